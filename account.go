@@ -0,0 +1,198 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Clone returns a copy of the account that shares no mutable state
+// with the original. Clone returns nil if a is nil.
+func (a *Account) Clone() *Account {
+	if a == nil {
+		return nil
+	}
+	acc := *a
+	if a.Extra != nil {
+		acc.Extra = make(map[string]string, len(a.Extra))
+		for k, v := range a.Extra {
+			acc.Extra[k] = v
+		}
+	}
+	if a.Scopes != nil {
+		acc.Scopes = append([]string{}, a.Scopes...)
+	}
+	return &acc
+}
+
+// AccountsEqual reports whether a and b hold the same account
+// information. Unlike reflect.DeepEqual, LastAuth times are compared
+// with time.Time.Equal, so times that represent the same instant are
+// equal regardless of their location or monotonic clock reading. A nil
+// Extra map is equal to an empty one, as is a nil Scopes slice. Two nil
+// accounts are equal.
+func AccountsEqual(a, b *Account) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Provider != b.Provider ||
+		a.OpenID != b.OpenID ||
+		a.Username != b.Username ||
+		a.DisplayName != b.DisplayName ||
+		a.Email != b.Email ||
+		a.IsVerified != b.IsVerified ||
+		!a.LastAuth.Equal(b.LastAuth) ||
+		len(a.Extra) != len(b.Extra) ||
+		len(a.Scopes) != len(b.Scopes) {
+		return false
+	}
+	for i, scope := range a.Scopes {
+		if b.Scopes[i] != scope {
+			return false
+		}
+	}
+	for k, v := range a.Extra {
+		if bv, ok := b.Extra[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// maxLastAuthAge is the age beyond which ValidateAccount considers an
+// account's LastAuth time to be stale.
+const maxLastAuthAge = 30 * 24 * time.Hour
+
+// ValidateAccount checks that the details in the given account are
+// plausible. An account must have an OpenID and a Provider, any email
+// address must be a valid RFC 5322 address and any LastAuth time must be
+// within the 30 days before the given time, which is usually the current
+// time. The returned error lists every problem found.
+func ValidateAccount(acc *Account, now time.Time) error {
+	var errs []error
+	if acc.OpenID == "" {
+		errs = append(errs, errgo.New("no openid"))
+	} else if acc.Provider == "" {
+		errs = append(errs, errgo.New("no provider"))
+	}
+	if acc.Email != "" {
+		if addr, err := mail.ParseAddress(acc.Email); err != nil || addr.Address != acc.Email {
+			errs = append(errs, errgo.Newf("invalid email address %q", acc.Email))
+		}
+	}
+	if !acc.LastAuth.IsZero() && now.Sub(acc.LastAuth) > maxLastAuthAge {
+		errs = append(errs, errgo.Newf("last authenticated at %s, more than 30 days ago", acc.LastAuth.UTC().Format(time.RFC3339)))
+	}
+	if len(errs) > 0 {
+		return errgo.Notef(errors.Join(errs...), "invalid account")
+	}
+	return nil
+}
+
+// fullAccount is the form in which an Account is encoded by
+// MarshalFull. Unlike the standard JSON encoding of an Account, which
+// matches the SSO discharge format, it includes every field.
+type fullAccount struct {
+	Provider    string            `json:"provider"`
+	OpenID      string            `json:"openid"`
+	Username    string            `json:"username"`
+	DisplayName string            `json:"displayname"`
+	Email       string            `json:"email"`
+	IsVerified  bool              `json:"is_verified"`
+	LastAuth    time.Time         `json:"last_auth"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Scopes      []string          `json:"scopes,omitempty"`
+}
+
+// MarshalFull encodes the account as JSON including all of its fields.
+// The standard JSON encoding omits the Provider, LastAuth, Extra and
+// Scopes fields because they are not part of the account caveat in an SSO
+// discharge. MarshalFull should be used when accounts need to be stored
+// or passed to other services, see UnmarshalFull.
+func (a *Account) MarshalFull() ([]byte, error) {
+	data, err := json.Marshal(fullAccount(*a))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
+
+// UnmarshalFull decodes an account encoded with MarshalFull.
+func UnmarshalFull(data []byte) (*Account, error) {
+	var fa fullAccount
+	if err := json.Unmarshal(data, &fa); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal account")
+	}
+	acc := Account(fa)
+	return &acc, nil
+}
+
+// yamlAccount is the form in which an Account is encoded as YAML. The
+// LastAuth time is encoded in the same format as the SSO last_auth
+// caveat.
+type yamlAccount struct {
+	Provider    string            `yaml:"provider"`
+	OpenID      string            `yaml:"openid"`
+	Username    string            `yaml:"username"`
+	DisplayName string            `yaml:"displayname"`
+	Email       string            `yaml:"email"`
+	IsVerified  bool              `yaml:"is_verified"`
+	LastAuth    string            `yaml:"last_auth,omitempty"`
+	Extra       map[string]string `yaml:"extra,omitempty"`
+	Scopes      []string          `yaml:"scopes,omitempty"`
+}
+
+// MarshalYAML implements yaml.Marshaler. LastAuth is encoded in UTC
+// using the same format as the SSO last_auth caveat, and is omitted if
+// it is zero.
+func (a Account) MarshalYAML() (interface{}, error) {
+	ya := yamlAccount{
+		Provider:    a.Provider,
+		OpenID:      a.OpenID,
+		Username:    a.Username,
+		DisplayName: a.DisplayName,
+		Email:       a.Email,
+		IsVerified:  a.IsVerified,
+		Extra:       a.Extra,
+		Scopes:      a.Scopes,
+	}
+	if !a.LastAuth.IsZero() {
+		ya.LastAuth = a.LastAuth.UTC().Format(timeFormat)
+	}
+	return ya, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (a *Account) UnmarshalYAML(value *yaml.Node) error {
+	var ya yamlAccount
+	if err := value.Decode(&ya); err != nil {
+		return err
+	}
+	var lastAuth time.Time
+	if ya.LastAuth != "" {
+		var err error
+		lastAuth, err = time.Parse(timeFormat, ya.LastAuth)
+		if err != nil {
+			return errgo.Notef(err, "invalid last_auth")
+		}
+	}
+	*a = Account{
+		Provider:    ya.Provider,
+		OpenID:      ya.OpenID,
+		Username:    ya.Username,
+		DisplayName: ya.DisplayName,
+		Email:       ya.Email,
+		IsVerified:  ya.IsVerified,
+		LastAuth:    lastAuth,
+		Extra:       ya.Extra,
+		Scopes:      ya.Scopes,
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestAccountClone(t *testing.T) {
+	c := qt.New(t)
+
+	acc := &ssoauth.Account{
+		Provider:   "login.example.com",
+		OpenID:     "AAAAAAA",
+		IsVerified: true,
+		LastAuth:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Extra:      map[string]string{"a": "1"},
+		Scopes:     []string{"admin"},
+	}
+	clone := acc.Clone()
+	c.Assert(clone, qt.DeepEquals, acc)
+	c.Assert(clone, qt.Not(qt.Equals), acc)
+
+	clone.Extra["b"] = "2"
+	acc.Extra["a"] = "3"
+	c.Check(acc.Extra, qt.DeepEquals, map[string]string{"a": "3"})
+	c.Check(clone.Extra, qt.DeepEquals, map[string]string{"a": "1", "b": "2"})
+	clone.Scopes[0] = "read-only"
+	c.Check(acc.Scopes, qt.DeepEquals, []string{"admin"})
+
+	c.Check((&ssoauth.Account{}).Clone().Extra, qt.IsNil)
+	c.Check((*ssoauth.Account)(nil).Clone(), qt.IsNil)
+}
+
+var accountsEqualTests = []struct {
+	about  string
+	a, b   *ssoauth.Account
+	expect bool
+}{{
+	about:  "both nil",
+	expect: true,
+}, {
+	about: "one nil",
+	a:     &ssoauth.Account{},
+}, {
+	about: "equal",
+	a: &ssoauth.Account{
+		Provider:    "login.example.com",
+		OpenID:      "AAAAAAA",
+		Username:    "bob",
+		DisplayName: "Bob Smith",
+		Email:       "bob@example.com",
+		IsVerified:  true,
+		LastAuth:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Extra:       map[string]string{"2fa": "true"},
+	},
+	b: &ssoauth.Account{
+		Provider:    "login.example.com",
+		OpenID:      "AAAAAAA",
+		Username:    "bob",
+		DisplayName: "Bob Smith",
+		Email:       "bob@example.com",
+		IsVerified:  true,
+		LastAuth:    time.Date(2020, 1, 2, 4, 4, 5, 0, time.FixedZone("test", 3600)),
+		Extra:       map[string]string{"2fa": "true"},
+	},
+	expect: true,
+}, {
+	about:  "monotonic clock reading",
+	a:      &ssoauth.Account{LastAuth: time.Unix(1577934245, 0)},
+	b:      &ssoauth.Account{LastAuth: time.Unix(1577934245, 0).Round(0)},
+	expect: true,
+}, {
+	about:  "nil and empty extra",
+	a:      &ssoauth.Account{Extra: map[string]string{}},
+	b:      &ssoauth.Account{},
+	expect: true,
+}, {
+	about: "different username",
+	a:     &ssoauth.Account{Username: "bob"},
+	b:     &ssoauth.Account{Username: "alice"},
+}, {
+	about: "different verified",
+	a:     &ssoauth.Account{IsVerified: true},
+	b:     &ssoauth.Account{},
+}, {
+	about: "different last auth",
+	a:     &ssoauth.Account{LastAuth: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	b:     &ssoauth.Account{LastAuth: time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)},
+}, {
+	about:  "nil and empty scopes",
+	a:      &ssoauth.Account{Scopes: []string{}},
+	b:      &ssoauth.Account{},
+	expect: true,
+}, {
+	about: "different scopes",
+	a:     &ssoauth.Account{Scopes: []string{"admin", "read-only"}},
+	b:     &ssoauth.Account{Scopes: []string{"read-only", "admin"}},
+}, {
+	about: "different extra",
+	a:     &ssoauth.Account{Extra: map[string]string{"a": ""}},
+	b:     &ssoauth.Account{Extra: map[string]string{"b": ""}},
+}}
+
+func TestAccountsEqual(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range accountsEqualTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Check(ssoauth.AccountsEqual(test.a, test.b), qt.Equals, test.expect)
+			c.Check(ssoauth.AccountsEqual(test.b, test.a), qt.Equals, test.expect)
+		})
+	}
+}
+
+// validateAccountNow is the current time used in validateAccountTests.
+var validateAccountNow = time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+var validateAccountTests = []struct {
+	name        string
+	acc         ssoauth.Account
+	expectError string
+}{{
+	name: "valid",
+	acc: ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+		Email:    "bob@example.com",
+		LastAuth: validateAccountNow.Add(-29 * 24 * time.Hour),
+	},
+}, {
+	name: "minimal",
+	acc: ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+	},
+}, {
+	name: "no-openid",
+	acc: ssoauth.Account{
+		Provider: "login.example.com",
+	},
+	expectError: `invalid account: no openid`,
+}, {
+	name: "no-provider",
+	acc: ssoauth.Account{
+		OpenID: "AAAAAAA",
+	},
+	expectError: `invalid account: no provider`,
+}, {
+	name: "invalid-email",
+	acc: ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+		Email:    "bob",
+	},
+	expectError: `invalid account: invalid email address "bob"`,
+}, {
+	name: "email-with-name",
+	acc: ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+		Email:    "Bob <bob@example.com>",
+	},
+	expectError: `invalid account: invalid email address "Bob <bob@example.com>"`,
+}, {
+	name: "stale-last-auth",
+	acc: ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+		LastAuth: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	},
+	expectError: `invalid account: last authenticated at 2020-01-02T03:04:05Z, more than 30 days ago`,
+}, {
+	name: "multiple",
+	acc: ssoauth.Account{
+		Email:    "bob@",
+		LastAuth: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	},
+	expectError: `invalid account: no openid
+invalid email address "bob@"
+last authenticated at 2020-01-02T03:04:05Z, more than 30 days ago`,
+}}
+
+func TestValidateAccount(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range validateAccountTests {
+		c.Run(test.name, func(c *qt.C) {
+			err := ssoauth.ValidateAccount(&test.acc, validateAccountNow)
+			if test.expectError == "" {
+				c.Check(err, qt.IsNil)
+				return
+			}
+			c.Check(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+func TestAccountMarshalFull(t *testing.T) {
+	c := qt.New(t)
+
+	acc := &ssoauth.Account{
+		Provider:    "login.example.com",
+		OpenID:      "AAAAAAA",
+		Username:    "bob",
+		DisplayName: "Bob Smith",
+		Email:       "bob@example.com",
+		IsVerified:  true,
+		LastAuth:    time.Date(2020, 1, 2, 3, 4, 5, 123456000, time.UTC),
+		Extra:       map[string]string{"2fa": "true"},
+		Scopes:      []string{"admin"},
+	}
+	data, err := acc.MarshalFull()
+	c.Assert(err, qt.IsNil)
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	c.Assert(err, qt.IsNil)
+	c.Check(fields["provider"], qt.Equals, "login.example.com")
+	c.Check(fields["last_auth"], qt.Equals, "2020-01-02T03:04:05.123456Z")
+
+	acc2, err := ssoauth.UnmarshalFull(data)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc2, ssoauthtest.AccountEquals, acc)
+}
+
+func TestUnmarshalFullError(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := ssoauth.UnmarshalFull([]byte("{"))
+	c.Check(err, qt.ErrorMatches, `cannot unmarshal account: unexpected end of JSON input`)
+}
+
+func TestAccountYAMLRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	acc := &ssoauth.Account{
+		Provider:    "login.example.com",
+		OpenID:      "AAAAAAA",
+		Username:    "bob",
+		DisplayName: "Bob Smith",
+		Email:       "bob@example.com",
+		IsVerified:  true,
+		LastAuth:    time.Date(2020, 1, 2, 3, 4, 5, 123456000, time.UTC),
+		Extra:       map[string]string{"2fa": "true"},
+	}
+	data, err := yaml.Marshal(acc)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(data), qt.Equals, `provider: login.example.com
+openid: AAAAAAA
+username: bob
+displayname: Bob Smith
+email: bob@example.com
+is_verified: true
+last_auth: 2020-01-02T03:04:05.123456
+extra:
+    2fa: "true"
+`)
+
+	var acc2 ssoauth.Account
+	err = yaml.Unmarshal(data, &acc2)
+	c.Assert(err, qt.IsNil)
+	c.Check(&acc2, ssoauthtest.AccountEquals, acc)
+}
+
+func TestAccountYAMLZeroLastAuth(t *testing.T) {
+	c := qt.New(t)
+
+	data, err := yaml.Marshal(ssoauth.Account{OpenID: "AAAAAAA"})
+	c.Assert(err, qt.IsNil)
+	c.Check(string(data), qt.Not(qt.Contains), "last_auth")
+
+	var acc ssoauth.Account
+	err = yaml.Unmarshal(data, &acc)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc, qt.DeepEquals, ssoauth.Account{OpenID: "AAAAAAA"})
+}
+
+func TestAccountYAMLInvalidLastAuth(t *testing.T) {
+	c := qt.New(t)
+
+	var acc ssoauth.Account
+	err := yaml.Unmarshal([]byte("openid: AAAAAAA\nlast_auth: yesterday\n"), &acc)
+	c.Check(err, qt.ErrorMatches, `invalid last_auth: parsing time "yesterday" .*`)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// An AuditLogger records the outcome of authentication attempts.
+type AuditLogger interface {
+	// LogAuthentication is called after every call to Authenticate
+	// with the account and error that Authenticate returns.
+	LogAuthentication(ctx context.Context, acc *Account, err error)
+}
+
+// NoopAuditLogger is an AuditLogger that discards every event.
+type NoopAuditLogger struct{}
+
+// LogAuthentication implements AuditLogger.
+func (NoopAuditLogger) LogAuthentication(context.Context, *Account, error) {}
+
+// JSONAuditLogger returns an AuditLogger that writes each event to w as
+// a line of JSON. Each event records the time, the result ("success",
+// "unauthorized" or "error"), any error message and the provider, OpenID
+// and username of the authenticated account. Personal details such as
+// the email address and display name are not recorded.
+func JSONAuditLogger(w io.Writer) AuditLogger {
+	return &jsonAuditLogger{
+		enc: json.NewEncoder(w),
+		now: time.Now,
+	}
+}
+
+// jsonAuditLogger is the AuditLogger returned from JSONAuditLogger.
+type jsonAuditLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// auditEvent is the JSON form of an event written by a jsonAuditLogger.
+type auditEvent struct {
+	Time     time.Time `json:"time"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	Provider string    `json:"provider,omitempty"`
+	OpenID   string    `json:"openid,omitempty"`
+	Username string    `json:"username,omitempty"`
+}
+
+// LogAuthentication implements AuditLogger.
+func (l *jsonAuditLogger) LogAuthentication(_ context.Context, acc *Account, err error) {
+	ev := auditEvent{
+		Time:   l.now().UTC(),
+		Result: authenticateResult(err),
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	if acc != nil {
+		ev.Provider = acc.Provider
+		ev.OpenID = acc.OpenID
+		ev.Username = acc.Username
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// There is nowhere to report a failure to write the event.
+	l.enc.Encode(ev)
+}
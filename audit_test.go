@@ -0,0 +1,118 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestAuthenticateWithAuditLogger(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var al recordingAuditLogger
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithAuditLogger(&al))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), ssoauthtest.NewAccount().WithOpenID("AAAAAAA").Build(), time.Time{}, time.Time{})
+	acc, err := a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M()})
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	c.Assert(al.events, qt.HasLen, 2)
+	c.Check(al.events[0].acc, qt.Equals, acc)
+	c.Check(al.events[0].err, qt.IsNil)
+	c.Check(al.events[1].acc, qt.IsNil)
+	c.Check(al.events[1].err, qt.Equals, err)
+}
+
+func TestJSONAuditLogger(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithAuditLogger(ssoauth.JSONAuditLogger(&buf)))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	acc := ssoauthtest.NewAccount().
+		WithOpenID("AAAAAAA").
+		WithUsername("bob").
+		WithDisplayName("Bob Smith").
+		WithEmail("bob@example.com").
+		Build()
+	_, err = a.Authenticate(ctx, ssoauthtest.MustDischarge(discharger, m.M(), acc, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M()})
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	c.Assert(lines, qt.HasLen, 2)
+	c.Check(lines[0], qt.Not(qt.Contains), "bob@example.com")
+	c.Check(lines[0], qt.Not(qt.Contains), "Bob Smith")
+
+	var events []map[string]interface{}
+	for _, line := range lines {
+		var ev map[string]interface{}
+		err := json.Unmarshal([]byte(line), &ev)
+		c.Assert(err, qt.IsNil)
+		_, err = time.Parse(time.RFC3339, ev["time"].(string))
+		c.Check(err, qt.IsNil)
+		delete(ev, "time")
+		events = append(events, ev)
+	}
+	c.Check(events, qt.DeepEquals, []map[string]interface{}{{
+		"result":   "success",
+		"provider": "login.example.com",
+		"openid":   "AAAAAAA",
+		"username": "bob",
+	}, {
+		"result": "unauthorized",
+		"error":  err.Error(),
+	}})
+}
+
+func TestNoopAuditLogger(t *testing.T) {
+	var al ssoauth.AuditLogger = ssoauth.NoopAuditLogger{}
+	al.LogAuthentication(context.Background(), nil, nil)
+}
+
+type auditEvent struct {
+	acc *ssoauth.Account
+	err error
+}
+
+type recordingAuditLogger struct {
+	mu     sync.Mutex
+	events []auditEvent
+}
+
+func (l *recordingAuditLogger) LogAuthentication(_ context.Context, acc *ssoauth.Account, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, auditEvent{acc, err})
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+// FormatCaveat formats an SSO first-party caveat with the given name and
+// value, addressed to the SSO server at the given location, in the form
+// "{location}|{name}|{value}".
+func FormatCaveat(location, name, value string) string {
+	return location + "|" + name + "|" + value
+}
+
+// ParseCaveat parses an SSO first-party caveat in the form created by
+// FormatCaveat. The value may itself contain "|" characters. If the
+// caveat is not in that form, or the location is empty, an error with
+// a cause of ErrUnsupportedCaveat is returned.
+func ParseCaveat(caveat string) (location, name, value string, err error) {
+	location, rest, ok := strings.Cut(caveat, "|")
+	if !ok || location == "" {
+		return "", "", "", errgo.WithCausef(nil, ErrUnsupportedCaveat, "")
+	}
+	name, value, ok = strings.Cut(rest, "|")
+	if !ok {
+		return "", "", "", errgo.WithCausef(nil, ErrUnsupportedCaveat, "")
+	}
+	return location, name, value, nil
+}
+
+// AttenuateMacaroon adds the given first-party caveats to the given
+// macaroon, restricting its use further. The caveats are checked by
+// Authenticate using the standard bakery checkers. Because adding a
+// caveat changes the macaroon's signature, the macaroon must be
+// attenuated before any discharge macaroons are bound to it. If any of
+// the caveats is a third-party caveat an error is returned and no
+// caveats are added.
+func AttenuateMacaroon(m *macaroon.Macaroon, caveats []checkers.Caveat) error {
+	for _, cav := range caveats {
+		if cav.Location != "" {
+			return errgo.Newf("cannot add third-party caveat %q", cav.Condition)
+		}
+	}
+	ns := checkers.New(nil).Namespace()
+	for _, cav := range caveats {
+		cav = ns.ResolveCaveat(cav)
+		if err := m.AddFirstPartyCaveat([]byte(cav.Condition)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// AddExpiresCaveat adds an SSO expires caveat to the given macaroon, in
+// the same form as the SSO server does, so that it is not valid at or
+// after the given time.
+func AddExpiresCaveat(m *macaroon.Macaroon, location string, t time.Time) error {
+	return errgo.Mask(addTimeCaveat(m, location, "expires", t))
+}
+
+// AddValidSinceCaveat adds an SSO valid_since caveat to the given
+// macaroon, in the same form as the SSO server does, so that it is not
+// valid at or before the given time.
+func AddValidSinceCaveat(m *macaroon.Macaroon, location string, t time.Time) error {
+	return errgo.Mask(addTimeCaveat(m, location, "valid_since", t))
+}
+
+// AddLastAuthCaveat adds an SSO last_auth caveat to the given macaroon,
+// in the same form as the SSO server does, recording that the user last
+// authenticated at the given time. The SSO server only adds one
+// last_auth caveat, so a macaroon with more than one is rejected.
+func AddLastAuthCaveat(m *macaroon.Macaroon, location string, t time.Time) error {
+	return errgo.Mask(addTimeCaveat(m, location, "last_auth", t))
+}
+
+// addTimeCaveat adds an SSO caveat with the given name and a time value.
+// SSO times are always in UTC and have microsecond precision.
+func addTimeCaveat(m *macaroon.Macaroon, location, name string, t time.Time) error {
+	return m.AddFirstPartyCaveat([]byte(FormatCaveat(location, name, t.UTC().Format(timeFormat))))
+}
+
+// AddIPRestrictionCaveat adds a first-party caveat to the given macaroon
+// that restricts its use to clients with an address in one of the given
+// CIDR ranges. The caveat is checked against the remote address stored
+// in the context with WithRemoteAddr.
+func AddIPRestrictionCaveat(m *macaroon.Macaroon, location string, allowedCIDRs []string) error {
+	if len(allowedCIDRs) == 0 {
+		return errgo.New("no allowed CIDRs specified")
+	}
+	for _, cidr := range allowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	cav := FormatCaveat(location, "allowed_ips", strings.Join(allowedCIDRs, ","))
+	return errgo.Mask(m.AddFirstPartyCaveat([]byte(cav)))
+}
+
+// AddScopesCaveat adds a first-party caveat to the given macaroon that
+// restricts the scopes granted to the account returned from
+// Authenticate to the given scopes. Because anyone holding a macaroon
+// can add first-party caveats a scopes caveat can never grant a scope,
+// the account is only granted the scopes in Params.Scopes that are
+// also in every scopes caveat. A scope must not be empty or contain a
+// comma.
+func AddScopesCaveat(m *macaroon.Macaroon, location string, scopes []string) error {
+	if len(scopes) == 0 {
+		return errgo.New("no scopes specified")
+	}
+	for _, scope := range scopes {
+		if scope == "" || strings.Contains(scope, ",") {
+			return errgo.Newf("invalid scope %q", scope)
+		}
+	}
+	cav := FormatCaveat(location, "scopes", strings.Join(scopes, ","))
+	return errgo.Mask(m.AddFirstPartyCaveat([]byte(cav)))
+}
+
+// AddAudienceCaveat adds a first-party caveat to the given macaroon that
+// binds it to the service at the given URL, so that it cannot be
+// replayed at another service. The caveat is checked by Authenticate
+// against the Audiences in the Params. Authenticators without Audiences
+// accept any audience.
+func AddAudienceCaveat(m *macaroon.Macaroon, location, serviceURL string) error {
+	if serviceURL == "" {
+		return errgo.New("no service URL specified")
+	}
+	cav := FormatCaveat(location, "audience", serviceURL)
+	return errgo.Mask(m.AddFirstPartyCaveat([]byte(cav)))
+}
+
+// parseScopes parses the comma separated scopes in the value of a
+// scopes caveat. Empty scopes are ignored.
+func parseScopes(s string) []string {
+	scopes := make([]string, 0, strings.Count(s, ",")+1)
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// intersectScopes returns the scopes in a that are also in b.
+func intersectScopes(a, b []string) []string {
+	scopes := make([]string, 0, len(a))
+	for _, scope := range a {
+		for _, bscope := range b {
+			if scope == bscope {
+				scopes = append(scopes, scope)
+				break
+			}
+		}
+	}
+	return scopes
+}
+
+// checkAllowedIPs checks that the given remote address is in one of the
+// comma separated CIDR ranges.
+func checkAllowedIPs(remoteAddr, cidrs string) error {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errgo.Newf("invalid remote address %q", remoteAddr)
+	}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if ipnet.Contains(ip) {
+			return nil
+		}
+	}
+	return errgo.Newf("remote address %q not allowed", remoteAddr)
+}
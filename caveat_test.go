@@ -0,0 +1,451 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+var ipRestrictionTests = []struct {
+	name        string
+	cidrs       []string
+	remoteAddr  string
+	expectError string
+}{{
+	name:       "match",
+	cidrs:      []string{"192.0.2.0/24", "10.0.0.0/8"},
+	remoteAddr: "10.1.2.3:4567",
+}, {
+	name:       "match-bare-ip",
+	cidrs:      []string{"2001:db8::/32"},
+	remoteAddr: "2001:db8::1",
+}, {
+	name:        "no-match",
+	cidrs:       []string{"192.0.2.0/24"},
+	remoteAddr:  "10.1.2.3:4567",
+	expectError: `caveat "login.example.com\|allowed_ips\|192.0.2.0/24" not satisfied: remote address "10.1.2.3:4567" not allowed`,
+}, {
+	name:        "no-remote-addr",
+	cidrs:       []string{"192.0.2.0/24"},
+	expectError: `caveat "login.example.com\|allowed_ips\|192.0.2.0/24" not satisfied: caveat not recognized`,
+}}
+
+func TestIPRestrictionCaveat(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+
+	for _, test := range ipRestrictionTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ctx := context.Background()
+			m, err := a.Macaroon(ctx)
+			c.Assert(err, qt.IsNil)
+			err = ssoauth.AddIPRestrictionCaveat(m.M(), discharger.Location(), test.cidrs)
+			c.Assert(err, qt.IsNil)
+			ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+
+			if test.remoteAddr != "" {
+				ctx = ssoauth.WithRemoteAddr(ctx, test.remoteAddr)
+			}
+			acc, err := a.Authenticate(ctx, ms)
+			if test.expectError != "" {
+				c.Check(err, qt.ErrorMatches, test.expectError)
+				c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+		})
+	}
+}
+
+func TestIPRestrictionCaveatChecker(t *testing.T) {
+	c := qt.New(t)
+
+	cav := discharger.Location() + "|allowed_ips|192.0.2.0/24"
+	check := ssoauth.CaveatChecker(context.Background(), []string{discharger.Location()}, nil)
+	c.Check(check(cav), qt.Equals, ssoauth.ErrUnsupportedCaveat)
+
+	check = ssoauth.CaveatChecker(ssoauth.WithRemoteAddr(context.Background(), "192.0.2.1"), []string{discharger.Location()}, nil)
+	c.Check(check(cav), qt.IsNil)
+	c.Check(check(discharger.Location()+"|allowed_ips|192.0.2.0"), qt.ErrorMatches, `caveat ".*" not satisfied: invalid CIDR address: 192.0.2.0`)
+}
+
+func TestAddIPRestrictionCaveatInvalidCIDR(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+
+	err = ssoauth.AddIPRestrictionCaveat(m.M(), discharger.Location(), []string{"192.0.2.0"})
+	c.Check(err, qt.ErrorMatches, `invalid CIDR address: 192.0.2.0`)
+	err = ssoauth.AddIPRestrictionCaveat(m.M(), discharger.Location(), nil)
+	c.Check(err, qt.ErrorMatches, `no allowed CIDRs specified`)
+}
+
+func TestAttenuateMacaroon(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	err = ssoauth.AttenuateMacaroon(m.M(), []checkers.Caveat{
+		checkers.TimeBeforeCaveat(time.Now().Add(time.Hour)),
+	})
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+	_, err = a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+
+	m, err = a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	err = ssoauth.AttenuateMacaroon(m.M(), []checkers.Caveat{
+		checkers.TimeBeforeCaveat(time.Now().Add(-time.Hour)),
+	})
+	c.Assert(err, qt.IsNil)
+	ms = ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+	_, err = a.Authenticate(ctx, ms)
+	c.Check(err, qt.ErrorMatches, `caveat "time-before .*" not satisfied: macaroon has expired`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+}
+
+func TestAttenuateMacaroonThirdPartyCaveat(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+
+	ncav := len(m.M().Caveats())
+	err = ssoauth.AttenuateMacaroon(m.M(), []checkers.Caveat{
+		checkers.TimeBeforeCaveat(time.Now().Add(time.Hour)),
+		{
+			Location:  "https://third-party.example.com",
+			Condition: "is-authenticated-user",
+		},
+	})
+	c.Check(err, qt.ErrorMatches, `cannot add third-party caveat "is-authenticated-user"`)
+	// The first-party caveat before the third-party caveat has not
+	// been added.
+	c.Check(m.M().Caveats(), qt.HasLen, ncav)
+}
+
+var scopesTests = []struct {
+	name         string
+	grant        []string
+	caveats      []string
+	expectScopes []string
+}{{
+	name: "no-grant",
+}, {
+	name:         "no-grant-with-caveat",
+	caveats:      []string{"admin"},
+	expectScopes: []string{},
+}, {
+	name:         "no-scopes-caveat",
+	grant:        []string{"admin", "read-only"},
+	expectScopes: []string{"admin", "read-only"},
+}, {
+	name:         "scopes",
+	grant:        []string{"admin", "read-only"},
+	caveats:      []string{"admin,read-only"},
+	expectScopes: []string{"admin", "read-only"},
+}, {
+	name:         "cannot-grant",
+	grant:        []string{"read-only"},
+	caveats:      []string{"admin,read-only"},
+	expectScopes: []string{"read-only"},
+}, {
+	name:         "restricted",
+	grant:        []string{"admin", "read-only", "other"},
+	caveats:      []string{"admin,read-only", "read-only,other"},
+	expectScopes: []string{"read-only"},
+}, {
+	name:         "disjoint",
+	grant:        []string{"admin", "read-only"},
+	caveats:      []string{"admin", "read-only"},
+	expectScopes: []string{},
+}}
+
+func TestScopesCaveat(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range scopesTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			a := ssoauth.New(ssoauth.Params{
+				Oven:      bakery.NewOven(bakery.OvenParams{}),
+				PublicKey: discharger.PublicKey(),
+				Location:  discharger.Location(),
+				Scopes:    test.grant,
+			})
+			ctx := context.Background()
+			m, err := a.Macaroon(ctx)
+			c.Assert(err, qt.IsNil)
+			caveatID := ssoauthtest.MustGetCaveatID(discharger, m.M())
+			d, err := discharger.Discharge(caveatID, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+			c.Assert(err, qt.IsNil)
+			for _, cav := range test.caveats {
+				err := d.AddFirstPartyCaveat([]byte(discharger.Location() + "|scopes|" + cav))
+				c.Assert(err, qt.IsNil)
+			}
+			d.Bind(m.M().Signature())
+
+			acc, err := a.Authenticate(ctx, macaroon.Slice{m.M(), d})
+			c.Assert(err, qt.IsNil)
+			c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+			c.Check(acc.Scopes, qt.DeepEquals, test.expectScopes)
+			if !slices.Contains(test.grant, "admin") {
+				// A scopes caveat added by the client must
+				// not be able to grant a scope.
+				c.Check(ssoauth.RequireAnyScope(acc, "admin"), qt.ErrorIs, ssoauth.ErrUnauthorized)
+			}
+		})
+	}
+}
+
+func TestAddScopesCaveat(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+		Scopes:    []string{"admin", "read-only", "other"},
+	})
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	err = ssoauth.AddScopesCaveat(m.M(), discharger.Location(), []string{"admin", "read-only"})
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+	acc, err := a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.Scopes, qt.DeepEquals, []string{"admin", "read-only"})
+
+	err = ssoauth.AddScopesCaveat(m.M(), discharger.Location(), nil)
+	c.Check(err, qt.ErrorMatches, `no scopes specified`)
+	err = ssoauth.AddScopesCaveat(m.M(), discharger.Location(), []string{"a,b"})
+	c.Check(err, qt.ErrorMatches, `invalid scope "a,b"`)
+	err = ssoauth.AddScopesCaveat(m.M(), discharger.Location(), []string{""})
+	c.Check(err, qt.ErrorMatches, `invalid scope ""`)
+}
+
+func TestScopesCaveatChecker(t *testing.T) {
+	c := qt.New(t)
+
+	var acc ssoauth.Account
+	check := ssoauth.CaveatChecker(context.Background(), []string{discharger.Location()}, &acc)
+	c.Check(check(discharger.Location()+"|scopes|a,b"), qt.IsNil)
+	c.Check(acc.Scopes, qt.DeepEquals, []string{})
+	c.Check(check(discharger.Location()+"|scopes"), qt.ErrorMatches, `malformed caveat ".*"`)
+}
+
+var audienceTests = []struct {
+	name        string
+	audiences   []string
+	serviceURLs []string
+	expectError string
+}{{
+	name:        "accepted",
+	audiences:   []string{"https://a.example.com", "https://b.example.com"},
+	serviceURLs: []string{"https://b.example.com"},
+}, {
+	name:        "wrong-audience",
+	audiences:   []string{"https://a.example.com"},
+	serviceURLs: []string{"https://b.example.com"},
+	expectError: `caveat "login.example.com\|audience\|https://b.example.com" not satisfied`,
+}, {
+	name:        "one-of-several-wrong",
+	audiences:   []string{"https://a.example.com"},
+	serviceURLs: []string{"https://a.example.com", "https://b.example.com"},
+	expectError: `caveat "login.example.com\|audience\|https://b.example.com" not satisfied`,
+}, {
+	name:        "missing-caveat",
+	audiences:   []string{"https://a.example.com"},
+	expectError: `no audience caveat`,
+}, {
+	name:        "no-audiences",
+	serviceURLs: []string{"https://b.example.com"},
+}, {
+	name: "no-audiences-no-caveat",
+}}
+
+func TestAudienceCaveat(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range audienceTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ctx := context.Background()
+			a := ssoauth.New(ssoauth.Params{
+				Oven:      bakery.NewOven(bakery.OvenParams{}),
+				PublicKey: discharger.PublicKey(),
+				Location:  discharger.Location(),
+				Audiences: test.audiences,
+			})
+			m, err := a.Macaroon(ctx)
+			c.Assert(err, qt.IsNil)
+			for _, u := range test.serviceURLs {
+				err := ssoauth.AddAudienceCaveat(m.M(), discharger.Location(), u)
+				c.Assert(err, qt.IsNil)
+			}
+			ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+			acc, err := a.Authenticate(ctx, ms)
+			if test.expectError != "" {
+				c.Check(err, qt.ErrorMatches, test.expectError)
+				c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+				c.Check(acc, qt.IsNil)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+		})
+	}
+}
+
+func TestAddAudienceCaveat(t *testing.T) {
+	c := qt.New(t)
+
+	m, err := macaroon.New([]byte("root key"), []byte("id"), "", macaroon.V2)
+	c.Assert(err, qt.IsNil)
+	err = ssoauth.AddAudienceCaveat(m, "login.example.com", "https://a.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(m.Caveats(), qt.HasLen, 1)
+	c.Check(string(m.Caveats()[0].Id), qt.Equals, "login.example.com|audience|https://a.example.com")
+
+	err = ssoauth.AddAudienceCaveat(m, "login.example.com", "")
+	c.Check(err, qt.ErrorMatches, `no service URL specified`)
+}
+
+var parseCaveatTests = []struct {
+	caveat         string
+	expectLocation string
+	expectName     string
+	expectValue    string
+	expectError    string
+}{{
+	caveat:      "",
+	expectError: `unsupported caveat`,
+}, {
+	caveat:      "login.example.com",
+	expectError: `unsupported caveat`,
+}, {
+	caveat:      "login.example.com|expires",
+	expectError: `unsupported caveat`,
+}, {
+	caveat:      "|expires|2020-01-01T00:00:00.000000",
+	expectError: `unsupported caveat`,
+}, {
+	caveat:         "login.example.com|expires|2020-01-01T00:00:00.000000",
+	expectLocation: "login.example.com",
+	expectName:     "expires",
+	expectValue:    "2020-01-01T00:00:00.000000",
+}, {
+	caveat:         "login.example.com|nonce|",
+	expectLocation: "login.example.com",
+	expectName:     "nonce",
+}, {
+	caveat:         "login.example.com||value",
+	expectLocation: "login.example.com",
+	expectValue:    "value",
+}, {
+	caveat:         "login.example.com|extra|a|b|c",
+	expectLocation: "login.example.com",
+	expectName:     "extra",
+	expectValue:    "a|b|c",
+}}
+
+func TestParseCaveat(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range parseCaveatTests {
+		location, name, value, err := ssoauth.ParseCaveat(test.caveat)
+		if test.expectError != "" {
+			c.Check(err, qt.ErrorMatches, test.expectError, qt.Commentf("%q", test.caveat))
+			c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnsupportedCaveat)
+			continue
+		}
+		c.Assert(err, qt.IsNil, qt.Commentf("%q", test.caveat))
+		c.Check(location, qt.Equals, test.expectLocation)
+		c.Check(name, qt.Equals, test.expectName)
+		c.Check(value, qt.Equals, test.expectValue)
+
+		// Formatting the parsed caveat gives back the original.
+		c.Check(ssoauth.FormatCaveat(location, name, value), qt.Equals, test.caveat)
+	}
+}
+
+func TestTimeCaveatsRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	lastAuth := time.Date(2020, 1, 1, 12, 30, 0, 123456789, time.UTC)
+	expires := time.Date(2100, 1, 2, 0, 0, 0, 0, time.FixedZone("UTC+1", 3600))
+	validSince := time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	m, err := macaroon.New([]byte("root key"), []byte("id"), "", macaroon.V2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ssoauth.AddExpiresCaveat(m, "login.example.com", expires), qt.IsNil)
+	c.Assert(ssoauth.AddValidSinceCaveat(m, "login.example.com", validSince), qt.IsNil)
+	c.Assert(ssoauth.AddLastAuthCaveat(m, "login.example.com", lastAuth), qt.IsNil)
+
+	var ids []string
+	for _, cav := range m.Caveats() {
+		ids = append(ids, string(cav.Id))
+	}
+	c.Check(ids, qt.DeepEquals, []string{
+		"login.example.com|expires|2100-01-01T23:00:00.000000",
+		"login.example.com|valid_since|2019-12-31T00:00:00.000000",
+		"login.example.com|last_auth|2020-01-01T12:30:00.123456",
+	})
+
+	var acc ssoauth.Account
+	check := ssoauth.CaveatChecker(context.Background(), []string{"login.example.com"}, &acc)
+	for _, id := range ids {
+		c.Check(check(id), qt.IsNil, qt.Commentf("%s", id))
+	}
+	c.Check(acc.LastAuth, qt.Equals, lastAuth.Truncate(time.Microsecond))
+
+	// The checker enforces the times encoded by the helpers.
+	m, err = macaroon.New([]byte("root key"), []byte("id"), "", macaroon.V2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ssoauth.AddExpiresCaveat(m, "login.example.com", time.Now().Add(-time.Minute)), qt.IsNil)
+	c.Assert(ssoauth.AddValidSinceCaveat(m, "login.example.com", time.Now().Add(time.Minute)), qt.IsNil)
+	err = check(string(m.Caveats()[0].Id))
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrExpired)
+	err = check(string(m.Caveats()[1].Id))
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrNotYetValid)
+}
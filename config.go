@@ -0,0 +1,96 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"encoding"
+	"os"
+	"reflect"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+)
+
+// Config holds the configuration of an Authenticator in a form that can
+// be read from a JSON file or from environment variables, see
+// LoadConfigFromEnv.
+type Config struct {
+	// Location contains the Ubuntu SSO location that the macaroons
+	// are addressed to.
+	Location string `json:"location" env:"SSO_LOCATION"`
+
+	// PublicKeyPEM contains the PEM encoded RSA public key of the
+	// Ubuntu SSO server, in either of the forms accepted by
+	// ParsePublicKey.
+	PublicKeyPEM string `json:"public_key_pem" env:"SSO_PUBLIC_KEY_PEM"`
+
+	// Expiry contains the duration for which minted macaroons are
+	// valid. If this is zero then a default of seven days is used.
+	Expiry Duration `json:"expiry" env:"SSO_EXPIRY"`
+}
+
+// A Duration is a time.Duration that is encoded as text in the format
+// accepted by time.ParseDuration, for example "168h".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ToParams creates the Params described by the Config, using the given
+// oven to issue macaroons. The returned Params are not validated.
+func (c Config) ToParams(oven *bakery.Oven) (Params, error) {
+	pk, err := ParsePublicKey([]byte(c.PublicKeyPEM))
+	if err != nil {
+		return Params{}, errgo.Notef(err, "cannot parse public key")
+	}
+	return Params{
+		Oven:      oven,
+		Location:  c.Location,
+		PublicKey: pk,
+		Expiry:    time.Duration(c.Expiry),
+	}, nil
+}
+
+// LoadConfigFromEnv creates a Config from the environment variables
+// named in the env tags of its fields. Fields whose variable is unset or
+// empty are left as the zero value.
+func LoadConfigFromEnv() (Config, error) {
+	var c Config
+	v := reflect.ValueOf(&c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		switch f := v.Field(i).Addr().Interface().(type) {
+		case encoding.TextUnmarshaler:
+			if err := f.UnmarshalText([]byte(s)); err != nil {
+				return Config{}, errgo.Notef(err, "invalid %s", name)
+			}
+		case *string:
+			*f = s
+		default:
+			panic(errgo.Newf("unsupported config field type %T", f))
+		}
+	}
+	return c, nil
+}
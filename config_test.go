@@ -0,0 +1,100 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/ssoauth"
+)
+
+func publicKeyPEM(c *qt.C) string {
+	b, err := x509.MarshalPKIXPublicKey(discharger.PublicKey())
+	c.Assert(err, qt.IsNil)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	c := qt.New(t)
+
+	c.Setenv("SSO_LOCATION", "login.example.com")
+	c.Setenv("SSO_PUBLIC_KEY_PEM", publicKeyPEM(c))
+	c.Setenv("SSO_EXPIRY", "24h")
+
+	cfg, err := ssoauth.LoadConfigFromEnv()
+	c.Assert(err, qt.IsNil)
+	c.Check(cfg.Location, qt.Equals, "login.example.com")
+	c.Check(cfg.Expiry, qt.Equals, ssoauth.Duration(24*time.Hour))
+
+	oven := bakery.NewOven(bakery.OvenParams{})
+	p, err := cfg.ToParams(oven)
+	c.Assert(err, qt.IsNil)
+	c.Check(p.Oven, qt.Equals, oven)
+	c.Check(p.Location, qt.Equals, "login.example.com")
+	c.Check(p.PublicKey.Equal(discharger.PublicKey()), qt.IsTrue)
+	c.Check(p.Expiry, qt.Equals, 24*time.Hour)
+	c.Check(p.Validate(), qt.IsNil)
+}
+
+func TestLoadConfigFromEnvUnset(t *testing.T) {
+	c := qt.New(t)
+
+	c.Setenv("SSO_LOCATION", "")
+	c.Setenv("SSO_PUBLIC_KEY_PEM", "")
+	c.Setenv("SSO_EXPIRY", "")
+
+	cfg, err := ssoauth.LoadConfigFromEnv()
+	c.Assert(err, qt.IsNil)
+	c.Check(cfg, qt.Equals, ssoauth.Config{})
+}
+
+func TestLoadConfigFromEnvInvalidExpiry(t *testing.T) {
+	c := qt.New(t)
+
+	c.Setenv("SSO_EXPIRY", "a week")
+
+	_, err := ssoauth.LoadConfigFromEnv()
+	c.Check(err, qt.ErrorMatches, `invalid SSO_EXPIRY: time: invalid duration "a week"`)
+}
+
+func TestConfigToParamsInvalidPublicKey(t *testing.T) {
+	c := qt.New(t)
+
+	cfg := ssoauth.Config{
+		Location:     "login.example.com",
+		PublicKeyPEM: "not a key",
+	}
+	_, err := cfg.ToParams(bakery.NewOven(bakery.OvenParams{}))
+	c.Check(err, qt.ErrorMatches, `cannot parse public key: no PEM data found`)
+}
+
+func TestConfigJSON(t *testing.T) {
+	c := qt.New(t)
+
+	cfg := ssoauth.Config{
+		Location:     "login.example.com",
+		PublicKeyPEM: publicKeyPEM(c),
+		Expiry:       ssoauth.Duration(90 * time.Minute),
+	}
+	b, err := json.Marshal(cfg)
+	c.Assert(err, qt.IsNil)
+
+	var m map[string]interface{}
+	err = json.Unmarshal(b, &m)
+	c.Assert(err, qt.IsNil)
+	c.Check(m["location"], qt.Equals, "login.example.com")
+	c.Check(m["expiry"], qt.Equals, "1h30m0s")
+
+	var cfg2 ssoauth.Config
+	err = json.Unmarshal(b, &cfg2)
+	c.Assert(err, qt.IsNil)
+	c.Check(cfg2, qt.Equals, cfg)
+}
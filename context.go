@@ -0,0 +1,36 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import "context"
+
+type accountKey struct{}
+
+// WithAccount returns a copy of the given context which stores the
+// given Account. The account can be retrieved using AccountFromContext.
+func WithAccount(ctx context.Context, acc *Account) context.Context {
+	return context.WithValue(ctx, accountKey{}, acc)
+}
+
+// AccountFromContext retrieves the Account stored in the given context
+// by WithAccount, if any.
+func AccountFromContext(ctx context.Context) (*Account, bool) {
+	acc, ok := ctx.Value(accountKey{}).(*Account)
+	return acc, ok && acc != nil
+}
+
+type remoteAddrKey struct{}
+
+// WithRemoteAddr returns a copy of the given context which stores the
+// given client address. The address may either be a bare IP address or
+// of the form "host:port" as found in http.Request.RemoteAddr. It is
+// used to check caveats added with AddIPRestrictionCaveat.
+func WithRemoteAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, remoteAddrKey{}, addr)
+}
+
+func remoteAddrFromContext(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(remoteAddrKey{}).(string)
+	return addr, ok && addr != ""
+}
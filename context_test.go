@@ -0,0 +1,43 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+)
+
+func TestAccountContextRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	acc := &ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+	}
+	ctx := ssoauth.WithAccount(context.Background(), acc)
+	acc2, ok := ssoauth.AccountFromContext(ctx)
+	c.Check(ok, qt.Equals, true)
+	c.Check(acc2, qt.Equals, acc)
+}
+
+func TestAccountFromContextNoAccount(t *testing.T) {
+	c := qt.New(t)
+
+	acc, ok := ssoauth.AccountFromContext(context.Background())
+	c.Check(ok, qt.Equals, false)
+	c.Check(acc, qt.IsNil)
+}
+
+func TestAccountFromContextNilAccount(t *testing.T) {
+	c := qt.New(t)
+
+	ctx := ssoauth.WithAccount(context.Background(), nil)
+	acc, ok := ssoauth.AccountFromContext(ctx)
+	c.Check(ok, qt.Equals, false)
+	c.Check(acc, qt.IsNil)
+}
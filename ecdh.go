@@ -0,0 +1,87 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+	errgo "gopkg.in/errgo.v1"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+// ecdhKeyInfo is the HKDF info used to derive the key that wraps the
+// root key in a version 2 caveat ID.
+const ecdhKeyInfo = "ssoauth third-party caveat v2"
+
+// AddThirdPartyCaveatECDH adds a third-party caveat to the given
+// macaroon in the same way as AddThirdPartyCaveat, except that the root
+// key is encrypted for an X25519 public key rather than an RSA one.
+//
+// The caveat ID is a JSON object with a version of 2. An ephemeral
+// X25519 key pair is generated for each caveat, and its public key is
+// included in the caveat ID as "public_key". The key shared between the
+// ephemeral key and pk is passed through HKDF-SHA256, salted with the
+// ephemeral public key followed by pk, to derive an AES-256-GCM key
+// which encrypts the root key. The "secret" field holds the GCM nonce
+// followed by the encrypted root key. All binary values are encoded
+// using standard base64.
+func AddThirdPartyCaveatECDH(m *macaroon.Macaroon, rootKey []byte, location string, pk *ecdh.PublicKey) error {
+	if pk == nil || pk.Curve() != ecdh.X25519() {
+		return errgo.New("public key is not an X25519 key")
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	shared, err := ephemeral.ECDH(pk)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	aead, err := ecdhCaveatAEAD(shared, ephemeral.PublicKey(), pk)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(rootKey)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return errgo.Mask(err)
+	}
+	var cid = struct {
+		Secret    string `json:"secret"`
+		PublicKey string `json:"public_key"`
+		Version   int    `json:"version"`
+	}{
+		Secret:    base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, rootKey, nil)),
+		PublicKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Version:   2,
+	}
+	caveatID, err := json.Marshal(cid)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(m.AddThirdPartyCaveat(rootKey, caveatID, location))
+}
+
+// ecdhCaveatAEAD creates the AEAD used to encrypt the root key in a
+// version 2 caveat ID from the given shared secret, ephemeral public key
+// and recipient public key.
+func ecdhCaveatAEAD(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(append([]byte(nil), ephemeral.Bytes()...), recipient.Bytes()...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(ecdhKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
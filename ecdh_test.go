@@ -0,0 +1,93 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestAuthenticateECDH(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := new(ssoauthtest.Discharger)
+	a := ssoauth.New(ssoauth.Params{
+		Oven:          bakery.NewOven(bakery.OvenParams{}),
+		ECDHPublicKey: d.ECDHPublicKey(),
+		Location:      d.Location(),
+	})
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	caveatID, err := ssoauthtest.GetCaveatID(d, m.M())
+	c.Assert(err, qt.IsNil)
+	var cid struct {
+		PublicKey string `json:"public_key"`
+		Version   int    `json:"version"`
+	}
+	err = json.Unmarshal(caveatID, &cid)
+	c.Assert(err, qt.IsNil)
+	c.Check(cid.Version, qt.Equals, 2)
+	c.Check(cid.PublicKey, qt.Not(qt.Equals), "")
+
+	acc := &ssoauth.Account{
+		Provider: d.Location(),
+		OpenID:   "AAAAAAA",
+		Username: "test",
+	}
+	ms := ssoauthtest.MustDischarge(d, m.M(), acc, time.Time{}, time.Time{})
+	gotAcc, err := a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(gotAcc, ssoauthtest.AccountEquals, acc)
+}
+
+func TestAuthenticateECDHWrongKey(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := new(ssoauthtest.Discharger)
+	a := ssoauth.New(ssoauth.Params{
+		Oven:          bakery.NewOven(bakery.OvenParams{}),
+		ECDHPublicKey: d.ECDHPublicKey(),
+		Location:      d.Location(),
+	})
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	d.Reset()
+	caveatID, err := ssoauthtest.GetCaveatID(d, m.M())
+	c.Assert(err, qt.IsNil)
+	_, err = d.Discharge(caveatID, nil, time.Time{}, time.Time{})
+	c.Check(err, qt.ErrorMatches, `cannot decrypt secret`)
+}
+
+func TestAddThirdPartyCaveatECDHInvalidKey(t *testing.T) {
+	c := qt.New(t)
+
+	m, err := macaroon.New([]byte("root key"), []byte("id"), "", macaroon.V2)
+	c.Assert(err, qt.IsNil)
+
+	err = ssoauth.AddThirdPartyCaveatECDH(m, []byte("caveat key"), "login.example.com", nil)
+	c.Check(err, qt.ErrorMatches, `public key is not an X25519 key`)
+
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	c.Assert(err, qt.IsNil)
+	err = ssoauth.AddThirdPartyCaveatECDH(m, []byte("caveat key"), "login.example.com", key.PublicKey())
+	c.Check(err, qt.ErrorMatches, `public key is not an X25519 key`)
+	c.Check(m.Caveats(), qt.HasLen, 0)
+}
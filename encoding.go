@@ -0,0 +1,75 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	errgo "gopkg.in/errgo.v1"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+// MarshalMacaroonSlice encodes the given macaroon slice for transport
+// over HTTP. The macaroons are encoded in the standard binary format,
+// which is then encoded as URL-safe base64 without padding.
+//
+// The encoded value is expected to be sent in an "Authorization"
+// header using the "Macaroon" scheme (for example "Authorization:
+// Macaroon <encoded>"), or as the value of the cookie named in
+// Params.MacaroonCookieName.
+func MarshalMacaroonSlice(ms macaroon.Slice) ([]byte, error) {
+	b, err := ms.MarshalBinary()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	data := make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))
+	base64.RawURLEncoding.Encode(data, b)
+	return data, nil
+}
+
+// UnmarshalMacaroonSlice decodes a macaroon slice encoded with
+// MarshalMacaroonSlice. For compatibility with other clients the
+// standard base64 alphabet and padded encodings are also accepted.
+func UnmarshalMacaroonSlice(data []byte) (macaroon.Slice, error) {
+	s := strings.TrimRight(string(data), "=")
+	enc := base64.RawURLEncoding
+	if strings.ContainsAny(s, "+/") {
+		enc = base64.RawStdEncoding
+	}
+	b, err := enc.DecodeString(s)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var ms macaroon.Slice
+	if err := ms.UnmarshalBinary(b); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return ms, nil
+}
+
+// MacaroonFingerprint returns the SHA-256 hash of the binary encoding of
+// the given macaroon slice. The fingerprint is stable for a given slice
+// and is suitable for use as a cache key for authentication results.
+func MacaroonFingerprint(ms macaroon.Slice) ([]byte, error) {
+	b, err := ms.MarshalBinary()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// RootMacaroonID returns the ID of the root macaroon in the given slice,
+// which is unique to each macaroon minted by an Authenticator. It is a
+// cheaper cache key than MacaroonFingerprint, but the same ID is shared
+// by every slice containing the same root macaroon, however it was
+// discharged.
+func RootMacaroonID(ms macaroon.Slice) ([]byte, error) {
+	if len(ms) == 0 {
+		return nil, errgo.New("no macaroons")
+	}
+	return ms[0].Id(), nil
+}
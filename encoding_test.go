@@ -0,0 +1,99 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/ssoauth"
+)
+
+func TestMacaroonSliceRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	ms := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+	c.Assert(ms, qt.HasLen, 2)
+
+	data, err := ssoauth.MarshalMacaroonSlice(ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(data), qt.Not(qt.Matches), `.*[+/=].*`)
+
+	ms2, err := ssoauth.UnmarshalMacaroonSlice(data)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ms2, qt.HasLen, 2)
+
+	b1, err := ms.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	b2, err := ms2.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Check(b2, qt.DeepEquals, b1)
+}
+
+func TestUnmarshalMacaroonSliceStdEncoding(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	ms := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+	b, err := ms.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+
+	ms2, err := ssoauth.UnmarshalMacaroonSlice([]byte(base64.StdEncoding.EncodeToString(b)))
+	c.Assert(err, qt.IsNil)
+	b2, err := ms2.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Check(b2, qt.DeepEquals, b)
+}
+
+func TestUnmarshalMacaroonSliceInvalid(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := ssoauth.UnmarshalMacaroonSlice([]byte("@@@@"))
+	c.Check(err, qt.ErrorMatches, `illegal base64 data at input byte 0`)
+}
+
+func TestMacaroonFingerprint(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	ms1 := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+	ms2 := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+
+	fp1, err := ssoauth.MacaroonFingerprint(ms1)
+	c.Assert(err, qt.IsNil)
+	c.Check(fp1, qt.HasLen, 32)
+	fp, err := ssoauth.MacaroonFingerprint(ms1)
+	c.Assert(err, qt.IsNil)
+	c.Check(fp, qt.DeepEquals, fp1)
+	fp2, err := ssoauth.MacaroonFingerprint(ms2)
+	c.Assert(err, qt.IsNil)
+	c.Check(fp2, qt.Not(qt.DeepEquals), fp1)
+
+	id1, err := ssoauth.RootMacaroonID(ms1)
+	c.Assert(err, qt.IsNil)
+	c.Check(id1, qt.DeepEquals, ms1[0].Id())
+	id2, err := ssoauth.RootMacaroonID(ms2)
+	c.Assert(err, qt.IsNil)
+	c.Check(id2, qt.Not(qt.DeepEquals), id1)
+
+	_, err = ssoauth.RootMacaroonID(nil)
+	c.Check(err, qt.ErrorMatches, `no macaroons`)
+}
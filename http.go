@@ -0,0 +1,161 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	errgo "gopkg.in/errgo.v1"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+// Middleware returns an http.Handler that authenticates every request
+// using the given Authenticator before passing it to next. The
+// macaroons are read from an "Authorization: Macaroon <base64>" header
+// or, if that is not present and Params.MacaroonCookieName is set,
+// from the named cookie. The authenticated Account is stored in the
+// request context and can be retrieved with AccountFromContext.
+//
+// If the request cannot be authenticated a JSON error response is
+// written with the status 401 Unauthorized. Any other error results in
+// a 500 Internal Server Error response that does not describe the
+// error, which is logged instead.
+func Middleware(a *Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		acc, err := a.AuthenticateFromRequest(req)
+		if err != nil {
+			a.writeError(w, req, err)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(WithAccount(req.Context(), acc)))
+	})
+}
+
+// AccountFromRequest retrieves the Account stored in the context of the
+// given request by Middleware, if any.
+func AccountFromRequest(req *http.Request) (*Account, bool) {
+	return AccountFromContext(req.Context())
+}
+
+// MustAccountFromRequest is like AccountFromRequest except that it
+// panics if the request has no Account. It is intended for use in
+// handlers that are only reachable through Middleware.
+func MustAccountFromRequest(req *http.Request) *Account {
+	acc, ok := AccountFromRequest(req)
+	if !ok {
+		panic("ssoauth: no account in request context")
+	}
+	return acc
+}
+
+// AuthenticateFromRequest authenticates the macaroons in the given HTTP
+// request, in the same way as Middleware. The macaroons are read from
+// an "Authorization: Macaroon <base64>" header or, if that is not
+// present and Params.MacaroonCookieName is set, from the named cookie.
+// If the request holds no valid macaroons then an error with a cause of
+// ErrUnauthorized is returned.
+func (a *Authenticator) AuthenticateFromRequest(req *http.Request) (*Account, error) {
+	ms, err := a.macaroonsFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return a.Authenticate(WithRemoteAddr(req.Context(), req.RemoteAddr), ms)
+}
+
+func (a *Authenticator) macaroonsFromRequest(req *http.Request) (macaroon.Slice, error) {
+	var data string
+	if h := req.Header.Get("Authorization"); h != "" {
+		parts := strings.SplitN(h, " ", 2)
+		if len(parts) != 2 || parts[0] != "Macaroon" {
+			return nil, unauthorizedf(nil, "unsupported authorization scheme")
+		}
+		data = parts[1]
+	} else if a.p.MacaroonCookieName != "" {
+		if c, err := req.Cookie(a.p.MacaroonCookieName); err == nil {
+			data = c.Value
+		}
+	}
+	if data == "" {
+		return nil, unauthorizedf(nil, "no macaroon")
+	}
+	ms, err := UnmarshalMacaroonSlice([]byte(data))
+	if err != nil {
+		return nil, unauthorizedf(err, "cannot parse macaroon")
+	}
+	return ms, nil
+}
+
+// RedirectHandler returns an http.Handler that mints a new macaroon and
+// redirects the client to the SSO server to discharge it. The macaroon
+// is sent in the "macaroon" query parameter, in binary format encoded as
+// URL-safe base64 without padding, along with the given returnURL in the
+// "return_to" query parameter. The redirect is sent to the first
+// configured location, using https unless the location specifies a
+// scheme.
+func (a *Authenticator) RedirectHandler(returnURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		u, err := a.redirectURL(req.Context(), returnURL)
+		if err != nil {
+			a.writeError(w, req, err)
+			return
+		}
+		http.Redirect(w, req, u, http.StatusFound)
+	})
+}
+
+func (a *Authenticator) redirectURL(ctx context.Context, returnURL string) (string, error) {
+	m, err := a.Macaroon(ctx)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	b, err := m.M().MarshalBinary()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	loc := a.locations()[0]
+	if !strings.Contains(loc, "://") {
+		loc = "https://" + loc
+	}
+	return loc + "?" + url.Values{
+		"macaroon":  {base64.RawURLEncoding.EncodeToString(b)},
+		"return_to": {returnURL},
+	}.Encode(), nil
+}
+
+// errorResponse is the body of the error responses written by
+// Middleware.
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes a JSON error response for the given error. Only
+// unauthorized errors are described to the client, the details of any
+// other error are logged rather than exposed.
+func (a *Authenticator) writeError(w http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusUnauthorized
+	resp := errorResponse{
+		Code:    "unauthorized",
+		Message: err.Error(),
+	}
+	if errgo.Cause(err) != ErrUnauthorized {
+		status = http.StatusInternalServerError
+		resp.Code = "internal server error"
+		resp.Message = "internal server error"
+		if a.logger != nil {
+			a.logger.ErrorContext(req.Context(), "cannot handle request", "path", req.URL.Path, "error", err)
+		} else {
+			log.Printf("cannot handle request for %s: %v", req.URL.Path, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,295 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestMiddleware(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	expectAccount := &ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+		Username: "test-user",
+	}
+	ms := dischargedMacaroon(c, a, expectAccount, time.Now().Add(time.Minute))
+
+	var called bool
+	h := ssoauth.Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		acc, ok := ssoauth.AccountFromContext(req.Context())
+		c.Check(ok, qt.Equals, true)
+		c.Check(acc, qt.DeepEquals, expectAccount)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Macaroon "+encodeMacaroons(c, ms))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	c.Check(rr.Code, qt.Equals, http.StatusOK)
+	c.Check(called, qt.Equals, true)
+}
+
+func TestAccountFromRequest(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	ms := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+
+	var called bool
+	h := ssoauth.Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		acc, ok := ssoauth.AccountFromRequest(req)
+		c.Check(ok, qt.IsTrue)
+		c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+		c.Check(ssoauth.MustAccountFromRequest(req), qt.Equals, acc)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Macaroon "+encodeMacaroons(c, ms))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	c.Check(called, qt.IsTrue)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	acc, ok := ssoauth.AccountFromRequest(req)
+	c.Check(ok, qt.IsFalse)
+	c.Check(acc, qt.IsNil)
+	c.Check(func() {
+		ssoauth.MustAccountFromRequest(req)
+	}, qt.PanicMatches, `ssoauth: no account in request context`)
+}
+
+func TestMiddlewareCookie(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:               bakery.NewOven(bakery.OvenParams{}),
+		PublicKey:          discharger.PublicKey(),
+		Location:           discharger.Location(),
+		MacaroonCookieName: "macaroon-sso",
+	})
+	ms := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+
+	var called bool
+	h := ssoauth.Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		acc, ok := ssoauth.AccountFromContext(req.Context())
+		c.Check(ok, qt.Equals, true)
+		c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "macaroon-sso", Value: encodeMacaroons(c, ms)})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	c.Check(rr.Code, qt.Equals, http.StatusOK)
+	c.Check(called, qt.Equals, true)
+}
+
+var middlewareUnauthorizedTests = []struct {
+	name          string
+	authorization func(c *qt.C, ms macaroon.Slice) string
+	expectMessage string
+}{{
+	name:          "no-macaroon",
+	authorization: func(*qt.C, macaroon.Slice) string { return "" },
+	expectMessage: `no macaroon`,
+}, {
+	name:          "unsupported-scheme",
+	authorization: func(*qt.C, macaroon.Slice) string { return "Bearer AAAA" },
+	expectMessage: `unsupported authorization scheme`,
+}, {
+	name:          "invalid-base64",
+	authorization: func(*qt.C, macaroon.Slice) string { return "Macaroon @@@@" },
+	expectMessage: `cannot parse macaroon: illegal base64 data at input byte 0`,
+}, {
+	name:          "invalid-macaroon",
+	authorization: func(*qt.C, macaroon.Slice) string { return "Macaroon AAAA" },
+	expectMessage: `cannot parse macaroon: .*`,
+}, {
+	name: "no-discharge",
+	authorization: func(c *qt.C, ms macaroon.Slice) string {
+		return "Macaroon " + encodeMacaroons(c, ms[:1])
+	},
+	expectMessage: `verification failed: cannot find discharge macaroon for caveat .*`,
+}}
+
+func TestMiddlewareUnauthorized(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	ms := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+	h := ssoauth.Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Error("unexpected call to handler")
+	}))
+
+	for _, test := range middlewareUnauthorizedTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if auth := test.authorization(c, ms); auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			c.Check(rr.Code, qt.Equals, http.StatusUnauthorized)
+			c.Check(rr.Header().Get("Content-Type"), qt.Equals, "application/json")
+			var resp struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			c.Assert(err, qt.IsNil)
+			c.Check(resp.Code, qt.Equals, "unauthorized")
+			c.Check(resp.Message, qt.Matches, test.expectMessage)
+		})
+	}
+}
+
+func TestAuthenticateFromRequest(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:               bakery.NewOven(bakery.OvenParams{}),
+		PublicKey:          discharger.PublicKey(),
+		Location:           discharger.Location(),
+		MacaroonCookieName: "macaroon-sso",
+	})
+	ms := dischargedMacaroon(c, a, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Macaroon "+encodeMacaroons(c, ms))
+	acc, err := a.AuthenticateFromRequest(req)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "macaroon-sso", Value: encodeMacaroons(c, ms)})
+	acc, err = a.AuthenticateFromRequest(req)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+
+	req = httptest.NewRequest("GET", "/", nil)
+	_, err = a.AuthenticateFromRequest(req)
+	c.Check(err, qt.ErrorMatches, `no macaroon`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Macaroon @@@@")
+	_, err = a.AuthenticateFromRequest(req)
+	c.Check(err, qt.ErrorMatches, `cannot parse macaroon: illegal base64 data at input byte 0`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	c := qt.New(t)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	h := a.RedirectHandler("https://service.example.com/callback?state=1")
+
+	req := httptest.NewRequest("GET", "/login", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusFound)
+
+	u, err := url.Parse(rr.Header().Get("Location"))
+	c.Assert(err, qt.IsNil)
+	c.Check(u.Scheme, qt.Equals, "https")
+	c.Check(u.Host, qt.Equals, "login.example.com")
+	c.Check(u.Query().Get("return_to"), qt.Equals, "https://service.example.com/callback?state=1")
+
+	b, err := base64.RawURLEncoding.DecodeString(u.Query().Get("macaroon"))
+	c.Assert(err, qt.IsNil)
+	var m macaroon.Macaroon
+	err = m.UnmarshalBinary(b)
+	c.Assert(err, qt.IsNil)
+
+	// The macaroon can be discharged and used to authenticate.
+	ms, err := ssoauthtest.Discharge(discharger, &m, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+	acc, err := a.Authenticate(context.Background(), ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+}
+
+func TestRedirectHandlerInternalError(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	a := ssoauth.New(ssoauth.Params{
+		Oven:        bakery.NewOven(bakery.OvenParams{}),
+		KeyProvider: new(ssoauth.AtomicPublicKey),
+		Location:    discharger.Location(),
+	}, ssoauth.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	h := a.RedirectHandler("https://service.example.com/callback")
+
+	req := httptest.NewRequest("GET", "/login", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusInternalServerError)
+
+	// The details of the error are logged, but not sent to the
+	// client.
+	var resp struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	c.Assert(err, qt.IsNil)
+	c.Check(resp.Code, qt.Equals, "internal server error")
+	c.Check(resp.Message, qt.Equals, "internal server error")
+	c.Check(rr.Body.String(), qt.Not(qt.Contains), "public key")
+	c.Check(buf.String(), qt.Contains, `msg="cannot handle request" path=/login error="no public key available"`)
+}
+
+// dischargedMacaroon creates a new macaroon with the given Authenticator
+// and discharges it with the test discharger.
+func dischargedMacaroon(c *qt.C, a *ssoauth.Authenticator, acc *ssoauth.Account, expires time.Time) macaroon.Slice {
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+	ms, err := ssoauthtest.Discharge(discharger, m.M(), acc, expires, time.Time{})
+	c.Assert(err, qt.IsNil)
+	return ms
+}
+
+func encodeMacaroons(c *qt.C, ms macaroon.Slice) string {
+	b, err := ssoauth.MarshalMacaroonSlice(ms)
+	c.Assert(err, qt.IsNil)
+	return string(b)
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+// ExpiryTime determines when the given macaroon expires, without
+// verifying it. Both SSO expires caveats added by the given location
+// and standard bakery time-before caveats are considered, the earliest
+// expiry time found is returned. If the macaroon has no expiry caveats
+// then the returned bool will be false. An error is returned if an SSO
+// expires caveat cannot be parsed.
+func ExpiryTime(m *macaroon.Macaroon, location string) (time.Time, bool, error) {
+	t, ok := checkers.ExpiryTime(nil, m.Caveats())
+	for _, cav := range m.Caveats() {
+		if len(cav.VerificationId) > 0 {
+			continue
+		}
+		loc, name, value, err := ParseCaveat(string(cav.Id))
+		if err != nil {
+			if string(cav.Id) == location+"|expires" {
+				return time.Time{}, false, errgo.Newf("malformed caveat %q", cav.Id)
+			}
+			continue
+		}
+		if loc != location || name != "expires" {
+			continue
+		}
+		et, err := time.Parse(timeFormat, value)
+		if err != nil {
+			return time.Time{}, false, errgo.Notef(err, "cannot parse caveat %q", cav.Id)
+		}
+		if !ok || et.Before(t) {
+			t = et
+			ok = true
+		}
+	}
+	return t, ok, nil
+}
+
+// DischargeCaveats holds the values of the SSO first-party caveats in a
+// discharge macaroon, see ParseDischargeCaveats.
+type DischargeCaveats struct {
+	// Account holds the account from the account caveat, or nil if
+	// there is no account caveat. Its Provider is set to the
+	// location and its LastAuth to the LastAuth below.
+	Account *Account
+
+	// Expires holds the earliest time in any expires caveat, or the
+	// zero time if there is none.
+	Expires time.Time
+
+	// ValidSince holds the latest time in any valid_since caveat,
+	// or the zero time if there is none.
+	ValidSince time.Time
+
+	// LastAuth holds the time in the last_auth caveat, or the zero
+	// time if there is none.
+	LastAuth time.Time
+
+	// UnknownCaveats holds, in order, the caveats at the location
+	// that are not otherwise recorded above.
+	UnknownCaveats []string
+}
+
+// ParseDischargeCaveats parses the first-party caveats added by the SSO
+// server at the given location to the given discharge macaroon, without
+// verifying the macaroon. Caveats for other locations are ignored. The
+// caveats are not checked, so the values of expires and valid_since
+// caveats are returned even if they are not satisfied. An error is
+// returned if any caveat is malformed, or if there is more than one
+// account or last_auth caveat.
+func ParseDischargeCaveats(m *macaroon.Macaroon, location string) (*DischargeCaveats, error) {
+	var dc DischargeCaveats
+	for _, cav := range m.Caveats() {
+		if len(cav.VerificationId) > 0 {
+			continue
+		}
+		loc, name, value, err := ParseCaveat(string(cav.Id))
+		if err != nil {
+			// The caveat may still be addressed to the
+			// location but have no value.
+			var ok bool
+			loc, name, ok = strings.Cut(string(cav.Id), "|")
+			if !ok || loc != location {
+				continue
+			}
+			if isDischargeCaveat(name) {
+				return nil, errgo.Newf("malformed caveat %q", cav.Id)
+			}
+			dc.UnknownCaveats = append(dc.UnknownCaveats, string(cav.Id))
+			continue
+		}
+		if loc != location {
+			continue
+		}
+		if !isDischargeCaveat(name) {
+			dc.UnknownCaveats = append(dc.UnknownCaveats, string(cav.Id))
+			continue
+		}
+		switch name {
+		case "account":
+			if dc.Account != nil {
+				return nil, errgo.Newf("duplicate caveat %q", cav.Id)
+			}
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot parse caveat %q", cav.Id)
+			}
+			acc := &Account{Provider: location}
+			if err := json.Unmarshal(b, acc); err != nil {
+				return nil, errgo.Notef(err, "cannot parse caveat %q", cav.Id)
+			}
+			dc.Account = acc
+		case "last_auth":
+			if !dc.LastAuth.IsZero() {
+				return nil, errgo.Newf("duplicate caveat %q", cav.Id)
+			}
+			t, err := time.Parse(timeFormat, value)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot parse caveat %q", cav.Id)
+			}
+			dc.LastAuth = t
+		case "expires":
+			t, err := time.Parse(timeFormat, value)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot parse caveat %q", cav.Id)
+			}
+			if dc.Expires.IsZero() || t.Before(dc.Expires) {
+				dc.Expires = t
+			}
+		case "valid_since":
+			t, err := time.Parse(timeFormat, value)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot parse caveat %q", cav.Id)
+			}
+			if t.After(dc.ValidSince) {
+				dc.ValidSince = t
+			}
+		}
+	}
+	if dc.Account != nil {
+		dc.Account.LastAuth = dc.LastAuth
+	}
+	return &dc, nil
+}
+
+// isDischargeCaveat reports whether caveats with the given name are
+// recorded in the fields of a DischargeCaveats.
+func isDischargeCaveat(name string) bool {
+	switch name {
+	case "account", "expires", "valid_since", "last_auth":
+		return true
+	}
+	return false
+}
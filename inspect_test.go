@@ -0,0 +1,199 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+var expiryTimeTests = []struct {
+	name        string
+	caveats     []string
+	expectTime  time.Time
+	expectOK    bool
+	expectError string
+}{{
+	name: "no-expiry",
+	caveats: []string{
+		"login.example.com|valid_since|2020-01-01T00:00:00.000000",
+	},
+}, {
+	name: "sso-expiry",
+	caveats: []string{
+		"login.example.com|expires|2020-01-02T00:00:00.000000",
+	},
+	expectTime: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	expectOK:   true,
+}, {
+	name: "bakery-expiry",
+	caveats: []string{
+		checkers.TimeBeforeCaveat(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)).Condition,
+	},
+	expectTime: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+	expectOK:   true,
+}, {
+	name: "both",
+	caveats: []string{
+		checkers.TimeBeforeCaveat(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)).Condition,
+		"login.example.com|expires|2020-01-02T00:00:00.000000",
+	},
+	expectTime: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	expectOK:   true,
+}, {
+	name: "other-location",
+	caveats: []string{
+		"login.staging.example.com|expires|2020-01-02T00:00:00.000000",
+	},
+}, {
+	name: "invalid-expires",
+	caveats: []string{
+		"login.example.com|expires|tomorrow",
+	},
+	expectError: `cannot parse caveat "login.example.com\|expires\|tomorrow": .*`,
+}, {
+	name: "malformed-expires",
+	caveats: []string{
+		"login.example.com|expires",
+	},
+	expectError: `malformed caveat "login.example.com\|expires"`,
+}}
+
+func TestExpiryTime(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range expiryTimeTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			m, err := macaroon.New([]byte("root-key"), []byte("id"), "", macaroon.V1)
+			c.Assert(err, qt.IsNil)
+			for _, cav := range test.caveats {
+				err := m.AddFirstPartyCaveat([]byte(cav))
+				c.Assert(err, qt.IsNil)
+			}
+			et, ok, err := ssoauth.ExpiryTime(m, "login.example.com")
+			if test.expectError != "" {
+				c.Check(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Check(ok, qt.Equals, test.expectOK)
+			c.Check(et.Equal(test.expectTime), qt.Equals, true)
+		})
+	}
+}
+
+func TestParseDischargeCaveats(t *testing.T) {
+	c := qt.New(t)
+
+	m, err := macaroon.New([]byte("root-key"), []byte("id"), "", macaroon.V1)
+	c.Assert(err, qt.IsNil)
+	err = ssoauth.AddThirdPartyCaveat(m, []byte("caveat-key"), discharger.Location(), discharger.PublicKey())
+	c.Assert(err, qt.IsNil)
+	caveatID, err := ssoauthtest.GetCaveatID(discharger, m)
+	c.Assert(err, qt.IsNil)
+
+	lastAuth := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	validSince := time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)
+	acc := ssoauthtest.NewAccount().
+		WithProvider(discharger.Location()).
+		WithOpenID("AAAAAAA").
+		WithUsername("test-user").
+		WithLastAuth(lastAuth).
+		Build()
+	d, err := discharger.Discharge(caveatID, acc, expires, validSince)
+	c.Assert(err, qt.IsNil)
+	for _, cav := range []string{
+		discharger.Location() + "|expires|2020-01-03T00:00:00.000000",
+		discharger.Location() + "|scopes|admin",
+		discharger.Location() + "|flag",
+		"login.staging.example.com|expires|2000-01-01T00:00:00.000000",
+		checkers.TimeBeforeCaveat(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).Condition,
+	} {
+		err := d.AddFirstPartyCaveat([]byte(cav))
+		c.Assert(err, qt.IsNil)
+	}
+
+	dc, err := ssoauth.ParseDischargeCaveats(d, discharger.Location())
+	c.Assert(err, qt.IsNil)
+	c.Check(dc.Account, ssoauthtest.AccountEquals, acc)
+	c.Check(dc.Expires.Equal(expires), qt.IsTrue)
+	c.Check(dc.ValidSince.Equal(validSince), qt.IsTrue)
+	c.Check(dc.LastAuth.Equal(lastAuth), qt.IsTrue)
+	c.Check(dc.UnknownCaveats, qt.DeepEquals, []string{
+		discharger.Location() + "|scopes|admin",
+		discharger.Location() + "|flag",
+	})
+}
+
+func TestParseDischargeCaveatsEmpty(t *testing.T) {
+	c := qt.New(t)
+
+	m, err := macaroon.New([]byte("root-key"), []byte("id"), "", macaroon.V1)
+	c.Assert(err, qt.IsNil)
+	dc, err := ssoauth.ParseDischargeCaveats(m, "login.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(dc, qt.DeepEquals, &ssoauth.DischargeCaveats{})
+}
+
+var parseDischargeCaveatsErrorTests = []struct {
+	name        string
+	caveats     []string
+	expectError string
+}{{
+	name:        "malformed-expires",
+	caveats:     []string{"login.example.com|expires"},
+	expectError: `malformed caveat "login.example.com\|expires"`,
+}, {
+	name:        "invalid-valid-since",
+	caveats:     []string{"login.example.com|valid_since|yesterday"},
+	expectError: `cannot parse caveat "login.example.com\|valid_since\|yesterday": .*`,
+}, {
+	name:        "invalid-account",
+	caveats:     []string{"login.example.com|account|!!!"},
+	expectError: `cannot parse caveat "login.example.com\|account\|!!!": .*`,
+}, {
+	name: "duplicate-last-auth",
+	caveats: []string{
+		"login.example.com|last_auth|2020-01-01T00:00:00.000000",
+		"login.example.com|last_auth|2020-01-02T00:00:00.000000",
+	},
+	expectError: `duplicate caveat "login.example.com\|last_auth\|2020-01-02T00:00:00.000000"`,
+}, {
+	name: "expired-is-not-an-error",
+	caveats: []string{
+		"login.example.com|expires|2000-01-01T00:00:00.000000",
+		"login.example.com|valid_since|2100-01-01T00:00:00.000000",
+	},
+}}
+
+func TestParseDischargeCaveatsErrors(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range parseDischargeCaveatsErrorTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			m, err := macaroon.New([]byte("root-key"), []byte("id"), "", macaroon.V1)
+			c.Assert(err, qt.IsNil)
+			for _, cav := range test.caveats {
+				err := m.AddFirstPartyCaveat([]byte(cav))
+				c.Assert(err, qt.IsNil)
+			}
+			_, err = ssoauth.ParseDischargeCaveats(m, "login.example.com")
+			if test.expectError == "" {
+				c.Check(err, qt.IsNil)
+				return
+			}
+			c.Check(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
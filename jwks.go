@@ -0,0 +1,111 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+// maxJWKSSize is the largest JWKS document that FetchPublicKeyFromJWKS
+// will read.
+const maxJWKSSize = 1 << 20
+
+// A jwk is the subset of a JSON Web Key (RFC 7517) needed to decode RSA
+// public keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// ParsePublicKeyFromJWKS parses the given JSON Web Key Set and returns
+// the RSA public keys it contains that are intended for signatures,
+// that is those with a "use" of "sig" or with no "use" specified. Keys
+// of other types are ignored. The keys are returned in the order they
+// appear in the set.
+func ParsePublicKeyFromJWKS(jwks []byte) ([]*rsa.PublicKey, error) {
+	keys, err := parseJWKS(jwks, func(k jwk) bool { return k.Use == "" || k.Use == "sig" })
+	return keys, errgo.Mask(err)
+}
+
+// FetchPublicKeyFromJWKS fetches the JSON Web Key Set at the given URL
+// and returns the first RSA public key in it with a "use" of "sig".
+func FetchPublicKeyFromJWKS(ctx context.Context, jwksURL string) (*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", jwksURL, nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot fetch JWKS")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("cannot fetch JWKS: unexpected status %q", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot fetch JWKS")
+	}
+	keys, err := parseJWKS(data, func(k jwk) bool { return k.Use == "sig" })
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(keys) == 0 {
+		return nil, errgo.New("no RSA signing key found in JWKS")
+	}
+	return keys[0], nil
+}
+
+// parseJWKS returns the RSA public keys in the given JSON Web Key Set
+// for which the given function returns true.
+func parseJWKS(data []byte, f func(jwk) bool) ([]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, errgo.Notef(err, "cannot parse JWKS")
+	}
+	var keys []*rsa.PublicKey
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || !f(k) {
+			continue
+		}
+		pk, err := rsaPublicKey(k)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid key %q", k.Kid)
+		}
+		keys = append(keys, pk)
+	}
+	return keys, nil
+}
+
+func rsaPublicKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid exponent")
+	}
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+		return nil, errgo.New("invalid RSA public key")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestParsePublicKeyFromJWKS(t *testing.T) {
+	c := qt.New(t)
+
+	encKey := ssoauthtest.GenerateTestKey(1024)
+	sigKey := ssoauthtest.GenerateTestKey(1024)
+	anyKey := ssoauthtest.GenerateTestKey(1024)
+	keys, err := ssoauth.ParsePublicKeyFromJWKS(testJWKS(c,
+		jwkJSON("enc", "RSA", &encKey.PublicKey),
+		jwkJSON("sig", "EC", nil),
+		jwkJSON("sig", "RSA", &sigKey.PublicKey),
+		jwkJSON("", "RSA", &anyKey.PublicKey),
+	))
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys, qt.HasLen, 2)
+	c.Check(keys[0].Equal(&sigKey.PublicKey), qt.IsTrue)
+	c.Check(keys[1].Equal(&anyKey.PublicKey), qt.IsTrue)
+}
+
+func TestParsePublicKeyFromJWKSErrors(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := ssoauth.ParsePublicKeyFromJWKS([]byte("{"))
+	c.Check(err, qt.ErrorMatches, `cannot parse JWKS: unexpected end of JSON input`)
+
+	_, err = ssoauth.ParsePublicKeyFromJWKS([]byte(`{"keys":[{"kty":"RSA","kid":"k1","n":"!","e":"AQAB"}]}`))
+	c.Check(err, qt.ErrorMatches, `invalid key "k1": invalid modulus: illegal base64 data at input byte 0`)
+
+	_, err = ssoauth.ParsePublicKeyFromJWKS([]byte(`{"keys":[{"kty":"RSA","kid":"k1","n":"AQAB","e":""}]}`))
+	c.Check(err, qt.ErrorMatches, `invalid key "k1": invalid RSA public key`)
+}
+
+func TestFetchPublicKeyFromJWKS(t *testing.T) {
+	c := qt.New(t)
+
+	encKey := ssoauthtest.GenerateTestKey(1024)
+	sigKey1 := ssoauthtest.GenerateTestKey(1024)
+	sigKey2 := ssoauthtest.GenerateTestKey(1024)
+	jwks := testJWKS(c,
+		jwkJSON("", "RSA", &encKey.PublicKey),
+		jwkJSON("enc", "RSA", &encKey.PublicKey),
+		jwkJSON("sig", "RSA", &sigKey1.PublicKey),
+		jwkJSON("sig", "RSA", &sigKey2.PublicKey),
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/jwks.json" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	pk, err := ssoauth.FetchPublicKeyFromJWKS(context.Background(), srv.URL+"/jwks.json")
+	c.Assert(err, qt.IsNil)
+	c.Check(pk.Equal(&sigKey1.PublicKey), qt.IsTrue)
+
+	_, err = ssoauth.FetchPublicKeyFromJWKS(context.Background(), srv.URL+"/missing")
+	c.Check(err, qt.ErrorMatches, `cannot fetch JWKS: unexpected status "404 Not Found"`)
+}
+
+func TestFetchPublicKeyFromJWKSNoSigningKey(t *testing.T) {
+	c := qt.New(t)
+
+	key := ssoauthtest.GenerateTestKey(1024)
+	jwks := testJWKS(c, jwkJSON("enc", "RSA", &key.PublicKey))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	_, err := ssoauth.FetchPublicKeyFromJWKS(context.Background(), srv.URL)
+	c.Check(err, qt.ErrorMatches, `no RSA signing key found in JWKS`)
+}
+
+func jwkJSON(use, kty string, pk *rsa.PublicKey) map[string]string {
+	k := map[string]string{"kty": kty}
+	if use != "" {
+		k["use"] = use
+	}
+	if pk != nil {
+		k["n"] = base64.RawURLEncoding.EncodeToString(pk.N.Bytes())
+		k["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes())
+	}
+	return k
+}
+
+func testJWKS(c *qt.C, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	c.Assert(err, qt.IsNil)
+	return data
+}
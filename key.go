@@ -0,0 +1,89 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"sync/atomic"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+// ParsePublicKey parses an RSA public key from the given PEM encoded
+// data. Both "RSA PUBLIC KEY" (PKCS #1) and "PUBLIC KEY" (PKIX) blocks
+// are supported.
+func ParsePublicKey(pemData []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errgo.New("no PEM data found")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		pk, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot parse public key")
+		}
+		return pk, nil
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot parse public key")
+		}
+		pk, ok := k.(*rsa.PublicKey)
+		if !ok {
+			return nil, errgo.Newf("unsupported public key type %T", k)
+		}
+		return pk, nil
+	default:
+		return nil, errgo.Newf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// LoadPublicKeyFile reads the PEM encoded RSA public key stored in the
+// file at the given path.
+func LoadPublicKeyFile(path string) (*rsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	pk, err := ParsePublicKey(b)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot load %s", path)
+	}
+	return pk, nil
+}
+
+// A KeyProvider provides the current RSA public key of an SSO server.
+type KeyProvider interface {
+	// PublicKey returns the current public key, or nil if no key is
+	// available.
+	PublicKey() *rsa.PublicKey
+}
+
+var _ KeyProvider = (*AtomicPublicKey)(nil)
+
+// An AtomicPublicKey is a KeyProvider holding a public key that may be
+// replaced at any time, for example when the SSO server rotates its
+// key. The zero value holds no key. It is safe for concurrent use.
+type AtomicPublicKey struct {
+	p atomic.Pointer[rsa.PublicKey]
+}
+
+// Store replaces the held public key with pk.
+func (k *AtomicPublicKey) Store(pk *rsa.PublicKey) {
+	k.p.Store(pk)
+}
+
+// Load returns the held public key.
+func (k *AtomicPublicKey) Load() *rsa.PublicKey {
+	return k.p.Load()
+}
+
+// PublicKey implements KeyProvider by returning the held public key.
+func (k *AtomicPublicKey) PublicKey() *rsa.PublicKey {
+	return k.p.Load()
+}
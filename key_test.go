@@ -0,0 +1,136 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestParsePublicKeyPKIX(t *testing.T) {
+	c := qt.New(t)
+
+	b, err := x509.MarshalPKIXPublicKey(discharger.PublicKey())
+	c.Assert(err, qt.IsNil)
+	pk, err := ssoauth.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+	c.Assert(err, qt.IsNil)
+	c.Check(pk.Equal(discharger.PublicKey()), qt.Equals, true)
+}
+
+func TestParsePublicKeyPKCS1(t *testing.T) {
+	c := qt.New(t)
+
+	b := x509.MarshalPKCS1PublicKey(discharger.PublicKey())
+	pk, err := ssoauth.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: b}))
+	c.Assert(err, qt.IsNil)
+	c.Check(pk.Equal(discharger.PublicKey()), qt.Equals, true)
+}
+
+func TestParsePublicKeyErrors(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := ssoauth.ParsePublicKey([]byte("not a key"))
+	c.Check(err, qt.ErrorMatches, `no PEM data found`)
+
+	_, err = ssoauth.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}))
+	c.Check(err, qt.ErrorMatches, `unsupported PEM block type "PRIVATE KEY"`)
+
+	_, err = ssoauth.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("key")}))
+	c.Check(err, qt.ErrorMatches, `cannot parse public key: .*`)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.IsNil)
+	b, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	c.Assert(err, qt.IsNil)
+	_, err = ssoauth.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+	c.Check(err, qt.ErrorMatches, `unsupported public key type \*ecdsa.PublicKey`)
+}
+
+func TestLoadPublicKeyFile(t *testing.T) {
+	c := qt.New(t)
+
+	b, err := x509.MarshalPKIXPublicKey(discharger.PublicKey())
+	c.Assert(err, qt.IsNil)
+	path := filepath.Join(c.Mkdir(), "sso.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}), 0600)
+	c.Assert(err, qt.IsNil)
+
+	pk, err := ssoauth.LoadPublicKeyFile(path)
+	c.Assert(err, qt.IsNil)
+	c.Check(pk.Equal(discharger.PublicKey()), qt.Equals, true)
+
+	_, err = ssoauth.LoadPublicKeyFile(filepath.Join(c.Mkdir(), "missing.pem"))
+	c.Check(err, qt.ErrorMatches, `open .*missing.pem: no such file or directory`)
+}
+
+func TestAtomicPublicKeyRotation(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	oldDischarger := new(ssoauthtest.Discharger)
+	newDischarger := new(ssoauthtest.Discharger)
+	var key ssoauth.AtomicPublicKey
+	key.Store(oldDischarger.PublicKey())
+	a := ssoauth.New(ssoauth.Params{
+		Oven:        bakery.NewOven(bakery.OvenParams{}),
+		KeyProvider: &key,
+		Location:    oldDischarger.Location(),
+	})
+	acc := ssoauthtest.NewAccount().WithProvider(oldDischarger.Location()).WithOpenID("AAAAAAA").Build()
+
+	m1, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	key.Store(newDischarger.PublicKey())
+	c.Check(key.Load(), qt.Equals, newDischarger.PublicKey())
+
+	m2, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// Macaroons minted before the rotation are still addressed to
+	// the old key.
+	gotAcc, err := a.Authenticate(ctx, ssoauthtest.MustDischarge(oldDischarger, m1.M(), acc, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+	c.Check(gotAcc, ssoauthtest.AccountEquals, acc)
+
+	_, err = ssoauthtest.Discharge(oldDischarger, m2.M(), acc, time.Time{}, time.Time{})
+	c.Check(err, qt.ErrorMatches, `.*cannot decrypt secret.*`)
+	gotAcc, err = a.Authenticate(ctx, ssoauthtest.MustDischarge(newDischarger, m2.M(), acc, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+	c.Check(gotAcc, ssoauthtest.AccountEquals, acc)
+}
+
+func TestAtomicPublicKeyEmpty(t *testing.T) {
+	c := qt.New(t)
+
+	var key ssoauth.AtomicPublicKey
+	p := ssoauth.Params{
+		Oven:        bakery.NewOven(bakery.OvenParams{}),
+		KeyProvider: &key,
+		Location:    discharger.Location(),
+	}
+	c.Assert(p.Validate(), qt.IsNil)
+
+	a := ssoauth.New(p)
+	_, err := a.Macaroon(context.Background())
+	c.Check(err, qt.ErrorMatches, `no public key available`)
+
+	key.Store(&ssoauthtest.GenerateTestKey(1024).PublicKey)
+	_, err = a.Macaroon(context.Background())
+	c.Check(err, qt.ErrorMatches, `public key too small \(1024 bits, need at least 2048\)`)
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"context"
+	"time"
+)
+
+// A Metrics records measurements of the use of an Authenticator, see
+// WithMetrics.
+type Metrics interface {
+	// ObserveAuthentication is called after every authentication
+	// attempt, by Authenticate, AuthenticateOp or AuthenticateAll,
+	// with the error returned and the time taken.
+	ObserveAuthentication(ctx context.Context, err error, d time.Duration)
+
+	// ObserveMacaroon is called whenever a macaroon is created, by
+	// Macaroon, MacaroonForOp or MacaroonForLocation.
+	ObserveMacaroon(ctx context.Context)
+}
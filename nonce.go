@@ -0,0 +1,79 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	errgo "gopkg.in/errgo.v1"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+// A NonceStore records the nonces of macaroons that have been used, see
+// WithNonceStore.
+type NonceStore interface {
+	// MarkUsed marks the given nonce as used, reporting whether it
+	// had already been marked.
+	MarkUsed(ctx context.Context, nonce string) (alreadyUsed bool, err error)
+}
+
+// AddNonceCaveat adds a first-party caveat to the given macaroon holding
+// a newly generated random nonce, which is returned. An Authenticator
+// configured with WithNonceStore accepts a macaroon with a nonce caveat
+// only once, and rejects macaroons without one. Because adding a caveat changes the macaroon's signature,
+// the caveat must be added before any discharge macaroons are bound to
+// it.
+func AddNonceCaveat(m *macaroon.Macaroon, location string) (nonce string, err error) {
+	nonce, err = newUUID()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	cav := FormatCaveat(location, "nonce", nonce)
+	if err := m.AddFirstPartyCaveat([]byte(cav)); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return nonce, nil
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+var _ NonceStore = (*MemNonceStore)(nil)
+
+// MemNonceStore is a NonceStore that records used nonces in memory. The
+// nonces are never forgotten, so it is mostly useful for testing. It is
+// safe for concurrent use.
+type MemNonceStore struct {
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+// NewMemNonceStore creates a new empty MemNonceStore.
+func NewMemNonceStore() *MemNonceStore {
+	return &MemNonceStore{
+		used: make(map[string]bool),
+	}
+}
+
+// MarkUsed implements NonceStore.
+func (s *MemNonceStore) MarkUsed(_ context.Context, nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used[nonce] {
+		return true, nil
+	}
+	s.used[nonce] = true
+	return false, nil
+}
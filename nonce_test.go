@@ -0,0 +1,168 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestNonceCaveat(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	ns := ssoauth.NewMemNonceStore()
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithNonceStore(ns))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	nonce, err := ssoauth.AddNonceCaveat(m.M(), discharger.Location())
+	c.Assert(err, qt.IsNil)
+	c.Check(nonce, qt.Matches, `[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+
+	acc, err := a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+
+	acc, err = a.Authenticate(ctx, ms)
+	c.Check(err, qt.ErrorMatches, `macaroon already used`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+	c.Check(acc, qt.IsNil)
+
+	used, err := ns.MarkUsed(ctx, nonce)
+	c.Assert(err, qt.IsNil)
+	c.Check(used, qt.IsTrue)
+}
+
+func TestNonceCaveatRequired(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithNonceStore(ssoauth.NewMemNonceStore()))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+
+	acc, err := a.Authenticate(ctx, ms)
+	c.Check(err, qt.ErrorMatches, `no nonce caveat`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+	c.Check(acc, qt.IsNil)
+}
+
+func TestNonceCaveatWithoutNonceStore(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = ssoauth.AddNonceCaveat(m.M(), discharger.Location())
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+
+	for i := 0; i < 2; i++ {
+		_, err := a.Authenticate(ctx, ms)
+		c.Assert(err, qt.IsNil)
+	}
+}
+
+func TestNonceCaveatNotSpentOnFailure(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	ns := ssoauth.NewMemNonceStore()
+	a := ssoauth.New(ssoauth.Params{
+		Oven:       bakery.NewOven(bakery.OvenParams{}),
+		PublicKey:  discharger.PublicKey(),
+		Location:   discharger.Location(),
+		MinAuthAge: time.Hour,
+	}, ssoauth.WithNonceStore(ns))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	nonce, err := ssoauth.AddNonceCaveat(m.M(), discharger.Location())
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+
+	_, err = a.Authenticate(ctx, ms)
+	c.Check(err, qt.ErrorMatches, `no last_auth caveat`)
+
+	used, err := ns.MarkUsed(ctx, nonce)
+	c.Assert(err, qt.IsNil)
+	c.Check(used, qt.IsFalse)
+}
+
+func TestNonceCaveatStoreError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithNonceStore(errorNonceStore{}))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = ssoauth.AddNonceCaveat(m.M(), discharger.Location())
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+
+	_, err = a.Authenticate(ctx, ms)
+	c.Check(err, qt.ErrorMatches, `cannot check nonce: nonce store unavailable`)
+	c.Check(errgo.Cause(err), qt.Not(qt.Equals), ssoauth.ErrUnauthorized)
+}
+
+func TestNonceCaveatChecker(t *testing.T) {
+	c := qt.New(t)
+
+	check := ssoauth.CaveatChecker(context.Background(), []string{discharger.Location()}, nil)
+	c.Check(check(discharger.Location()+"|nonce|1234"), qt.IsNil)
+	c.Check(check(discharger.Location()+"|nonce|5678"), qt.ErrorMatches, `duplicate caveat ".*"`)
+	c.Check(check(discharger.Location()+"|nonce|"), qt.ErrorMatches, `malformed caveat ".*"`)
+}
+
+func TestAddNonceCaveatUnique(t *testing.T) {
+	c := qt.New(t)
+
+	m, err := macaroon.New([]byte("root key"), []byte("id"), "", macaroon.V2)
+	c.Assert(err, qt.IsNil)
+	n1, err := ssoauth.AddNonceCaveat(m, "login.example.com")
+	c.Assert(err, qt.IsNil)
+	n2, err := ssoauth.AddNonceCaveat(m, "login.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(n1, qt.Not(qt.Equals), n2)
+	c.Assert(m.Caveats(), qt.HasLen, 2)
+	c.Check(string(m.Caveats()[0].Id), qt.Equals, "login.example.com|nonce|"+n1)
+}
+
+type errorNonceStore struct{}
+
+func (errorNonceStore) MarkUsed(context.Context, string) (bool, error) {
+	return false, errgo.New("nonce store unavailable")
+}
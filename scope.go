@@ -0,0 +1,55 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth
+
+// RequireScope checks that the given account has been granted the given
+// scope, see Params.Scopes. If it has not the returned error has a
+// cause of ErrUnauthorized.
+func RequireScope(acc *Account, scope string) error {
+	if !hasScope(acc, scope) {
+		return unauthorizedf(nil, "scope %q required", scope)
+	}
+	return nil
+}
+
+// RequireAnyScope checks that the given account has been granted at
+// least one of the given scopes. If it has not, or no scopes are given,
+// the returned error has a cause of ErrUnauthorized.
+func RequireAnyScope(acc *Account, scopes ...string) error {
+	for _, scope := range scopes {
+		if hasScope(acc, scope) {
+			return nil
+		}
+	}
+	return unauthorizedf(nil, "one of scopes %q required", scopes)
+}
+
+// RequireAllScopes checks that the given account has been granted every
+// one of the given scopes. If it has not, or no scopes are given, the
+// returned error has a cause of ErrUnauthorized.
+func RequireAllScopes(acc *Account, scopes ...string) error {
+	if len(scopes) == 0 {
+		return unauthorizedf(nil, "no scopes specified")
+	}
+	for _, scope := range scopes {
+		if err := RequireScope(acc, scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasScope reports whether acc has been granted the given scope. An
+// empty scope is never granted.
+func hasScope(acc *Account, scope string) bool {
+	if acc == nil || scope == "" {
+		return false
+	}
+	for _, s := range acc.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauth_test
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+)
+
+var requireScopeTests = []struct {
+	about       string
+	scopes      []string
+	required    []string
+	expectAny   string
+	expectAll   string
+	expectFirst string
+}{{
+	about:    "match",
+	scopes:   []string{"admin", "read-only"},
+	required: []string{"admin", "read-only"},
+}, {
+	about:       "partial match",
+	scopes:      []string{"read-only"},
+	required:    []string{"admin", "read-only"},
+	expectAll:   `scope "admin" required`,
+	expectFirst: `scope "admin" required`,
+}, {
+	about:       "no match",
+	scopes:      []string{"other"},
+	required:    []string{"admin", "read-only"},
+	expectAny:   `one of scopes \["admin" "read-only"\] required`,
+	expectAll:   `scope "admin" required`,
+	expectFirst: `scope "admin" required`,
+}, {
+	about:       "nil scopes",
+	required:    []string{"admin"},
+	expectAny:   `one of scopes \["admin"\] required`,
+	expectAll:   `scope "admin" required`,
+	expectFirst: `scope "admin" required`,
+}, {
+	about:       "empty required scope",
+	scopes:      []string{"admin"},
+	required:    []string{""},
+	expectAny:   `one of scopes \[""\] required`,
+	expectAll:   `scope "" required`,
+	expectFirst: `scope "" required`,
+}, {
+	about:     "no required scopes",
+	scopes:    []string{"admin"},
+	expectAny: `one of scopes \[\] required`,
+	expectAll: `no scopes specified`,
+}}
+
+func TestRequireScope(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range requireScopeTests {
+		c.Run(test.about, func(c *qt.C) {
+			acc := &ssoauth.Account{OpenID: "AAAAAAA", Scopes: test.scopes}
+			checkScopeError(c, ssoauth.RequireAnyScope(acc, test.required...), test.expectAny)
+			checkScopeError(c, ssoauth.RequireAllScopes(acc, test.required...), test.expectAll)
+			if len(test.required) > 0 {
+				checkScopeError(c, ssoauth.RequireScope(acc, test.required[0]), test.expectFirst)
+			}
+		})
+	}
+}
+
+func TestRequireScopeNilAccount(t *testing.T) {
+	c := qt.New(t)
+	checkScopeError(c, ssoauth.RequireScope(nil, "admin"), `scope "admin" required`)
+	checkScopeError(c, ssoauth.RequireAnyScope(nil, "admin"), `one of scopes \["admin"\] required`)
+	checkScopeError(c, ssoauth.RequireAllScopes(nil, "admin"), `scope "admin" required`)
+}
+
+func checkScopeError(c *qt.C, err error, expectError string) {
+	c.Helper()
+	if expectError == "" {
+		c.Check(err, qt.IsNil)
+		return
+	}
+	c.Check(err, qt.ErrorMatches, expectError)
+	c.Check(errors.Is(err, ssoauth.ErrUnauthorized), qt.IsTrue)
+}
@@ -7,15 +7,25 @@ package ssoauth
 
 import (
 	"context"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/url"
+	"runtime"
+	"slices"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
@@ -23,8 +33,8 @@ import (
 )
 
 const (
-	timeFormat = "2006-01-02T15:04:05.000000"
-	expireTime = 7 * 24 * time.Hour
+	timeFormat        = "2006-01-02T15:04:05.000000"
+	defaultExpireTime = 7 * 24 * time.Hour
 )
 
 var ssoLoginOp = bakery.Op{
@@ -34,10 +44,25 @@ var ssoLoginOp = bakery.Op{
 
 var ErrUnauthorized = errgo.New("unauthorized")
 
+// ErrExpired is the cause of the errors returned when an SSO expires
+// caveat is no longer satisfied.
+var ErrExpired = errgo.New("macaroon expired")
+
+// ErrNotYetValid is the cause of the errors returned when an SSO
+// valid_since caveat is not yet satisfied.
+var ErrNotYetValid = errgo.New("macaroon not yet valid")
+
 // An Authenticator is used to mint macaroons with a third-party caveat
 // addressed to a canonical SSO provider and authenticate responses.
 type Authenticator struct {
-	p Params
+	p      Params
+	clock  Clock
+	logger *slog.Logger
+	tracer trace.Tracer
+
+	auditLogger AuditLogger
+	metrics     Metrics
+	nonceStore  NonceStore
 }
 
 type Params struct {
@@ -48,29 +73,290 @@ type Params struct {
 	// are addressed to.
 	Location string
 
+	// Locations contains a list of Ubuntu SSO locations that are
+	// trusted to discharge macaroons. If this is set then Location
+	// must be empty, the first location is used when minting
+	// macaroons with Macaroon and the others may be addressed using
+	// MacaroonForLocation. Third-party caveats addressed to a
+	// location are encrypted with that location's key in
+	// LocationPublicKeys, if it has one, otherwise with the key
+	// configured for all locations.
+	Locations []string
+
 	// PublicKey contains the public key of the Ubuntu SSO server to
 	// which the third-party caveat will be addressed.
+	//
+	// The key is only used to encrypt the caveat ID of newly minted
+	// macaroons; it is the SSO server that decrypts it when
+	// discharging. When the SSO server rotates its key only new
+	// macaroons need the new key, macaroons minted with the old key
+	// remain valid for as long as the SSO server can still decrypt
+	// them.
 	PublicKey *rsa.PublicKey
+
+	// KeyProvider provides the public key of the Ubuntu SSO server.
+	// If this is set then it is used in preference to PublicKey,
+	// which may be nil, and is consulted every time a macaroon is
+	// minted so that a rotated key is picked up without restarting,
+	// see AtomicPublicKey.
+	KeyProvider KeyProvider
+
+	// ECDHPublicKey contains an X25519 public key of the Ubuntu SSO
+	// server. If this is set then it is used in preference to
+	// PublicKey, which may be nil, to encrypt the caveat ID of newly
+	// minted macaroons, see AddThirdPartyCaveatECDH.
+	ECDHPublicKey *ecdh.PublicKey
+
+	// LocationPublicKeys contains the public keys of the Ubuntu SSO
+	// servers at particular locations, keyed by location. A key in
+	// this map is used in preference to PublicKey, KeyProvider and
+	// ECDHPublicKey for macaroons addressed to its location. If
+	// every location has a key in this map then none of the other
+	// keys need be set.
+	LocationPublicKeys map[string]*rsa.PublicKey
+
+	// Expiry contains the duration for which minted macaroons are
+	// valid. If this is zero then a default of seven days is used.
+	Expiry time.Duration
+
+	// MinAuthAge contains the maximum time that may have passed since
+	// the SSO server last authenticated the user. If this is non-zero
+	// then Authenticate rejects macaroons whose last_auth caveat is
+	// older than this, or that have no last_auth caveat at all.
+	MinAuthAge time.Duration
+
+	// Scopes contains the scopes that authenticated accounts may be
+	// granted. The Scopes of an authenticated account are these
+	// scopes restricted by any scopes caveats in the macaroons, see
+	// AddScopesCaveat. If this is empty no scopes are granted.
+	Scopes []string
+
+	// Audiences contains the service URLs that this service accepts
+	// in audience caveats, see AddAudienceCaveat. If this is empty
+	// audience caveats are not checked. Otherwise Authenticate
+	// rejects macaroons that have no audience caveat, or that have
+	// one for a service URL not in Audiences.
+	Audiences []string
+
+	// MacaroonCookieName contains the name of an HTTP cookie which
+	// may hold the macaroons when they are not provided in the
+	// Authorization header. If this is empty cookies are not
+	// consulted.
+	MacaroonCookieName string
+}
+
+// minKeyBits is the minimum size of RSA key accepted by Validate.
+const minKeyBits = 2048
+
+// Validate checks that the Params contain all the required values. All
+// problems found are listed in the returned error.
+func (p Params) Validate() error {
+	var problems []string
+	if p.Oven == nil {
+		problems = append(problems, "oven not specified")
+	}
+	locations := p.Locations
+	if p.Location != "" {
+		locations = append([]string{p.Location}, locations...)
+	}
+	allKeyed := len(locations) > 0
+	for _, loc := range locations {
+		if _, ok := p.LocationPublicKeys[loc]; !ok {
+			allKeyed = false
+		}
+	}
+	switch {
+	case p.ECDHPublicKey != nil:
+		if p.ECDHPublicKey.Curve() != ecdh.X25519() {
+			problems = append(problems, "ECDH public key is not an X25519 key")
+		}
+	case p.KeyProvider != nil:
+	case p.PublicKey == nil:
+		if !allKeyed {
+			problems = append(problems, "public key not specified")
+		}
+	case p.PublicKey.N.BitLen() < minKeyBits:
+		problems = append(problems, fmt.Sprintf("public key too small (%d bits, need at least %d)", p.PublicKey.N.BitLen(), minKeyBits))
+	}
+	switch {
+	case p.Location != "" && len(p.Locations) > 0:
+		problems = append(problems, "location and locations both specified")
+	case p.Location == "" && len(p.Locations) == 0:
+		problems = append(problems, "location not specified")
+	}
+	for _, loc := range locations {
+		if !validLocation(loc) {
+			problems = append(problems, fmt.Sprintf("invalid location %q", loc))
+		}
+	}
+	keyed := make([]string, 0, len(p.LocationPublicKeys))
+	for loc := range p.LocationPublicKeys {
+		keyed = append(keyed, loc)
+	}
+	slices.Sort(keyed)
+	for _, loc := range keyed {
+		pk := p.LocationPublicKeys[loc]
+		switch {
+		case !slices.Contains(locations, loc):
+			problems = append(problems, fmt.Sprintf("public key for unknown location %q", loc))
+		case pk == nil:
+			problems = append(problems, fmt.Sprintf("public key for location %q not specified", loc))
+		case pk.N.BitLen() < minKeyBits:
+			problems = append(problems, fmt.Sprintf("public key for location %q too small (%d bits, need at least %d)", loc, pk.N.BitLen(), minKeyBits))
+		}
+	}
+	if len(problems) > 0 {
+		return errgo.Newf("invalid parameters: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validLocation determines whether the given location is either an
+// https URL or a bare host name.
+func validLocation(loc string) bool {
+	if strings.HasPrefix(loc, "https://") {
+		u, err := url.Parse(loc)
+		return err == nil && u.Host != ""
+	}
+	u, err := url.Parse("https://" + loc)
+	return err == nil && u.Host == loc && u.Hostname() != ""
+}
+
+// An Option configures optional behaviour of an Authenticator.
+type Option func(*Authenticator)
+
+// A Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock used when none is configured.
+type systemClock struct{}
+
+// Now implements Clock by returning time.Now.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock configures the Authenticator to use the given Clock to
+// determine the current time. By default the system time is used.
+func WithClock(clock Clock) Option {
+	return func(a *Authenticator) {
+		a.clock = clock
+	}
+}
+
+// WithLogger configures the Authenticator to log to the given logger. By
+// default messages are logged using the standard log package.
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *Authenticator) {
+		a.logger = logger
+	}
+}
+
+// WithTracer configures the Authenticator to record a span for each call
+// to Authenticate using the given tracer. By default no spans are
+// recorded.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(a *Authenticator) {
+		a.tracer = tracer
+	}
 }
 
-// New creates a new Authenticator.
-func New(p Params) *Authenticator {
-	return &Authenticator{
-		p: p,
+// WithAuditLogger configures the Authenticator to report the outcome of
+// every call to Authenticate to the given AuditLogger.
+func WithAuditLogger(al AuditLogger) Option {
+	return func(a *Authenticator) {
+		a.auditLogger = al
 	}
 }
 
+// WithMetrics configures the Authenticator to report every
+// authentication attempt and every macaroon created to the given
+// Metrics, see the ssoauthmetrics package. By default nothing is
+// measured.
+func WithMetrics(m Metrics) Option {
+	return func(a *Authenticator) {
+		a.metrics = m
+	}
+}
+
+// WithNonceStore configures the Authenticator to reject macaroons with a
+// nonce caveat, see AddNonceCaveat, that the given NonceStore has
+// already seen, and macaroons that have no nonce caveat at all. By
+// default nonce caveats are not checked.
+func WithNonceStore(ns NonceStore) Option {
+	return func(a *Authenticator) {
+		a.nonceStore = ns
+	}
+}
+
+// New creates a new Authenticator. New panics if the given Params are
+// not valid, see Params.Validate.
+func New(p Params, opts ...Option) *Authenticator {
+	if err := p.Validate(); err != nil {
+		panic(err)
+	}
+	a := &Authenticator{
+		p:     p,
+		clock: systemClock{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
 // Macaroon creates a new macaroon with a third party caveat addressed to
 // the configured SSO server. Once discharged, the macaroon can be used
 // to authorize a call to the Authenticate method.
 func (a *Authenticator) Macaroon(ctx context.Context) (*bakery.Macaroon, error) {
+	m, err := a.newMacaroon(ctx, a.locations()[0], ssoLoginOp)
+	return m, errgo.Mask(err)
+}
+
+// MacaroonForOp creates a new macaroon in the same way as Macaroon,
+// except that the macaroon authorizes the given operation rather than
+// a plain login. Once discharged, the macaroon can be used to authorize
+// a call to the AuthenticateOp method with the same operation.
+func (a *Authenticator) MacaroonForOp(ctx context.Context, op bakery.Op) (*bakery.Macaroon, error) {
+	m, err := a.newMacaroon(ctx, a.locations()[0], op)
+	return m, errgo.Mask(err)
+}
+
+// MacaroonForLocation creates a new macaroon with a third party caveat
+// addressed to the SSO server at the given location, which must be one
+// of the configured locations.
+func (a *Authenticator) MacaroonForLocation(ctx context.Context, location string) (*bakery.Macaroon, error) {
+	for _, loc := range a.locations() {
+		if loc == location {
+			m, err := a.newMacaroon(ctx, location, ssoLoginOp)
+			return m, errgo.Mask(err)
+		}
+	}
+	return nil, errgo.Newf("untrusted location %q", location)
+}
+
+// locations returns the SSO locations trusted by the Authenticator.
+func (a *Authenticator) locations() []string {
+	if len(a.p.Locations) > 0 {
+		return a.p.Locations
+	}
+	return []string{a.p.Location}
+}
+
+func (a *Authenticator) newMacaroon(ctx context.Context, location string, op bakery.Op) (*bakery.Macaroon, error) {
+	expiry := a.p.Expiry
+	if expiry == 0 {
+		expiry = defaultExpireTime
+	}
 	m, err := a.p.Oven.NewMacaroon(
 		ctx,
 		bakery.Version1,
 		[]checkers.Caveat{
-			checkers.TimeBeforeCaveat(time.Now().Add(expireTime)),
+			checkers.TimeBeforeCaveat(a.clock.Now().Add(expiry)),
 		},
-		ssoLoginOp,
+		op,
 	)
 	if err != nil {
 		return nil, errgo.Mask(err)
@@ -84,13 +370,42 @@ func (a *Authenticator) Macaroon(ctx context.Context) (*bakery.Macaroon, error)
 		return nil, errgo.Mask(err)
 	}
 
-	if err := AddThirdPartyCaveat(m.M(), rootKey[:], a.p.Location, a.p.PublicKey); err != nil {
+	if pk, ok := a.p.LocationPublicKeys[location]; ok {
+		err = AddThirdPartyCaveat(m.M(), rootKey, location, pk)
+	} else if a.p.ECDHPublicKey != nil {
+		err = AddThirdPartyCaveatECDH(m.M(), rootKey, location, a.p.ECDHPublicKey)
+	} else {
+		var pk *rsa.PublicKey
+		pk, err = a.publicKey()
+		if err == nil {
+			err = AddThirdPartyCaveat(m.M(), rootKey, location, pk)
+		}
+	}
+	if err != nil {
 		return nil, errgo.Mask(err)
 	}
-
+	if a.metrics != nil {
+		a.metrics.ObserveMacaroon(ctx)
+	}
 	return m, nil
 }
 
+// publicKey returns the RSA public key to which third-party caveats are
+// currently addressed.
+func (a *Authenticator) publicKey() (*rsa.PublicKey, error) {
+	if a.p.KeyProvider == nil {
+		return a.p.PublicKey, nil
+	}
+	pk := a.p.KeyProvider.PublicKey()
+	switch {
+	case pk == nil:
+		return nil, errgo.New("no public key available")
+	case pk.N.BitLen() < minKeyBits:
+		return nil, errgo.Newf("public key too small (%d bits, need at least %d)", pk.N.BitLen(), minKeyBits)
+	}
+	return pk, nil
+}
+
 // AddThirdPartyCaveat adds a third-party caveat to the given macaroon in
 // the format understood by the SSO server.
 func AddThirdPartyCaveat(m *macaroon.Macaroon, rootKey []byte, location string, pk *rsa.PublicKey) error {
@@ -117,46 +432,218 @@ func AddThirdPartyCaveat(m *macaroon.Macaroon, rootKey []byte, location string,
 // the macaroon, if any. If given macaroons are not valid then an error
 // with a cause of ErrUnauthorized is returned.
 func (a *Authenticator) Authenticate(ctx context.Context, ms macaroon.Slice) (*Account, error) {
+	return a.AuthenticateOp(ctx, ms, ssoLoginOp)
+}
+
+// AuthenticateOp authenticates the given macaroon slice in the same way
+// as Authenticate, except that the macaroon must have been minted by
+// MacaroonForOp for the given operation. Macaroons authorizing any
+// other operation are rejected with an error with a cause of
+// ErrUnauthorized.
+func (a *Authenticator) AuthenticateOp(ctx context.Context, ms macaroon.Slice, op bakery.Op) (*Account, error) {
+	start := time.Now()
+	acc, err := a.traceAuthenticate(ctx, ms, op)
+	if a.metrics != nil {
+		a.metrics.ObserveAuthentication(ctx, err, time.Since(start))
+	}
+	if a.auditLogger != nil {
+		a.auditLogger.LogAuthentication(ctx, acc, err)
+	}
+	return acc, err
+}
+
+// AuthenticateAll authenticates each of the given macaroon slices as
+// Authenticate does, verifying up to runtime.NumCPU slices concurrently.
+// The returned accounts and errors are in the same order as slices. If
+// the context is canceled any slices that have not yet started
+// verification are not verified and their error is the context's error.
+func (a *Authenticator) AuthenticateAll(ctx context.Context, slices []macaroon.Slice) ([]*Account, []error) {
+	accs := make([]*Account, len(slices))
+	errs := make([]error, len(slices))
+	var g errgroup.Group
+	g.SetLimit(runtime.NumCPU())
+	for i, ms := range slices {
+		i, ms := i, ms
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return nil
+			}
+			accs[i], errs[i] = a.Authenticate(ctx, ms)
+			return nil
+		})
+	}
+	g.Wait()
+	return accs, errs
+}
+
+// traceAuthenticate authenticates the given macaroons, recording a span
+// if the Authenticator has a tracer.
+func (a *Authenticator) traceAuthenticate(ctx context.Context, ms macaroon.Slice, op bakery.Op) (*Account, error) {
+	if a.tracer == nil {
+		return a.authenticate(ctx, ms, op)
+	}
+	ctx, span := a.tracer.Start(ctx, "ssoauth.Authenticate", trace.WithAttributes(
+		attribute.StringSlice("sso.location", a.locations()),
+	))
+	defer span.End()
+	acc, err := a.authenticate(ctx, ms, op)
+	span.SetAttributes(attribute.String("sso.result", authenticateResult(err)))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return acc, err
+}
+
+// authenticateResult classifies an error returned from Authenticate as
+// "success", "unauthorized" or "error".
+func authenticateResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errgo.Cause(err) == ErrUnauthorized:
+		return "unauthorized"
+	default:
+		return "error"
+	}
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, ms macaroon.Slice, op bakery.Op) (*Account, error) {
 	ops, conditions, err := a.p.Oven.VerifyMacaroon(ctx, ms)
 	if err != nil {
 		if _, ok := err.(*bakery.VerificationError); ok {
-			return nil, errgo.WithCausef(err, ErrUnauthorized, "")
+			return nil, unauthorizedf(err, "")
 		}
 		return nil, errgo.Mask(err)
 	}
 
-	if len(ops) != 1 || ops[0] != ssoLoginOp {
-		return nil, errgo.WithCausef(nil, ErrUnauthorized, "invalid macaroon")
+	if len(ops) != 1 || ops[0] != op {
+		return nil, unauthorizedf(nil, "invalid macaroon")
 	}
 
 	var account Account
 
-	ssoChecker := CaveatChecker(a.p.Location, &account)
+	ssoChecker := &caveatChecker{
+		ctx:       ctx,
+		locations: a.locations(),
+		acc:       &account,
+		clock:     a.clock,
+		logger:    a.logger,
+		audiences: a.p.Audiences,
+
+		grantedScopes: a.p.Scopes,
+	}
 	stdChecker := checkers.New(nil)
+	stdCtx := checkers.ContextWithClock(ctx, a.clock)
 	for _, cond := range conditions {
-		if err := ssoChecker(cond); err != nil {
+		if err := ssoChecker.check(cond); err != nil {
 			if err == ErrUnsupportedCaveat {
-				err = stdChecker.CheckFirstPartyCaveat(ctx, cond)
+				err = stdChecker.CheckFirstPartyCaveat(stdCtx, cond)
+			}
+			if err == context.Canceled || err == context.DeadlineExceeded {
+				return nil, errgo.Mask(err, errgo.Is(context.Canceled), errgo.Is(context.DeadlineExceeded))
 			}
 			if err != nil {
-				return nil, errgo.WithCausef(err, ErrUnauthorized, "")
+				return nil, unauthorizedf(err, "")
 			}
 		}
 	}
 
+	if !ssoChecker.scopesSeen && len(a.p.Scopes) > 0 {
+		account.Scopes = append([]string{}, a.p.Scopes...)
+	}
+
+	if len(a.p.Audiences) > 0 && !ssoChecker.audienceSeen {
+		return nil, unauthorizedf(nil, "no audience caveat")
+	}
+
+	if a.p.MinAuthAge != 0 {
+		if account.LastAuth.IsZero() {
+			return nil, unauthorizedf(nil, "no last_auth caveat")
+		}
+		if a.clock.Now().Sub(account.LastAuth) > a.p.MinAuthAge {
+			return nil, unauthorizedf(nil, "authentication too old")
+		}
+	}
+
+	// The nonce is only marked as used once every other check has
+	// passed, so that a macaroon rejected for some other reason is
+	// not also spent.
+	if a.nonceStore != nil {
+		if ssoChecker.nonce == "" {
+			return nil, unauthorizedf(nil, "no nonce caveat")
+		}
+		used, err := a.nonceStore.MarkUsed(ctx, ssoChecker.nonce)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot check nonce")
+		}
+		if used {
+			return nil, unauthorizedf(nil, "macaroon already used")
+		}
+	}
+
 	return &account, nil
 }
 
+// unauthorizedError is the error returned from Authenticate when the
+// given macaroons are not valid. Its cause is always ErrUnauthorized,
+// but errors.Is will also match the cause of the underlying error so
+// that callers can distinguish particular failures, such as ErrExpired.
+type unauthorizedError struct {
+	errgo.Err
+}
+
+func unauthorizedf(underlying error, f string, a ...interface{}) error {
+	msg := fmt.Sprintf(f, a...)
+	if underlying == nil && msg == "" {
+		msg = ErrUnauthorized.Error()
+	}
+	err := &unauthorizedError{
+		Err: errgo.Err{
+			Message_:    msg,
+			Underlying_: underlying,
+			Cause_:      ErrUnauthorized,
+		},
+	}
+	err.SetLocation(1)
+	return err
+}
+
+// Is implements the interface used by errors.Is.
+func (e *unauthorizedError) Is(target error) bool {
+	if target == ErrUnauthorized {
+		return true
+	}
+	return e.Underlying_ != nil && errgo.Cause(e.Underlying_) == target
+}
+
 // Account contains the details of the authenticated user that Ubuntu
 // SSO added to the discharge macaroon.
+//
+// Accounts may also be encoded as YAML, in which case every field is
+// included, see Account.MarshalYAML.
 type Account struct {
-	Provider    string    `json:"-"`
-	OpenID      string    `json:"openid"`
-	Username    string    `json:"username"`
-	DisplayName string    `json:"displayname"`
-	Email       string    `json:"email"`
-	IsVerified  bool      `json:"is_verified"`
-	LastAuth    time.Time `json:"-"`
+	Provider    string    `json:"-" yaml:"provider"`
+	OpenID      string    `json:"openid" yaml:"openid"`
+	Username    string    `json:"username" yaml:"username"`
+	DisplayName string    `json:"displayname" yaml:"displayname"`
+	Email       string    `json:"email" yaml:"email"`
+	IsVerified  bool      `json:"is_verified" yaml:"is_verified"`
+	LastAuth    time.Time `json:"-" yaml:"last_auth"`
+
+	// Extra contains the values of any SSO caveats that are not
+	// otherwise understood, keyed by the caveat name. Macaroons with
+	// more than one such caveat with the same name are rejected.
+	// Because anyone holding a macaroon can add first-party caveats,
+	// a value should only be relied upon if the SSO server always
+	// adds that caveat to its discharges.
+	Extra map[string]string `json:"-" yaml:"extra,omitempty"`
+
+	// Scopes contains the scopes granted to the account. These are
+	// the Scopes in the Params, restricted by any scopes caveats,
+	// see AddScopesCaveat. It is empty if the Params grant no
+	// scopes.
+	Scopes []string `json:"-" yaml:"scopes,omitempty"`
 }
 
 // ErrUnsupportedCaveat is returned from the function created in
@@ -164,88 +651,213 @@ type Account struct {
 var ErrUnsupportedCaveat = errgo.New("unsupported caveat")
 
 // CaveatChecker creates a function which verifies first-party caveats
-// added by the SSO server at the given location. Account information
+// added by the SSO servers at any of the given locations. Account information
 // returned from the SSO server will be stored in the given Account. The
-// returned function is suitable for using asthe check parameter with the
-// Verify method of macaroon.Macaroon. If any provided caveat is not
+// returned function closes over the given context, which makes it
+// suitable for using as the check parameter with the Verify method of
+// macaroon.Macaroon. If the context is done then the context's error is
+// returned without checking the caveat. If any provided caveat is not
 // supported by this checker then an ErrUnsupportedCaveat error will be
 // returned.
-func CaveatChecker(location string, acc *Account) func(caveatID string) error {
+func CaveatChecker(ctx context.Context, locations []string, acc *Account) func(caveatID string) error {
 	if acc == nil {
 		acc = new(Account)
 	}
-	return func(caveatID string) error {
-		parts := strings.SplitN(caveatID, "|", 3)
-		if len(parts) < 2 || parts[0] != location {
+	c := &caveatChecker{
+		ctx:       ctx,
+		locations: locations,
+		acc:       acc,
+		clock:     systemClock{},
+	}
+	return c.check
+}
+
+// A caveatChecker holds the state used to check the first-party caveats
+// added by an SSO server.
+type caveatChecker struct {
+	ctx       context.Context
+	locations []string
+	acc       *Account
+	clock     Clock
+	logger    *slog.Logger
+
+	// grantedScopes holds the scopes that may be granted to the
+	// account, which scopes caveats restrict.
+	grantedScopes []string
+
+	// scopesSeen records whether a scopes caveat has been checked.
+	scopesSeen bool
+
+	// audiences holds the service URLs accepted in audience
+	// caveats. If it is empty audience caveats are not checked.
+	audiences []string
+
+	// audienceSeen records whether an audience caveat has been
+	// checked.
+	audienceSeen bool
+
+	// nonce holds the value of the nonce caveat, if any.
+	nonce string
+}
+
+func (c *caveatChecker) trusted(location string) bool {
+	for _, loc := range c.locations {
+		if loc == location {
+			return true
+		}
+	}
+	return false
+}
+
+// audienceAllowed determines whether an audience caveat for the given
+// service URL is satisfied.
+func (c *caveatChecker) audienceAllowed(serviceURL string) bool {
+	if len(c.audiences) == 0 {
+		return true
+	}
+	for _, aud := range c.audiences {
+		if aud == serviceURL {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *caveatChecker) check(caveatID string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	acc := c.acc
+	location, name, value, err := ParseCaveat(caveatID)
+	if err != nil {
+		return c.checkNoValue(caveatID)
+	}
+	if !c.trusted(location) {
+		return ErrUnsupportedCaveat
+	}
+	switch name {
+	case "account":
+		// account is a declarative caveat that the SSO
+		// server will only add one of. If we have
+		// already seen one then reject the macaroon.
+		if acc.Provider != "" {
+			return errgo.Newf("duplicate caveat %q", caveatID)
+		}
+		acc.Provider = location
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return errgo.Notef(err, "cannot parse caveat %q", caveatID)
+		}
+		if err := json.Unmarshal(b, &acc); err != nil {
+			return errgo.Notef(err, "cannot parse caveat %q", caveatID)
+		}
+	case "audience":
+		if !c.audienceAllowed(value) {
+			return errgo.Newf("caveat %q not satisfied", caveatID)
+		}
+		c.audienceSeen = true
+	case "expires":
+		// Ensure that now is before the macaroon expires.
+		t, err := time.Parse(timeFormat, value)
+		if err != nil {
+			return errgo.Notef(err, "cannot parse caveat %q", caveatID)
+		}
+		if !c.clock.Now().Before(t) {
+			return errgo.WithCausef(nil, ErrExpired, "")
+		}
+	case "last_auth":
+		// last_auth is a declarative caveat the the SSO
+		// server will only add one of. If we have
+		// already seen one then reject the macaroon.
+		if !acc.LastAuth.IsZero() {
+			return errgo.Newf("duplicate caveat %q", caveatID)
+		}
+		var err error
+		acc.LastAuth, err = time.Parse(timeFormat, value)
+		if err != nil {
+			return errgo.Notef(err, "cannot parse caveat %q", caveatID)
+		}
+	case "allowed_ips":
+		// Without a known remote address this checker cannot
+		// determine whether the caveat is satisfied, leave the
+		// decision up to the caller.
+		addr, ok := remoteAddrFromContext(c.ctx)
+		if !ok {
 			return ErrUnsupportedCaveat
 		}
-		switch parts[1] {
-		case "account":
-			// account is a declarative caveat that the SSO
-			// server will only add one of. If we have
-			// already seen one then reject the macaroon.
-			if acc.Provider != "" {
-				return errgo.Newf("duplicate caveat %q", caveatID)
-			}
-			acc.Provider = parts[0]
-			if len(parts) < 3 {
-				return errgo.Newf("malformed caveat %q", caveatID)
-			}
-			b, err := base64.StdEncoding.DecodeString(parts[2])
-			if err != nil {
-				return errgo.Notef(err, "cannot parse caveat %q", caveatID)
-			}
-			if err := json.Unmarshal(b, &acc); err != nil {
-				return errgo.Notef(err, "cannot parse caveat %q", caveatID)
-			}
-		case "expires":
-			if len(parts) < 3 {
-				return errgo.Newf("malformed caveat %q", caveatID)
-			}
-			// Ensure that now is before the macaroon expires.
-			t, err := time.Parse(timeFormat, parts[2])
-			if err != nil {
-				return errgo.Notef(err, "cannot parse caveat %q", caveatID)
-			}
-			if !time.Now().Before(t) {
-				return errgo.New("macaroon expired")
-			}
-		case "last_auth":
-			// last_auth is a declarative caveat the the SSO
-			// server will only add one of. If we have
-			// already seen one then reject the macaroon.
-			if !acc.LastAuth.IsZero() {
-				return errgo.Newf("duplicate caveat %q", caveatID)
-			}
-			if len(parts) < 3 {
-				return errgo.Newf("malformed caveat %q", caveatID)
-			}
-			var err error
-			acc.LastAuth, err = time.Parse(timeFormat, parts[2])
-			if err != nil {
-				return errgo.Notef(err, "cannot parse caveat %q", caveatID)
-			}
-		case "valid_since":
-			// Ensure that now is after valid_since.
-			if len(parts) < 3 {
-				return errgo.Newf("malformed caveat %q", caveatID)
-			}
-			t, err := time.Parse(timeFormat, parts[2])
-			if err != nil {
-				return errgo.Notef(err, "cannot parse caveat %q", caveatID)
-			}
-			if !time.Now().After(t) {
-				return errgo.New("macaroon not yet valid")
-			}
-		default:
-			// Ideally we would fail here, but there is
-			// currently no guarantee that SSO won't add
-			// additional first-party caveats to the
-			// discharge macaroon. For now just log the
-			// unexpected caveat.
-			log.Printf("unexpected SSO caveat detected %q", caveatID)
+		if err := checkAllowedIPs(addr, value); err != nil {
+			return errgo.Notef(err, "caveat %q not satisfied", caveatID)
+		}
+	case "nonce":
+		if value == "" {
+			return errgo.Newf("malformed caveat %q", caveatID)
+		}
+		if c.nonce != "" {
+			return errgo.Newf("duplicate caveat %q", caveatID)
+		}
+		c.nonce = value
+	case "scopes":
+		// Anyone holding the macaroon may add a scopes
+		// caveat, so each one can only restrict the scopes
+		// granted, starting from those the checker grants.
+		granted := c.grantedScopes
+		if c.scopesSeen {
+			granted = acc.Scopes
 		}
+		acc.Scopes = intersectScopes(granted, parseScopes(value))
+		c.scopesSeen = true
+	case "valid_since":
+		// Ensure that now is after valid_since.
+		t, err := time.Parse(timeFormat, value)
+		if err != nil {
+			return errgo.Notef(err, "cannot parse caveat %q", caveatID)
+		}
+		if !c.clock.Now().After(t) {
+			return errgo.WithCausef(nil, ErrNotYetValid, "")
+		}
+	default:
+		// Ideally we would fail here, but there is
+		// currently no guarantee that SSO won't add
+		// additional first-party caveats to the
+		// discharge macaroon. Record the values of any
+		// such caveats so that callers can make use of
+		// them.
+		// As anyone holding the macaroon may add further
+		// caveats, a later caveat must not be allowed to
+		// replace the value added by the SSO server.
+		if _, ok := acc.Extra[name]; ok {
+			return errgo.Newf("duplicate caveat %q", caveatID)
+		}
+		if acc.Extra == nil {
+			acc.Extra = make(map[string]string)
+		}
+		acc.Extra[name] = value
+	}
+
+	return nil
+}
+
+// checkNoValue checks a caveat that has no value, and so is not
+// accepted by ParseCaveat. Such caveats from a trusted location are
+// malformed if they are understood by the checker, and are otherwise
+// logged and ignored.
+func (c *caveatChecker) checkNoValue(caveatID string) error {
+	location, name, ok := strings.Cut(caveatID, "|")
+	if !ok || !c.trusted(location) {
+		return ErrUnsupportedCaveat
+	}
+	switch name {
+	case "account", "audience", "expires", "last_auth", "allowed_ips", "nonce", "scopes", "valid_since":
+		return errgo.Newf("malformed caveat %q", caveatID)
+	}
+	c.logUnexpected(caveatID)
+	return nil
+}
 
-		return nil
+func (c *caveatChecker) logUnexpected(caveatID string) {
+	if c.logger != nil {
+		c.logger.Warn("unexpected SSO caveat", "caveat_id", caveatID)
+		return
 	}
+	log.Printf("unexpected SSO caveat detected %q", caveatID)
 }
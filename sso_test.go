@@ -4,16 +4,25 @@
 package ssoauth_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
 	"testing"
 	"time"
 
 	qt "github.com/frankban/quicktest"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace/noop"
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
@@ -53,23 +62,64 @@ func TestMacaroon(t *testing.T) {
 	}})
 }
 
+func TestMacaroonExpiry(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+		Expiry:    time.Hour,
+	}, ssoauth.WithClock(ssoauthtest.NewFakeClock(now)))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	expires, ok := checkers.ExpiryTime(nil, m.M().Caveats())
+	c.Assert(ok, qt.Equals, true)
+	c.Check(expires.Equal(now.Add(time.Hour)), qt.Equals, true)
+}
+
+func TestMacaroonDefaultExpiry(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithClock(ssoauthtest.NewFakeClock(now)))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	expires, ok := checkers.ExpiryTime(nil, m.M().Caveats())
+	c.Assert(ok, qt.Equals, true)
+	c.Check(expires.Equal(now.Add(7*24*time.Hour)), qt.Equals, true)
+}
+
 func TestAuthenticate(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
 	o := bakery.NewOven(bakery.OvenParams{})
 	a := ssoauth.New(ssoauth.Params{
 		Oven:      o,
 		PublicKey: discharger.PublicKey(),
 		Location:  discharger.Location(),
-	})
+	}, ssoauth.WithClock(ssoauthtest.NewFakeClock(now)))
 
 	m, err := a.Macaroon(ctx)
 	c.Assert(err, qt.IsNil)
 
 	caveatID, err := ssoauthtest.GetCaveatID(discharger, m.M())
 	c.Assert(err, qt.IsNil)
-	now := time.Now().UTC()
 	expectAccount := ssoauth.Account{
 		Provider:    "login.example.com",
 		OpenID:      "AAAAAAA",
@@ -175,6 +225,44 @@ func TestAuthenticateIncorrectOp(t *testing.T) {
 	c.Assert(account, qt.IsNil)
 }
 
+func TestAuthenticateOp(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	readOp := bakery.Op{Entity: "resource", Action: "read"}
+	adminOp := bakery.Op{Entity: "resource", Action: "admin"}
+	acc := ssoauthtest.NewAccount().WithProvider(discharger.Location()).WithOpenID("AAAAAAA").Build()
+
+	m, err := a.MacaroonForOp(ctx, readOp)
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), acc, time.Time{}, time.Time{})
+
+	gotAcc, err := a.AuthenticateOp(ctx, ms, readOp)
+	c.Assert(err, qt.IsNil)
+	c.Check(gotAcc, ssoauthtest.AccountEquals, acc)
+
+	gotAcc, err = a.AuthenticateOp(ctx, ms, adminOp)
+	c.Check(err, qt.ErrorMatches, `invalid macaroon`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+	c.Check(gotAcc, qt.IsNil)
+
+	// A macaroon minted for an operation cannot be used to log in,
+	// nor a login macaroon for an operation.
+	_, err = a.Authenticate(ctx, ms)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+
+	m, err = a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	ms = ssoauthtest.MustDischarge(discharger, m.M(), acc, time.Time{}, time.Time{})
+	_, err = a.AuthenticateOp(ctx, ms, readOp)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+}
+
 var authenticateUnauthorizedTests = []struct {
 	name        string
 	caveats     []string
@@ -205,6 +293,13 @@ var authenticateUnauthorizedTests = []struct {
 		discharger.Location() + "|last_auth|2019-01-01T00:00:00.000000",
 	},
 	expectError: `duplicate caveat "` + discharger.Location() + `\|last_auth\|.*`,
+}, {
+	name: "duplicate-unknown-caveat",
+	caveats: []string{
+		discharger.Location() + "|unknown_field|false",
+		discharger.Location() + "|unknown_field|true",
+	},
+	expectError: `duplicate caveat "` + discharger.Location() + `\|unknown_field\|true"`,
 }, {
 	name: "invalid-last-auth",
 	caveats: []string{
@@ -266,11 +361,12 @@ func TestAuthenticateUnauthorized(t *testing.T) {
 			ctx := context.Background()
 
 			// Create a discharge macaroon.
-			discharge, err := discharger.Discharge(caveatID, nil, time.Time{}, time.Time{})
-			c.Assert(err, qt.IsNil)
+			var caveats [][]byte
 			for _, cav := range test.caveats {
-				discharge.AddFirstPartyCaveat([]byte(cav))
+				caveats = append(caveats, []byte(cav))
 			}
+			discharge, err := discharger.DischargeWithCaveats(caveatID, caveats)
+			c.Assert(err, qt.IsNil)
 			discharge.Bind(m.M().Signature())
 			account, err := a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
 			c.Assert(err, qt.ErrorMatches, test.expectError)
@@ -280,6 +376,194 @@ func TestAuthenticateUnauthorized(t *testing.T) {
 	}
 }
 
+func TestAuthenticateErrorCauses(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithClock(ssoauthtest.NewFakeClock(now)))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	caveatID, err := ssoauthtest.GetCaveatID(discharger, m.M())
+	c.Assert(err, qt.IsNil)
+
+	discharge, err := discharger.Discharge(caveatID, nil, now.Add(-time.Minute), time.Time{})
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Check(err, qt.ErrorMatches, `macaroon expired`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+	c.Check(errors.Is(err, ssoauth.ErrUnauthorized), qt.Equals, true)
+	c.Check(errors.Is(err, ssoauth.ErrExpired), qt.Equals, true)
+	c.Check(errors.Is(err, ssoauth.ErrNotYetValid), qt.Equals, false)
+
+	discharge, err = discharger.Discharge(caveatID, nil, time.Time{}, now.Add(time.Minute))
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Check(err, qt.ErrorMatches, `macaroon not yet valid`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+	c.Check(errors.Is(err, ssoauth.ErrUnauthorized), qt.Equals, true)
+	c.Check(errors.Is(err, ssoauth.ErrExpired), qt.Equals, false)
+	c.Check(errors.Is(err, ssoauth.ErrNotYetValid), qt.Equals, true)
+}
+
+func TestAuthenticateWithClock(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := ssoauthtest.NewFakeClock(now)
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithClock(clock))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	caveatID, err := ssoauthtest.GetCaveatID(discharger, m.M())
+	c.Assert(err, qt.IsNil)
+	discharge, err := discharger.Discharge(caveatID, nil, now.Add(time.Minute), now.Add(-time.Minute))
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(err, qt.IsNil)
+
+	clock.Advance(2 * time.Minute)
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(errors.Is(err, ssoauth.ErrExpired), qt.Equals, true)
+
+	clock.Advance(-4 * time.Minute)
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(errors.Is(err, ssoauth.ErrNotYetValid), qt.Equals, true)
+
+	// The root macaroon itself expires after the default expiry
+	// time even when the discharge has no time restrictions.
+	discharge, err = discharger.Discharge(caveatID, nil, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+	clock.Set(now.Add(7*24*time.Hour - time.Second))
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(err, qt.IsNil)
+	clock.Set(now.Add(7 * 24 * time.Hour))
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(errors.Is(err, ssoauth.ErrUnauthorized), qt.Equals, true)
+}
+
+func TestAuthenticateWithLogger(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var stdBuf bytes.Buffer
+	log.SetOutput(&stdBuf)
+	defer log.SetOutput(os.Stderr)
+
+	var buf bytes.Buffer
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	caveatID, err := ssoauthtest.GetCaveatID(discharger, m.M())
+	c.Assert(err, qt.IsNil)
+	discharge, err := discharger.Discharge(caveatID, nil, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+	discharge.AddFirstPartyCaveat([]byte(discharge.Location() + "|unknown"))
+	discharge.Bind(m.M().Signature())
+
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(err, qt.IsNil)
+	c.Check(buf.String(), qt.Contains, `msg="unexpected SSO caveat" caveat_id=login.example.com|unknown`)
+	c.Check(stdBuf.String(), qt.Equals, "")
+}
+
+func TestAuthenticateWithoutLogger(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	caveatID := ssoauthtest.MustGetCaveatID(discharger, m.M())
+	discharge, err := discharger.DischargeWithCaveats(caveatID, [][]byte{[]byte(discharger.Location() + "|unknown")})
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(err, qt.IsNil)
+	c.Check(buf.String(), qt.Contains, `unexpected SSO caveat detected "login.example.com|unknown"`)
+}
+
+func TestAuthenticateWithTracer(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tracer := new(ssoauthtest.Tracer)
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithTracer(tracer))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = a.Authenticate(ctx, ssoauthtest.MustDischarge(discharger, m.M(), nil, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M()})
+	c.Assert(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+
+	spans := tracer.Spans()
+	c.Assert(spans, qt.HasLen, 2)
+	for i, result := range []string{"success", "unauthorized"} {
+		c.Check(spans[i].Name(), qt.Equals, "ssoauth.Authenticate")
+		c.Check(spans[i].Attribute("sso.location").AsStringSlice(), qt.DeepEquals, []string{"login.example.com"})
+		c.Check(spans[i].Attribute("sso.result").AsString(), qt.Equals, result)
+		c.Check(spans[i].Ended(), qt.IsTrue)
+	}
+	c.Check(spans[0].Status(), qt.Equals, codes.Unset)
+	c.Check(spans[1].Status(), qt.Equals, codes.Error)
+}
+
+func TestAuthenticateWithNoopTracer(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithTracer(noop.NewTracerProvider().Tracer("test")))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = a.Authenticate(ctx, ssoauthtest.MustDischarge(discharger, m.M(), nil, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+}
+
 func TestUnknownSSOFirstPartyCaveats(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
@@ -299,24 +583,27 @@ func TestUnknownSSOFirstPartyCaveats(t *testing.T) {
 
 	// Create a discharge macaroon.
 	now := time.Now().UTC()
-	expectAccount := ssoauth.Account{
-		Provider:    "login.example.com",
-		OpenID:      "AAAAAAA",
-		Username:    "test-user",
-		DisplayName: "Test User",
-		Email:       "test@example.com",
-		IsVerified:  true,
-		LastAuth:    now.Truncate(time.Microsecond),
-	}
-	discharge, err := discharger.Discharge(caveatID, &expectAccount, now.Add(time.Minute), now.Add(-1*time.Minute))
+	expectAccount := ssoauthtest.NewAccount().
+		WithProvider("login.example.com").
+		WithOpenID("AAAAAAA").
+		WithUsername("test-user").
+		WithDisplayName("Test User").
+		WithEmail("test@example.com").
+		Build()
+	discharge, err := discharger.Discharge(caveatID, expectAccount, now.Add(time.Minute), now.Add(-1*time.Minute))
 	c.Assert(err, qt.IsNil)
 	discharge.AddFirstPartyCaveat([]byte(discharge.Location() + "|unknown|unknown"))
+	discharge.AddFirstPartyCaveat([]byte(discharge.Location() + "|two_factor_enabled|true"))
 
 	discharge.Bind(m.M().Signature())
 	account, err := a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
 	c.Assert(err, qt.IsNil)
 
-	c.Assert(account, qt.DeepEquals, &expectAccount)
+	expectAccount.Extra = map[string]string{
+		"unknown":            "unknown",
+		"two_factor_enabled": "true",
+	}
+	c.Assert(account, qt.DeepEquals, expectAccount)
 }
 
 func TestMacaroonRoundTrip(t *testing.T) {
@@ -345,22 +632,440 @@ func TestMacaroonRoundTrip(t *testing.T) {
 	}
 
 	now := time.Now().UTC()
-	expectAccount := ssoauth.Account{
-		Provider:    "login.example.com",
-		OpenID:      "AAAAAAA",
-		Username:    "test-user",
-		DisplayName: "Test User",
-		Email:       "test@example.com",
-		IsVerified:  true,
-		LastAuth:    now.Truncate(time.Microsecond),
-	}
-	discharge, err := discharger.Discharge(caveatID, &expectAccount, now.Add(time.Minute), now.Add(-1*time.Minute))
+	expectAccount := ssoauthtest.NewAccount().
+		WithProvider("login.example.com").
+		WithOpenID("AAAAAAA").
+		WithUsername("test-user").
+		WithDisplayName("Test User").
+		WithEmail("test@example.com").
+		Build()
+	discharge, err := discharger.Discharge(caveatID, expectAccount, now.Add(time.Minute), now.Add(-1*time.Minute))
 	c.Assert(err, qt.IsNil)
 	discharge.Bind(m.Signature())
 
 	var acc ssoauth.Account
-	err = m.Verify(rk1[:], ssoauth.CaveatChecker(discharger.Location(), &acc), []*macaroon.Macaroon{discharge})
+	err = m.Verify(rk1[:], ssoauth.CaveatChecker(context.Background(), []string{discharger.Location()}, &acc), []*macaroon.Macaroon{discharge})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(&acc, qt.DeepEquals, expectAccount)
+}
+
+func TestCaveatCheckerCanceled(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	check := ssoauth.CaveatChecker(ctx, []string{discharger.Location()}, nil)
+	c.Assert(check(discharger.Location()+"|valid_since|2000-01-01T00:00:00.000000"), qt.IsNil)
+
+	cancel()
+	err := check(discharger.Location() + "|valid_since|2000-01-01T00:00:00.000000")
+	c.Assert(err, qt.Equals, context.Canceled)
+}
+
+func TestAuthenticateCanceled(t *testing.T) {
+	c := qt.New(t)
+
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+
+	caveatID, err := ssoauthtest.GetCaveatID(discharger, m.M())
+	c.Assert(err, qt.IsNil)
+	now := time.Now().UTC()
+	discharge, err := discharger.Discharge(caveatID, nil, now.Add(time.Minute), time.Time{})
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	account, err := a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+	c.Assert(account, qt.IsNil)
+}
+
+func TestAuthenticateAll(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	})
+
+	var slices []macaroon.Slice
+	var expectAccounts []*ssoauth.Account
+	for i := 0; i < 10; i++ {
+		m, err := a.Macaroon(ctx)
+		c.Assert(err, qt.IsNil)
+		if i%3 == 0 {
+			slices = append(slices, macaroon.Slice{m.M()})
+			expectAccounts = append(expectAccounts, nil)
+			continue
+		}
+		acc := ssoauthtest.NewAccount().WithProvider(discharger.Location()).WithOpenID(fmt.Sprintf("OPENID%d", i)).Build()
+		slices = append(slices, ssoauthtest.MustDischarge(discharger, m.M(), acc, time.Time{}, time.Time{}))
+		expectAccounts = append(expectAccounts, acc)
+	}
+
+	accs, errs := a.AuthenticateAll(ctx, slices)
+	c.Assert(accs, qt.HasLen, len(slices))
+	c.Assert(errs, qt.HasLen, len(slices))
+	for i := range slices {
+		if expectAccounts[i] == nil {
+			c.Check(accs[i], qt.IsNil, qt.Commentf("slice %d", i))
+			c.Check(errgo.Cause(errs[i]), qt.Equals, ssoauth.ErrUnauthorized, qt.Commentf("slice %d", i))
+			continue
+		}
+		c.Check(errs[i], qt.IsNil, qt.Commentf("slice %d", i))
+		c.Check(accs[i], ssoauthtest.AccountEquals, expectAccounts[i], qt.Commentf("slice %d", i))
+	}
+}
+
+func TestAuthenticateAllCanceled(t *testing.T) {
+	c := qt.New(t)
+
+	var al recordingAuditLogger
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Location:  discharger.Location(),
+	}, ssoauth.WithAuditLogger(&al))
+
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+	ms := ssoauthtest.MustDischarge(discharger, m.M(), ssoauthtest.NewAccount().WithOpenID("AAAAAAA").Build(), time.Time{}, time.Time{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	accs, errs := a.AuthenticateAll(ctx, []macaroon.Slice{ms, ms, ms})
+	c.Assert(accs, qt.DeepEquals, []*ssoauth.Account{nil, nil, nil})
+	c.Assert(errs, qt.HasLen, 3)
+	for _, err := range errs {
+		c.Check(err, qt.Equals, context.Canceled)
+	}
+	c.Check(al.events, qt.HasLen, 0)
+}
+
+func TestAuthenticateMultipleLocations(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Each discharger generates its own key.
+	prodDischarger := ssoauthtest.NewDischarger("login.example.com")
+	stagingDischarger := ssoauthtest.NewDischarger("login.staging.example.com")
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		Locations: []string{prodDischarger.Location(), stagingDischarger.Location()},
+		LocationPublicKeys: map[string]*rsa.PublicKey{
+			prodDischarger.Location():    prodDischarger.PublicKey(),
+			stagingDischarger.Location(): stagingDischarger.PublicKey(),
+		},
+	})
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	ms, err := ssoauthtest.Discharge(prodDischarger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+	acc, err := a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.Provider, qt.Equals, prodDischarger.Location())
+	c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+
+	m, err = a.MacaroonForLocation(ctx, stagingDischarger.Location())
+	c.Assert(err, qt.IsNil)
+	ms, err = ssoauthtest.Discharge(stagingDischarger, m.M(), &ssoauth.Account{OpenID: "BBBBBBB"}, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+	acc, err = a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.Provider, qt.Equals, stagingDischarger.Location())
+	c.Check(acc.OpenID, qt.Equals, "BBBBBBB")
+
+	// A macaroon for one location cannot be discharged with the key
+	// of another.
+	_, err = ssoauthtest.Discharge(prodDischarger, m.M(), &ssoauth.Account{OpenID: "BBBBBBB"}, time.Time{}, time.Time{})
+	c.Check(err, qt.Not(qt.IsNil))
+}
+
+func TestAuthenticateLocationPublicKeyFallback(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	stagingDischarger := ssoauthtest.NewDischarger("login.staging.example.com")
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: discharger.PublicKey(),
+		Locations: []string{discharger.Location(), stagingDischarger.Location()},
+		LocationPublicKeys: map[string]*rsa.PublicKey{
+			stagingDischarger.Location(): stagingDischarger.PublicKey(),
+		},
+	})
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	acc, err := a.Authenticate(ctx, ssoauthtest.MustDischarge(discharger, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.Provider, qt.Equals, discharger.Location())
+
+	m, err = a.MacaroonForLocation(ctx, stagingDischarger.Location())
+	c.Assert(err, qt.IsNil)
+	acc, err = a.Authenticate(ctx, ssoauthtest.MustDischarge(stagingDischarger, m.M(), &ssoauth.Account{OpenID: "BBBBBBB"}, time.Time{}, time.Time{}))
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.Provider, qt.Equals, stagingDischarger.Location())
+}
+
+func TestAuthenticateUntrustedLocation(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	o := bakery.NewOven(bakery.OvenParams{})
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      o,
+		PublicKey: discharger.PublicKey(),
+		Locations: []string{discharger.Location(), "login.staging.example.com"},
+	})
+
+	_, err := a.MacaroonForLocation(ctx, "login.evil.example.com")
+	c.Check(err, qt.ErrorMatches, `untrusted location "login.evil.example.com"`)
+
+	m, err := a.Macaroon(ctx)
 	c.Assert(err, qt.IsNil)
+	caveatID, err := ssoauthtest.GetCaveatID(discharger, m.M())
+	c.Assert(err, qt.IsNil)
+	discharge, err := discharger.Discharge(caveatID, nil, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+	discharge.AddFirstPartyCaveat([]byte("login.evil.example.com|account|eyJvcGVuaWQiOiJCQkJCQkJCIn0="))
+	discharge.Bind(m.M().Signature())
+	acc, err := a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+	c.Check(err, qt.ErrorMatches, `caveat "login.evil.example.com\|account\|.*" not satisfied: .*`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+	c.Check(acc, qt.IsNil)
+}
+
+var authenticateMinAuthAgeTests = []struct {
+	name        string
+	minAuthAge  time.Duration
+	lastAuth    time.Duration
+	expectError string
+}{{
+	name:       "within-window",
+	minAuthAge: 10 * time.Minute,
+	lastAuth:   -5 * time.Minute,
+}, {
+	name:        "outside-window",
+	minAuthAge:  10 * time.Minute,
+	lastAuth:    -15 * time.Minute,
+	expectError: `authentication too old`,
+}, {
+	name:        "no-last-auth",
+	minAuthAge:  10 * time.Minute,
+	expectError: `no last_auth caveat`,
+}, {
+	name:     "no-restriction",
+	lastAuth: -24 * time.Hour,
+}, {
+	name: "no-restriction-no-last-auth",
+}}
+
+func TestAuthenticateMinAuthAge(t *testing.T) {
+	c := qt.New(t)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range authenticateMinAuthAgeTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ctx := context.Background()
+			a := ssoauth.New(ssoauth.Params{
+				Oven:       bakery.NewOven(bakery.OvenParams{}),
+				PublicKey:  discharger.PublicKey(),
+				Location:   discharger.Location(),
+				MinAuthAge: test.minAuthAge,
+			}, ssoauth.WithClock(ssoauthtest.NewFakeClock(now)))
+
+			m, err := a.Macaroon(ctx)
+			c.Assert(err, qt.IsNil)
+			acc := &ssoauth.Account{OpenID: "AAAAAAA"}
+			if test.lastAuth != 0 {
+				acc.LastAuth = now.Add(test.lastAuth)
+			}
+			ms, err := ssoauthtest.Discharge(discharger, m.M(), acc, time.Time{}, time.Time{})
+			c.Assert(err, qt.IsNil)
+
+			acc, err = a.Authenticate(ctx, ms)
+			if test.expectError != "" {
+				c.Check(err, qt.ErrorMatches, test.expectError)
+				c.Check(errgo.Cause(err), qt.Equals, ssoauth.ErrUnauthorized)
+				c.Check(acc, qt.IsNil)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+		})
+	}
+}
+
+var validateTests = []struct {
+	name        string
+	params      func(p *ssoauth.Params)
+	expectError string
+}{{
+	name:   "valid",
+	params: func(p *ssoauth.Params) {},
+}, {
+	name: "valid-locations",
+	params: func(p *ssoauth.Params) {
+		p.Location = ""
+		p.Locations = []string{"login.example.com", "https://login.staging.example.com"}
+	},
+}, {
+	name: "valid-location-with-port",
+	params: func(p *ssoauth.Params) {
+		p.Location = "login.example.com:8443"
+	},
+}, {
+	name: "no-oven",
+	params: func(p *ssoauth.Params) {
+		p.Oven = nil
+	},
+	expectError: `invalid parameters: oven not specified`,
+}, {
+	name: "no-public-key",
+	params: func(p *ssoauth.Params) {
+		p.PublicKey = nil
+	},
+	expectError: `invalid parameters: public key not specified`,
+}, {
+	name: "small-public-key",
+	params: func(p *ssoauth.Params) {
+		p.PublicKey = &ssoauthtest.GenerateTestKey(1024).PublicKey
+	},
+	expectError: `invalid parameters: public key too small \(1024 bits, need at least 2048\)`,
+}, {
+	name: "ecdh-public-key",
+	params: func(p *ssoauth.Params) {
+		p.PublicKey = nil
+		p.ECDHPublicKey = discharger.ECDHPublicKey()
+	},
+}, {
+	name: "non-x25519-ecdh-public-key",
+	params: func(p *ssoauth.Params) {
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		p.ECDHPublicKey = key.PublicKey()
+	},
+	expectError: `invalid parameters: ECDH public key is not an X25519 key`,
+}, {
+	name: "location-public-keys",
+	params: func(p *ssoauth.Params) {
+		p.PublicKey = nil
+		p.LocationPublicKeys = map[string]*rsa.PublicKey{
+			discharger.Location(): discharger.PublicKey(),
+		}
+	},
+}, {
+	name: "location-public-keys-incomplete",
+	params: func(p *ssoauth.Params) {
+		p.PublicKey = nil
+		p.Location = ""
+		p.Locations = []string{discharger.Location(), "login.staging.example.com"}
+		p.LocationPublicKeys = map[string]*rsa.PublicKey{
+			discharger.Location(): discharger.PublicKey(),
+		}
+	},
+	expectError: `invalid parameters: public key not specified`,
+}, {
+	name: "location-public-key-unknown-location",
+	params: func(p *ssoauth.Params) {
+		p.LocationPublicKeys = map[string]*rsa.PublicKey{
+			"login.evil.example.com": discharger.PublicKey(),
+		}
+	},
+	expectError: `invalid parameters: public key for unknown location "login.evil.example.com"`,
+}, {
+	name: "location-public-key-nil",
+	params: func(p *ssoauth.Params) {
+		p.LocationPublicKeys = map[string]*rsa.PublicKey{
+			discharger.Location(): nil,
+		}
+	},
+	expectError: `invalid parameters: public key for location ".*" not specified`,
+}, {
+	name: "location-public-key-small",
+	params: func(p *ssoauth.Params) {
+		p.LocationPublicKeys = map[string]*rsa.PublicKey{
+			discharger.Location(): &ssoauthtest.GenerateTestKey(1024).PublicKey,
+		}
+	},
+	expectError: `invalid parameters: public key for location ".*" too small \(1024 bits, need at least 2048\)`,
+}, {
+	name: "no-location",
+	params: func(p *ssoauth.Params) {
+		p.Location = ""
+	},
+	expectError: `invalid parameters: location not specified`,
+}, {
+	name: "location-and-locations",
+	params: func(p *ssoauth.Params) {
+		p.Locations = []string{"login.staging.example.com"}
+	},
+	expectError: `invalid parameters: location and locations both specified`,
+}, {
+	name: "http-location",
+	params: func(p *ssoauth.Params) {
+		p.Location = "http://login.example.com"
+	},
+	expectError: `invalid parameters: invalid location "http://login.example.com"`,
+}, {
+	name: "location-with-path",
+	params: func(p *ssoauth.Params) {
+		p.Location = "login.example.com/path"
+	},
+	expectError: `invalid parameters: invalid location "login.example.com/path"`,
+}, {
+	name: "multiple-problems",
+	params: func(p *ssoauth.Params) {
+		p.Oven = nil
+		p.PublicKey = nil
+		p.Location = ""
+	},
+	expectError: `invalid parameters: oven not specified; public key not specified; location not specified`,
+}}
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range validateTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			p := ssoauth.Params{
+				Oven:      bakery.NewOven(bakery.OvenParams{}),
+				PublicKey: discharger.PublicKey(),
+				Location:  discharger.Location(),
+			}
+			test.params(&p)
+			err := p.Validate()
+			if test.expectError == "" {
+				c.Check(err, qt.IsNil)
+				return
+			}
+			c.Check(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+func TestNewInvalidParams(t *testing.T) {
+	c := qt.New(t)
 
-	c.Assert(acc, qt.DeepEquals, expectAccount)
+	c.Check(func() {
+		ssoauth.New(ssoauth.Params{Location: discharger.Location()})
+	}, qt.PanicMatches, `invalid parameters: oven not specified; public key not specified`)
 }
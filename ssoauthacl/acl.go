@@ -9,8 +9,13 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/errgo.v1"
 
 	"github.com/canonical/ssoauth"
 )
@@ -27,6 +32,46 @@ type IdentityMatcher interface {
 	MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error)
 }
 
+// A MatcherFunc is a function that implements IdentityMatcher.
+type MatcherFunc func(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error)
+
+// MatchIdentity implements IdentityMatcher by calling f.
+func (f MatcherFunc) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	return f(ctx, acc, ids)
+}
+
+// MatchAny reports whether the given account matches at least one of
+// the given identities according to m. Any error from m is returned
+// along with the result determined from the identities it did match.
+func MatchAny(ctx context.Context, m IdentityMatcher, acc *ssoauth.Account, ids []string) (bool, error) {
+	if len(ids) == 0 {
+		return false, nil
+	}
+	mids, err := m.MatchIdentity(ctx, acc, ids)
+	return len(mids) > 0, err
+}
+
+// MatchAll reports whether the given account matches every one of the
+// given identities according to m. If ids is empty MatchAll returns
+// false. Any error from m is returned along with the result determined
+// from the identities it did match.
+func MatchAll(ctx context.Context, m IdentityMatcher, acc *ssoauth.Account, ids []string) (bool, error) {
+	if len(ids) == 0 {
+		return false, nil
+	}
+	mids, err := m.MatchIdentity(ctx, acc, ids)
+	matched := make(map[string]bool, len(mids))
+	for _, id := range mids {
+		matched[id] = true
+	}
+	for _, id := range ids {
+		if !matched[id] {
+			return false, err
+		}
+	}
+	return true, err
+}
+
 // An account matcher is an IdentityMatcher that only matches the
 // identity identified in the account. The identity must be specified as
 // a url of the form "https://{Provider}/+id/{OpenID}".
@@ -45,6 +90,162 @@ func (AccountMatcher) MatchIdentity(_ context.Context, acc *ssoauth.Account, ids
 	return match, nil
 }
 
+// An AllowAllMatcher is an IdentityMatcher that matches every identity
+// for every account.
+type AllowAllMatcher struct{}
+
+// MatchIdentity implements IdentityMatcher.
+func (AllowAllMatcher) MatchIdentity(_ context.Context, _ *ssoauth.Account, ids []string) ([]string, error) {
+	return append([]string{}, ids...), nil
+}
+
+// A DenyAllMatcher is an IdentityMatcher that never matches any
+// identity.
+type DenyAllMatcher struct{}
+
+// MatchIdentity implements IdentityMatcher.
+func (DenyAllMatcher) MatchIdentity(context.Context, *ssoauth.Account, []string) ([]string, error) {
+	return []string{}, nil
+}
+
+// An ErrorMatcher is an IdentityMatcher that never matches any identity
+// and always returns Err.
+type ErrorMatcher struct {
+	Err error
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m ErrorMatcher) MatchIdentity(context.Context, *ssoauth.Account, []string) ([]string, error) {
+	return nil, m.Err
+}
+
+// A StaticMatcher is an IdentityMatcher that matches a fixed set of
+// identities for every account, regardless of the account.
+type StaticMatcher struct {
+	// Allowed holds the set of identities that are matched.
+	Allowed map[string]struct{}
+}
+
+// NewStaticMatcher creates a StaticMatcher that matches the given
+// identities.
+func NewStaticMatcher(ids ...string) StaticMatcher {
+	return NewStaticMatcherFromSlice(ids)
+}
+
+// NewStaticMatcherFromSlice creates a StaticMatcher that matches the
+// identities in the given slice.
+func NewStaticMatcherFromSlice(ids []string) StaticMatcher {
+	allowed := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		allowed[id] = struct{}{}
+	}
+	return StaticMatcher{Allowed: allowed}
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m StaticMatcher) MatchIdentity(_ context.Context, _ *ssoauth.Account, ids []string) ([]string, error) {
+	match := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := m.Allowed[id]; ok {
+			match = append(match, id)
+		}
+	}
+	return match, nil
+}
+
+// An EmailDomainMatcher is an IdentityMatcher that matches accounts with
+// a verified email address in a particular domain. The identity must be
+// specified in the form "email-domain:{domain}", identities in any other
+// form are ignored.
+type EmailDomainMatcher struct{}
+
+// MatchIdentity implements IdentityMatcher.
+func (EmailDomainMatcher) MatchIdentity(_ context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	match := make([]string, 0, 1)
+	if !acc.IsVerified || acc.Email == "" {
+		return match, nil
+	}
+	email := strings.ToLower(acc.Email)
+	for _, id := range ids {
+		domain := strings.TrimPrefix(id, "email-domain:")
+		if domain == id || domain == "" {
+			continue
+		}
+		if strings.HasSuffix(email, "@"+strings.ToLower(domain)) {
+			match = append(match, id)
+		}
+	}
+	return match, nil
+}
+
+// A UsernameMatcher is an IdentityMatcher that matches accounts by
+// username. The identity must be specified in the form
+// "username:{name}", the name is compared to the account's username
+// case-insensitively. Identities in any other form are ignored.
+type UsernameMatcher struct{}
+
+// MatchIdentity implements IdentityMatcher.
+func (UsernameMatcher) MatchIdentity(_ context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	match := make([]string, 0, 1)
+	if acc.Username == "" {
+		return match, nil
+	}
+	for _, id := range ids {
+		name := strings.TrimPrefix(id, "username:")
+		if name == id {
+			continue
+		}
+		if strings.EqualFold(name, acc.Username) {
+			match = append(match, id)
+		}
+	}
+	return match, nil
+}
+
+// A RegexMatcher is an IdentityMatcher that matches all the given
+// identities if a field of the account matches a regular expression,
+// and none of them otherwise.
+type RegexMatcher struct {
+	// Pattern holds the regular expression to match. It must not be
+	// nil.
+	Pattern *regexp.Regexp
+
+	// Field holds the function used to extract the value to match
+	// from an account. It must not be nil.
+	Field func(*ssoauth.Account) string
+}
+
+// NewUsernameRegexMatcher creates a RegexMatcher that matches the
+// account's username against the given pattern. It panics if the
+// pattern is not a valid regular expression.
+func NewUsernameRegexMatcher(pattern string) RegexMatcher {
+	return RegexMatcher{
+		Pattern: regexp.MustCompile(pattern),
+		Field:   func(acc *ssoauth.Account) string { return acc.Username },
+	}
+}
+
+// NewEmailRegexMatcher creates a RegexMatcher that matches the
+// account's email address against the given pattern. It panics if the
+// pattern is not a valid regular expression.
+func NewEmailRegexMatcher(pattern string) RegexMatcher {
+	return RegexMatcher{
+		Pattern: regexp.MustCompile(pattern),
+		Field:   func(acc *ssoauth.Account) string { return acc.Email },
+	}
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m RegexMatcher) MatchIdentity(_ context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	if m.Pattern == nil {
+		panic("ssoauthacl: RegexMatcher has no pattern")
+	}
+	if !m.Pattern.MatchString(m.Field(acc)) {
+		return []string{}, nil
+	}
+	return append([]string(nil), ids...), nil
+}
+
 // An ACLMatcher is an IdentityMatcher that matches against a list of
 // identities by delegating to particular matchers for each identity.
 type ACLMatcher map[string]IdentityMatcher
@@ -53,22 +254,16 @@ type ACLMatcher map[string]IdentityMatcher
 //
 // Every identity is parsed as a URL, the host is used as the key in the
 // ACLMatcher to find the particular IdentityMatcher to use for that
-// identity. If the identity is not a valid URL, or there is no
-// IdentityMatcher for the host then the account does not match that
+// identity. Identities of the form "{scheme}:{value}", such as
+// "username:jsmith", have no host and use the scheme as the key
+// instead. If the identity is not a valid URL, or there is no
+// IdentityMatcher for the key then the account does not match that
 // identity. If an IdentityMatcher returns an error it will be bundled
 // with any errors from other identity matchers into an ACLMatchError
 // structure, this is the only error type returned by this
 // IdentityMatcher.
 func (m ACLMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
-	idmap := make(map[string][]string)
-
-	for _, id := range ids {
-		u, err := url.Parse(id)
-		if err != nil {
-			continue
-		}
-		idmap[u.Host] = append(idmap[u.Host], id)
-	}
+	idmap := groupIdentities(ids)
 
 	matchids := make([]string, 0, len(ids))
 	errs := make(map[string]error)
@@ -90,12 +285,133 @@ func (m ACLMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids
 	return matchids, nil
 }
 
+// A SyncACLMatcher is an IdentityMatcher that behaves like an
+// ACLMatcher, but which can be safely updated while in use.
+type SyncACLMatcher struct {
+	mu sync.RWMutex
+	m  ACLMatcher
+}
+
+// NewACLMatcher creates a new SyncACLMatcher with no IdentityMatchers.
+func NewACLMatcher() *SyncACLMatcher {
+	return &SyncACLMatcher{
+		m: make(ACLMatcher),
+	}
+}
+
+// Add sets the IdentityMatcher to use for identities with the given
+// host, replacing any existing IdentityMatcher for the host.
+func (m *SyncACLMatcher) Add(host string, matcher IdentityMatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m[host] = matcher
+}
+
+// Remove removes the IdentityMatcher for the given host.
+func (m *SyncACLMatcher) Remove(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.m, host)
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m *SyncACLMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	// Take a copy of the matchers so that the lock is not held while
+	// any potentially slow IdentityMatchers are consulted.
+	m.mu.RLock()
+	acl := make(ACLMatcher, len(m.m))
+	for k, v := range m.m {
+		acl[k] = v
+	}
+	m.mu.RUnlock()
+	return acl.MatchIdentity(ctx, acc, ids)
+}
+
+// A ConcurrentACLMatcher is an IdentityMatcher that behaves like an
+// ACLMatcher, except that the IdentityMatchers for each host are
+// consulted concurrently.
+type ConcurrentACLMatcher ACLMatcher
+
+// MatchIdentity implements IdentityMatcher.
+func (m ConcurrentACLMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	idmap := groupIdentities(ids)
+
+	var mu sync.Mutex
+	matchids := make([]string, 0, len(ids))
+	errs := make(map[string]error)
+	var eg errgroup.Group
+	for k, v := range idmap {
+		k, v := k, v
+		matcher := m[k]
+		if matcher == nil {
+			continue
+		}
+		eg.Go(func() error {
+			mids, err := matcher.MatchIdentity(ctx, acc, v)
+			mu.Lock()
+			defer mu.Unlock()
+			matchids = append(matchids, mids...)
+			if err != nil {
+				errs[k] = err
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+
+	if len(errs) > 0 {
+		return matchids, &ACLMatchError{Errors: errs}
+	}
+	return matchids, nil
+}
+
+// groupIdentities groups the given identities by the key used to find
+// their IdentityMatcher in an ACLMatcher.
+func groupIdentities(ids []string) map[string][]string {
+	idmap := make(map[string][]string)
+	for _, id := range ids {
+		u, err := url.Parse(id)
+		if err != nil {
+			continue
+		}
+		key := u.Host
+		if key == "" && u.Opaque != "" {
+			key = u.Scheme
+		}
+		idmap[key] = append(idmap[key], id)
+	}
+	return idmap
+}
+
+// ErrACLMatchFailed matches any ACLMatchError when using errors.Is.
+var ErrACLMatchFailed = errgo.New("ACL match failed")
+
 // An ACLMatchError is the error returned from an ACLMatcher if any of
 // the IdentityMatchers returns an error.
 type ACLMatchError struct {
 	Errors map[string]error
 }
 
+// Is reports whether target is ErrACLMatchFailed.
+func (e *ACLMatchError) Is(target error) bool {
+	return target == ErrACLMatchFailed
+}
+
+// Unwrap returns the errors returned from the IdentityMatchers, ordered
+// by key.
+func (e *ACLMatchError) Unwrap() []error {
+	keys := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	errs := make([]error, len(keys))
+	for i, k := range keys {
+		errs[i] = e.Errors[k]
+	}
+	return errs
+}
+
 // Error implements the error interface.
 func (e *ACLMatchError) Error() string {
 	errs := make([]string, 0, len(e.Errors))
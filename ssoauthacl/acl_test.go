@@ -5,15 +5,23 @@ package ssoauthacl_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"gopkg.in/errgo.v1"
 
 	"github.com/canonical/ssoauth"
 	"github.com/canonical/ssoauth/ssoauthacl"
+	"github.com/canonical/ssoauth/ssoauthtest"
 )
 
+var _ ssoauthacl.IdentityMatcher = (*ssoauthtest.FakeACL)(nil)
+
 func TestIdentityMatcher(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
@@ -32,6 +40,294 @@ func TestIdentityMatcher(t *testing.T) {
 	c.Check(ids, qt.HasLen, 0)
 }
 
+type ctxKey struct{}
+
+func TestMatcherFunc(t *testing.T) {
+	c := qt.New(t)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "test-value")
+	acc := &ssoauth.Account{OpenID: "AAAAAAA"}
+	testErr := errgo.New("test error")
+	var called bool
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.MatcherFunc(func(ctx1 context.Context, acc1 *ssoauth.Account, ids []string) ([]string, error) {
+		called = true
+		c.Check(ctx1.Value(ctxKey{}), qt.Equals, "test-value")
+		c.Check(acc1, qt.Equals, acc)
+		c.Check(ids, qt.DeepEquals, []string{"a", "b"})
+		return ids[:1], testErr
+	})
+	ids, err := m.MatchIdentity(ctx, acc, []string{"a", "b"})
+	c.Check(called, qt.IsTrue)
+	c.Check(err, qt.Equals, testErr)
+	c.Check(ids, qt.DeepEquals, []string{"a"})
+}
+
+var matchAnyAllTests = []struct {
+	name      string
+	ids       []string
+	expectAny bool
+	expectAll bool
+}{{
+	name: "empty-ids",
+}, {
+	name:      "partial-match",
+	ids:       []string{"username:jsmith", "username:jdoe"},
+	expectAny: true,
+}, {
+	name:      "full-match",
+	ids:       []string{"username:jsmith", "username:JSmith"},
+	expectAny: true,
+	expectAll: true,
+}, {
+	name: "no-match",
+	ids:  []string{"username:jdoe"},
+}}
+
+func TestMatchAnyAll(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	acc := &ssoauth.Account{Username: "jsmith"}
+	for _, test := range matchAnyAllTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ok, err := ssoauthacl.MatchAny(ctx, ssoauthacl.UsernameMatcher{}, acc, test.ids)
+			c.Check(err, qt.IsNil)
+			c.Check(ok, qt.Equals, test.expectAny)
+			ok, err = ssoauthacl.MatchAll(ctx, ssoauthacl.UsernameMatcher{}, acc, test.ids)
+			c.Check(err, qt.IsNil)
+			c.Check(ok, qt.Equals, test.expectAll)
+		})
+	}
+}
+
+func TestMatchAnyAllError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	testErr := errgo.New("test error")
+	m := ssoauthacl.ErrorMatcher{Err: testErr}
+	ok, err := ssoauthacl.MatchAny(ctx, m, &ssoauth.Account{}, []string{"a"})
+	c.Check(err, qt.Equals, testErr)
+	c.Check(ok, qt.IsFalse)
+	ok, err = ssoauthacl.MatchAll(ctx, m, &ssoauth.Account{}, []string{"a"})
+	c.Check(err, qt.Equals, testErr)
+	c.Check(ok, qt.IsFalse)
+}
+
+func TestAllowAllMatcher(t *testing.T) {
+	c := qt.New(t)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.AllowAllMatcher{}
+	ids, err := m.MatchIdentity(context.Background(), &ssoauth.Account{}, []string{"a", "b"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"a", "b"})
+}
+
+func TestDenyAllMatcher(t *testing.T) {
+	c := qt.New(t)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.DenyAllMatcher{}
+	ids, err := m.MatchIdentity(context.Background(), &ssoauth.Account{}, []string{"a", "b"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.HasLen, 0)
+}
+
+func TestErrorMatcher(t *testing.T) {
+	c := qt.New(t)
+
+	testErr := errgo.New("test error")
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.ErrorMatcher{Err: testErr}
+	ids, err := m.MatchIdentity(context.Background(), &ssoauth.Account{}, []string{"a", "b"})
+	c.Check(err, qt.Equals, testErr)
+	c.Check(ids, qt.HasLen, 0)
+}
+
+var emailDomainMatcherTests = []struct {
+	name        string
+	acc         *ssoauth.Account
+	ids         []string
+	expectMatch []string
+}{{
+	name: "matching-domain",
+	acc:  &ssoauth.Account{Email: "test@canonical.com", IsVerified: true},
+	ids: []string{
+		"email-domain:example.com",
+		"email-domain:canonical.com",
+		"canonical.com",
+		"https://canonical.com",
+	},
+	expectMatch: []string{"email-domain:canonical.com"},
+}, {
+	name:        "matching-domain-case",
+	acc:         &ssoauth.Account{Email: "Test@Canonical.COM", IsVerified: true},
+	ids:         []string{"email-domain:canonical.com"},
+	expectMatch: []string{"email-domain:canonical.com"},
+}, {
+	name:        "non-matching-domain",
+	acc:         &ssoauth.Account{Email: "test@notcanonical.com", IsVerified: true},
+	ids:         []string{"email-domain:canonical.com"},
+	expectMatch: []string{},
+}, {
+	name:        "unverified-email",
+	acc:         &ssoauth.Account{Email: "test@canonical.com"},
+	ids:         []string{"email-domain:canonical.com"},
+	expectMatch: []string{},
+}, {
+	name:        "empty-email",
+	acc:         &ssoauth.Account{IsVerified: true},
+	ids:         []string{"email-domain:canonical.com", "email-domain:"},
+	expectMatch: []string{},
+}}
+
+func TestStaticMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	acc := &ssoauth.Account{OpenID: "AAAAAAA"}
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.NewStaticMatcher("https://login.example.com/+id/AAAAAAA", "https://launchpad.net/~test")
+	ids, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test", "https://launchpad.net/~other"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test"})
+
+	ids, err = m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~other"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.HasLen, 0)
+
+	m = ssoauthacl.NewStaticMatcherFromSlice([]string{"https://launchpad.net/~other"})
+	ids, err = m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test", "https://launchpad.net/~other"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~other"})
+
+	for _, m := range []ssoauthacl.IdentityMatcher{ssoauthacl.NewStaticMatcher(), ssoauthacl.StaticMatcher{}} {
+		ids, err = m.MatchIdentity(ctx, acc, []string{"https://login.example.com/+id/AAAAAAA"})
+		c.Assert(err, qt.IsNil)
+		c.Check(ids, qt.HasLen, 0)
+	}
+}
+
+func TestEmailDomainMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.EmailDomainMatcher{}
+	for _, test := range emailDomainMatcherTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ids, err := m.MatchIdentity(ctx, test.acc, test.ids)
+			c.Assert(err, qt.IsNil)
+			c.Check(ids, qt.DeepEquals, test.expectMatch)
+		})
+	}
+}
+
+var usernameMatcherTests = []struct {
+	name        string
+	acc         *ssoauth.Account
+	ids         []string
+	expectMatch []string
+}{{
+	name:        "exact-match",
+	acc:         &ssoauth.Account{Username: "jsmith"},
+	ids:         []string{"username:jsmith", "username:jdoe", "jsmith"},
+	expectMatch: []string{"username:jsmith"},
+}, {
+	name:        "case-insensitive-match",
+	acc:         &ssoauth.Account{Username: "JSmith"},
+	ids:         []string{"username:jsmith"},
+	expectMatch: []string{"username:jsmith"},
+}, {
+	name:        "mismatch",
+	acc:         &ssoauth.Account{Username: "jsmith"},
+	ids:         []string{"username:jsmith2"},
+	expectMatch: []string{},
+}, {
+	name:        "empty-username",
+	acc:         &ssoauth.Account{},
+	ids:         []string{"username:", "username:jsmith"},
+	expectMatch: []string{},
+}}
+
+func TestUsernameMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.UsernameMatcher{}
+	for _, test := range usernameMatcherTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ids, err := m.MatchIdentity(ctx, test.acc, test.ids)
+			c.Assert(err, qt.IsNil)
+			c.Check(ids, qt.DeepEquals, test.expectMatch)
+		})
+	}
+}
+
+func TestACLMatcherPseudoHosts(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	acc := &ssoauth.Account{
+		Provider:   "login.example.com",
+		OpenID:     "AAAAAAA",
+		Username:   "jsmith",
+		Email:      "jsmith@example.com",
+		IsVerified: true,
+	}
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.ACLMatcher{
+		"login.example.com": ssoauthacl.AccountMatcher{},
+		"username":          ssoauthacl.UsernameMatcher{},
+		"email-domain":      ssoauthacl.EmailDomainMatcher{},
+	}
+
+	ids, err := m.MatchIdentity(ctx, acc, []string{
+		"https://login.example.com/+id/BBBBBBB",
+		"username:jsmith",
+		"username:jdoe",
+		"email-domain:example.com",
+		"email-domain:example.org",
+	})
+	c.Check(err, qt.IsNil)
+	sort.Strings(ids)
+	c.Check(ids, qt.DeepEquals, []string{"email-domain:example.com", "username:jsmith"})
+}
+
+func TestRegexMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	ids := []string{"group:contractors", "group:staff"}
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.NewUsernameRegexMatcher(`^contractor-.*`)
+	mids, err := m.MatchIdentity(ctx, &ssoauth.Account{Username: "contractor-jsmith"}, ids)
+	c.Assert(err, qt.IsNil)
+	c.Check(mids, qt.DeepEquals, ids)
+
+	mids, err = m.MatchIdentity(ctx, &ssoauth.Account{Username: "jsmith"}, ids)
+	c.Assert(err, qt.IsNil)
+	c.Check(mids, qt.HasLen, 0)
+
+	m = ssoauthacl.NewEmailRegexMatcher(`@example\.com$`)
+	mids, err = m.MatchIdentity(ctx, &ssoauth.Account{Email: "jsmith@example.com"}, ids)
+	c.Assert(err, qt.IsNil)
+	c.Check(mids, qt.DeepEquals, ids)
+
+	mids, err = m.MatchIdentity(ctx, &ssoauth.Account{Email: "jsmith@example.com.evil"}, ids)
+	c.Assert(err, qt.IsNil)
+	c.Check(mids, qt.HasLen, 0)
+}
+
+func TestRegexMatcherNilPattern(t *testing.T) {
+	c := qt.New(t)
+
+	m := ssoauthacl.RegexMatcher{
+		Field: func(acc *ssoauth.Account) string { return acc.Username },
+	}
+	c.Check(func() {
+		m.MatchIdentity(context.Background(), &ssoauth.Account{}, nil)
+	}, qt.PanicMatches, `ssoauthacl: RegexMatcher has no pattern`)
+}
+
 func TestACLMatcher(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
@@ -66,9 +362,9 @@ func TestACLMatcherError(t *testing.T) {
 	}
 
 	var m ssoauthacl.IdentityMatcher = ssoauthacl.ACLMatcher{
-		"1.example.com": errorMatcher{errgo.New("error 1")},
+		"1.example.com": ssoauthacl.ErrorMatcher{Err: errgo.New("error 1")},
 		"2.example.com": ssoauthacl.AccountMatcher{},
-		"3.example.com": errorMatcher{errgo.New("error 3")},
+		"3.example.com": ssoauthacl.ErrorMatcher{Err: errgo.New("error 3")},
 	}
 
 	ids, err := m.MatchIdentity(ctx, acc, []string{
@@ -82,10 +378,156 @@ func TestACLMatcherError(t *testing.T) {
 	c.Check(ids, qt.DeepEquals, []string{"https://2.example.com/+id/AAAAAAA"})
 }
 
-type errorMatcher struct {
-	err error
+func TestACLMatchErrorUnwrap(t *testing.T) {
+	c := qt.New(t)
+
+	err1 := errgo.New("error 1")
+	err2 := context.DeadlineExceeded
+	var err error = &ssoauthacl.ACLMatchError{
+		Errors: map[string]error{
+			"2.example.com": err2,
+			"1.example.com": err1,
+		},
+	}
+	c.Check(errors.Is(err, ssoauthacl.ErrACLMatchFailed), qt.IsTrue)
+	c.Check(errors.Is(err, err1), qt.IsTrue)
+	c.Check(errors.Is(err, context.DeadlineExceeded), qt.IsTrue)
+	c.Check(errors.Is(err, context.Canceled), qt.IsFalse)
+
+	var aclErr *ssoauthacl.ACLMatchError
+	c.Check(errors.As(fmt.Errorf("wrapped: %w", err), &aclErr), qt.IsTrue)
+	c.Check(aclErr, qt.Equals, err)
+	c.Check(aclErr.Unwrap(), qt.DeepEquals, []error{err1, err2})
+}
+
+func TestSyncACLMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	acc := &ssoauth.Account{
+		Provider: "1.example.com",
+		OpenID:   "AAAAAAA",
+	}
+	ids := []string{
+		"https://1.example.com/+id/AAAAAAA",
+		"username:jsmith",
+	}
+
+	m := ssoauthacl.NewACLMatcher()
+	mids, err := m.MatchIdentity(ctx, acc, ids)
+	c.Check(err, qt.IsNil)
+	c.Check(mids, qt.HasLen, 0)
+
+	m.Add("1.example.com", ssoauthacl.AccountMatcher{})
+	mids, err = m.MatchIdentity(ctx, acc, ids)
+	c.Check(err, qt.IsNil)
+	c.Check(mids, qt.DeepEquals, []string{"https://1.example.com/+id/AAAAAAA"})
+
+	m.Remove("1.example.com")
+	mids, err = m.MatchIdentity(ctx, acc, ids)
+	c.Check(err, qt.IsNil)
+	c.Check(mids, qt.HasLen, 0)
 }
 
-func (m errorMatcher) MatchIdentity(context.Context, *ssoauth.Account, []string) ([]string, error) {
-	return nil, m.err
+func TestSyncACLMatcherConcurrentUpdates(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	acc := &ssoauth.Account{
+		Provider: "1.example.com",
+		OpenID:   "AAAAAAA",
+		Username: "jsmith",
+	}
+	ids := []string{
+		"https://1.example.com/+id/AAAAAAA",
+		"username:jsmith",
+	}
+
+	m := ssoauthacl.NewACLMatcher()
+	m.Add("1.example.com", ssoauthacl.AccountMatcher{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.Add("username", ssoauthacl.UsernameMatcher{})
+			m.Remove("username")
+		}()
+		go func() {
+			defer wg.Done()
+			mids, err := m.MatchIdentity(ctx, acc, ids)
+			c.Check(err, qt.IsNil)
+			c.Check(mids, qt.Contains, "https://1.example.com/+id/AAAAAAA")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentACLMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	acc := &ssoauth.Account{
+		Provider: "2.example.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	// Each matcher waits for all the others to have started, which
+	// can only happen if they run concurrently.
+	var started sync.WaitGroup
+	started.Add(3)
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+	wait := func(m ssoauthacl.IdentityMatcher) ssoauthacl.IdentityMatcher {
+		return ssoauthacl.MatcherFunc(func(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+			started.Done()
+			select {
+			case <-allStarted:
+			case <-time.After(5 * time.Second):
+				return nil, errgo.New("matchers not run concurrently")
+			}
+			return m.MatchIdentity(ctx, acc, ids)
+		})
+	}
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.ConcurrentACLMatcher{
+		"1.example.com": wait(ssoauthacl.ErrorMatcher{Err: errgo.New("error 1")}),
+		"2.example.com": wait(ssoauthacl.AccountMatcher{}),
+		"3.example.com": wait(ssoauthacl.AccountMatcher{}),
+	}
+
+	ids, err := m.MatchIdentity(ctx, acc, []string{
+		"https://3.example.com/+id/AAAAAAA",
+		"https://1.example.com/+id/AAAAAAA",
+		"https://2.example.com/+id/AAAAAAA",
+		"https://4.example.com/+id/AAAAAAA",
+	})
+	c.Check(err, qt.ErrorMatches, `some matchers failed \[1.example.com: error 1\]`)
+	_, ok := err.(*ssoauthacl.ACLMatchError)
+	c.Check(ok, qt.Equals, true)
+	c.Check(ids, qt.DeepEquals, []string{"https://2.example.com/+id/AAAAAAA"})
+}
+
+func TestConcurrentACLMatcherCanceled(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	block := ssoauthacl.MatcherFunc(func(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.ConcurrentACLMatcher{
+		"1.example.com": block,
+		"2.example.com": block,
+	}
+	_, err := m.MatchIdentity(ctx, &ssoauth.Account{}, []string{
+		"https://1.example.com/+id/AAAAAAA",
+		"https://2.example.com/+id/AAAAAAA",
+	})
+	c.Check(err, qt.ErrorMatches, `some matchers failed \[1.example.com: context canceled; 2.example.com: context canceled\]`)
 }
@@ -0,0 +1,108 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"sync"
+	"time"
+)
+
+// A Breaker determines whether requests to a failing service should be
+// attempted.
+type Breaker interface {
+	// Allow reports whether a request should be attempted.
+	Allow() bool
+
+	// RecordSuccess records that an attempted request succeeded.
+	RecordSuccess()
+
+	// RecordFailure records that an attempted request failed.
+	RecordFailure()
+}
+
+// A CircuitBreaker is a Breaker that stops allowing requests after a
+// number of consecutive failures. Once the breaker has been open for a
+// period a single trial request is allowed, if that succeeds the
+// breaker closes again, otherwise it reopens. If the outcome of the
+// trial is never recorded, for example because the caller gave up, a
+// new trial is allowed once the open period has passed again. A
+// CircuitBreaker is safe for concurrent use.
+type CircuitBreaker struct {
+	failureThreshold int
+	openDuration     time.Duration
+	now              func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+
+	// openedAt holds the time the breaker last opened, or, when
+	// half-open, the time the trial request was allowed.
+	openedAt time.Time
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// NewCircuitBreaker creates a CircuitBreaker that opens after
+// failureThreshold consecutive failures and stays open for
+// openDuration.
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		now:              time.Now,
+	}
+}
+
+// Allow implements Breaker.Allow.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+		// Allow a single trial request.
+		b.state = breakerHalfOpen
+		b.openedAt = b.now()
+		return true
+	case breakerHalfOpen:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			// A trial request is already in progress.
+			return false
+		}
+		// The outcome of the trial was never recorded, so
+		// allow another.
+		b.openedAt = b.now()
+		return true
+	default:
+		return true
+	}
+}
+
+// RecordSuccess implements Breaker.RecordSuccess.
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// RecordFailure implements Breaker.RecordFailure.
+func (b *CircuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"launchpad.net/lpad"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	c := qt.New(t)
+
+	clock := ssoauthtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := ssoauthacl.NewCircuitBreaker(2, time.Minute)
+	ssoauthacl.SetCircuitBreakerClock(b, clock.Now)
+
+	// Closed.
+	c.Check(b.Allow(), qt.IsTrue)
+	b.RecordFailure()
+	c.Check(b.Allow(), qt.IsTrue)
+	b.RecordSuccess()
+	b.RecordFailure()
+	c.Check(b.Allow(), qt.IsTrue)
+
+	// Open after two consecutive failures.
+	b.RecordFailure()
+	c.Check(b.Allow(), qt.IsFalse)
+	clock.Advance(59 * time.Second)
+	c.Check(b.Allow(), qt.IsFalse)
+
+	// Half-open once the open duration has passed, allowing a single
+	// trial request.
+	clock.Advance(time.Second)
+	c.Check(b.Allow(), qt.IsTrue)
+	c.Check(b.Allow(), qt.IsFalse)
+
+	// A trial whose outcome is never recorded is abandoned after the
+	// open duration, allowing another.
+	clock.Advance(59 * time.Second)
+	c.Check(b.Allow(), qt.IsFalse)
+	clock.Advance(time.Second)
+	c.Check(b.Allow(), qt.IsTrue)
+	c.Check(b.Allow(), qt.IsFalse)
+
+	// A failed trial reopens the breaker.
+	b.RecordFailure()
+	c.Check(b.Allow(), qt.IsFalse)
+	clock.Advance(time.Minute)
+	c.Check(b.Allow(), qt.IsTrue)
+
+	// A successful trial closes it.
+	b.RecordSuccess()
+	c.Check(b.Allow(), qt.IsTrue)
+	c.Check(b.Allow(), qt.IsTrue)
+	b.RecordFailure()
+	c.Check(b.Allow(), qt.IsTrue)
+}
+
+func TestLaunchpadTeamMatcherCircuitBreaker(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:        lpad.APIBase(srv.URL),
+		CircuitBreaker: ssoauthacl.NewCircuitBreaker(2, time.Hour),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	var peopleRequests uint32
+	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&peopleRequests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+		c.Check(err, qt.ErrorMatches, `Server returned 503 and body: unavailable\n`)
+	}
+	_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+	c.Check(err, qt.ErrorMatches, `launchpad API circuit breaker open`)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauthacl.ErrCircuitOpen)
+	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(2))
+}
+
+func TestLaunchpadTeamMatcherCircuitBreakerCanceledTrial(t *testing.T) {
+	c := qt.New(t)
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+	})
+	// mode is 0 while the API is failing, 1 while requests block
+	// until the client gives up and 2 once the API has recovered.
+	var mode uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch atomic.LoadUint32(&mode) {
+		case 0:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 1:
+			<-req.Context().Done()
+		default:
+			lp.ServeHTTP(w, req)
+		}
+	}))
+	c.Cleanup(srv.Close)
+
+	clock := ssoauthtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := ssoauthacl.NewCircuitBreaker(1, time.Minute)
+	ssoauthacl.SetCircuitBreakerClock(b, clock.Now)
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:        lpad.APIBase(srv.URL),
+		CircuitBreaker: b,
+	}
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+	ids := []string{"https://launchpad.net/~test1"}
+
+	_, err := m.MatchIdentity(context.Background(), acc, ids)
+	c.Check(err, qt.ErrorMatches, `Server returned 503 and body: unavailable\n`)
+
+	// The trial request is abandoned by the caller.
+	clock.Advance(time.Minute)
+	atomic.StoreUint32(&mode, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = m.MatchIdentity(ctx, acc, ids)
+	c.Check(err, qt.ErrorMatches, `.*context deadline exceeded`)
+
+	atomic.StoreUint32(&mode, 2)
+	_, err = m.MatchIdentity(context.Background(), acc, ids)
+	c.Check(errgo.Cause(err), qt.Equals, ssoauthacl.ErrCircuitOpen)
+
+	// Once the open duration has passed a new trial is allowed.
+	clock.Advance(time.Minute)
+	matched, err := m.MatchIdentity(context.Background(), acc, ids)
+	c.Assert(err, qt.IsNil)
+	c.Check(matched, qt.DeepEquals, ids)
+}
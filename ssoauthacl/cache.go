@@ -0,0 +1,225 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats holds statistics about the use of a cache.
+type CacheStats struct {
+	// Hits holds the number of Get calls that found a value.
+	Hits uint64
+
+	// Misses holds the number of Get calls that found no value.
+	Misses uint64
+
+	// Evictions holds the number of entries removed from the cache
+	// because they expired or to make room for new entries.
+	Evictions uint64
+}
+
+// A StatsCache is a Cache that also records statistics about its use.
+type StatsCache interface {
+	Cache
+
+	// Stats returns the current statistics for the cache.
+	Stats() CacheStats
+}
+
+// cacheStats records CacheStats using atomic counters.
+type cacheStats struct {
+	hits, misses, evictions atomic.Uint64
+}
+
+// Stats implements StatsCache.Stats.
+func (s *cacheStats) Stats() CacheStats {
+	return CacheStats{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+	}
+}
+
+// NewTTLCache creates a Cache in which entries expire the given
+// duration after they were added. Expired entries are removed when they
+// are next requested. So that entries that are never requested again do
+// not accumulate, Add also removes every expired entry, at most once per
+// the given duration.
+func NewTTLCache(ttl time.Duration) StatsCache {
+	return newTTLCache(ttl, time.Now)
+}
+
+func newTTLCache(ttl time.Duration, now func() time.Time) *ttlCache {
+	c := &ttlCache{
+		ttl: ttl,
+		now: now,
+	}
+	c.entries.Store(new(sync.Map))
+	return c
+}
+
+type ttlEntry struct {
+	value     []string
+	expiresAt time.Time
+}
+
+// ttlCache is the Cache implementation returned from NewTTLCache.
+type ttlCache struct {
+	cacheStats
+
+	ttl time.Duration
+	now func() time.Time
+
+	// entries holds the current map of entries, it is replaced
+	// wholesale by Flush.
+	entries atomic.Pointer[sync.Map]
+
+	// mu guards nextSweep, the time after which Add next removes
+	// expired entries.
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+// Add implements Cache.Add.
+func (c *ttlCache) Add(key string, value []string) {
+	now := c.now()
+	entries := c.entries.Load()
+	entries.Store(key, &ttlEntry{
+		value:     value,
+		expiresAt: now.Add(c.ttl),
+	})
+	c.sweep(entries, now)
+}
+
+// sweep removes every expired entry from entries, unless it has already
+// done so within the last ttl.
+func (c *ttlCache) sweep(entries *sync.Map, now time.Time) {
+	c.mu.Lock()
+	if now.Before(c.nextSweep) {
+		c.mu.Unlock()
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+	c.mu.Unlock()
+	entries.Range(func(key, v any) bool {
+		if !now.Before(v.(*ttlEntry).expiresAt) && entries.CompareAndDelete(key, v) {
+			c.evictions.Add(1)
+		}
+		return true
+	})
+}
+
+// Get implements Cache.Get.
+func (c *ttlCache) Get(key string) ([]string, bool) {
+	entries := c.entries.Load()
+	v, ok := entries.Load(key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	e := v.(*ttlEntry)
+	if !c.now().Before(e.expiresAt) {
+		if entries.CompareAndDelete(key, v) {
+			c.evictions.Add(1)
+		}
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return e.value, true
+}
+
+// Invalidate implements Cache.Invalidate.
+func (c *ttlCache) Invalidate(key string) {
+	c.entries.Load().Delete(key)
+}
+
+// Flush implements Cache.Flush.
+func (c *ttlCache) Flush() {
+	c.entries.Store(new(sync.Map))
+}
+
+// NewLRUCache creates a Cache that holds at most maxEntries entries.
+// When the cache is full adding a new entry evicts the least recently
+// used entry. NewLRUCache panics if maxEntries is not positive.
+func NewLRUCache(maxEntries int) StatsCache {
+	if maxEntries <= 0 {
+		panic("ssoauthacl: LRU cache size must be positive")
+	}
+	return &lruCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+type lruEntry struct {
+	key   string
+	value []string
+}
+
+// lruCache is the Cache implementation returned from NewLRUCache.
+type lruCache struct {
+	cacheStats
+
+	maxEntries int
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+// Add implements Cache.Add.
+func (c *lruCache) Add(key string, value []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.ll.MoveToFront(e)
+		return
+	}
+	if c.ll.Len() >= c.maxEntries {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry).key)
+		c.evictions.Add(1)
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry{key: key, value: value})
+}
+
+// Get implements Cache.Get.
+func (c *lruCache) Get(key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// Invalidate implements Cache.Invalidate.
+func (c *lruCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.ll.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// Flush implements Cache.Flush.
+func (c *lruCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element)
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/ssoauthacl"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestTTLCache(t *testing.T) {
+	c := qt.New(t)
+
+	clock := ssoauthtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := ssoauthacl.NewTTLCacheWithClock(time.Minute, clock.Now)
+
+	_, ok := cache.Get("a")
+	c.Check(ok, qt.IsFalse)
+
+	cache.Add("a", []string{"team1"})
+	clock.Advance(30 * time.Second)
+	cache.Add("b", []string{"team2"})
+
+	v, ok := cache.Get("a")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team1"})
+
+	clock.Advance(30 * time.Second)
+	_, ok = cache.Get("a")
+	c.Check(ok, qt.IsFalse)
+	v, ok = cache.Get("b")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team2"})
+
+	// Adding an entry again resets its expiry time.
+	cache.Add("b", []string{"team3"})
+	clock.Advance(59 * time.Second)
+	v, ok = cache.Get("b")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team3"})
+
+	clock.Advance(time.Second)
+	_, ok = cache.Get("b")
+	c.Check(ok, qt.IsFalse)
+}
+
+func TestLRUCache(t *testing.T) {
+	c := qt.New(t)
+
+	cache := ssoauthacl.NewLRUCache(2)
+	cache.Add("a", []string{"team1"})
+	cache.Add("b", []string{"team2"})
+
+	// Using "a" makes "b" the least recently used entry.
+	v, ok := cache.Get("a")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team1"})
+
+	cache.Add("c", []string{"team3"})
+	_, ok = cache.Get("b")
+	c.Check(ok, qt.IsFalse)
+	v, ok = cache.Get("a")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team1"})
+	v, ok = cache.Get("c")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team3"})
+
+	// Replacing an entry does not evict anything.
+	cache.Add("a", []string{"team4"})
+	v, ok = cache.Get("a")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"team4"})
+	_, ok = cache.Get("c")
+	c.Check(ok, qt.IsTrue)
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := qt.New(t)
+
+	for _, cache := range []ssoauthacl.Cache{
+		ssoauthacl.NewTTLCache(time.Hour),
+		ssoauthacl.NewLRUCache(10),
+	} {
+		cache.Add("a", []string{"team1"})
+		cache.Add("b", []string{"team2"})
+		cache.Invalidate("a")
+		cache.Invalidate("c")
+		_, ok := cache.Get("a")
+		c.Check(ok, qt.IsFalse)
+		v, ok := cache.Get("b")
+		c.Check(ok, qt.IsTrue)
+		c.Check(v, qt.DeepEquals, []string{"team2"})
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	c := qt.New(t)
+
+	for _, cache := range []ssoauthacl.Cache{
+		ssoauthacl.NewTTLCache(time.Hour),
+		ssoauthacl.NewLRUCache(2),
+	} {
+		cache.Add("a", []string{"team1"})
+		cache.Add("b", []string{"team2"})
+		cache.Flush()
+		_, ok := cache.Get("a")
+		c.Check(ok, qt.IsFalse)
+		_, ok = cache.Get("b")
+		c.Check(ok, qt.IsFalse)
+
+		cache.Add("c", []string{"team3"})
+		v, ok := cache.Get("c")
+		c.Check(ok, qt.IsTrue)
+		c.Check(v, qt.DeepEquals, []string{"team3"})
+	}
+}
+
+func TestLRUCacheConcurrentAccess(t *testing.T) {
+	c := qt.New(t)
+
+	cache := ssoauthacl.NewLRUCache(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i%15)
+			cache.Add(key, []string{key})
+			if v, ok := cache.Get(key); ok {
+				c.Check(v, qt.DeepEquals, []string{key})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var n int
+	for i := 0; i < 15; i++ {
+		if _, ok := cache.Get(fmt.Sprintf("key%d", i)); ok {
+			n++
+		}
+	}
+	c.Check(n, qt.Equals, 10)
+}
+
+func TestLRUCacheInvalidSize(t *testing.T) {
+	c := qt.New(t)
+
+	c.Check(func() { ssoauthacl.NewLRUCache(0) }, qt.PanicMatches, `ssoauthacl: LRU cache size must be positive`)
+}
+
+func TestTTLCacheStats(t *testing.T) {
+	c := qt.New(t)
+
+	clock := ssoauthtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := ssoauthacl.NewTTLCacheWithClock(time.Minute, clock.Now)
+	cache.Add("a", []string{"team1"})
+	cache.Get("a")
+	cache.Get("b")
+	clock.Advance(time.Minute)
+	cache.Get("a")
+	cache.Get("a")
+	c.Check(cache.Stats(), qt.Equals, ssoauthacl.CacheStats{
+		Hits:      1,
+		Misses:    3,
+		Evictions: 1,
+	})
+}
+
+func TestTTLCacheSweep(t *testing.T) {
+	c := qt.New(t)
+
+	clock := ssoauthtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := ssoauthacl.NewTTLCacheWithClock(time.Minute, clock.Now)
+	cache.Add("a", []string{"team1"})
+	cache.Add("b", []string{"team2"})
+	clock.Advance(30 * time.Second)
+	cache.Add("c", []string{"team3"})
+	c.Check(cache.Stats().Evictions, qt.Equals, uint64(0))
+
+	// Adding an entry once a and b have expired removes them without
+	// their being requested, but leaves c.
+	clock.Advance(30 * time.Second)
+	cache.Add("d", []string{"team4"})
+	c.Check(cache.Stats().Evictions, qt.Equals, uint64(2))
+	_, ok := cache.Get("c")
+	c.Check(ok, qt.IsTrue)
+
+	// Expired entries are removed at most once per TTL.
+	clock.Advance(30 * time.Second)
+	cache.Add("e", []string{"team5"})
+	c.Check(cache.Stats().Evictions, qt.Equals, uint64(2))
+	clock.Advance(30 * time.Second)
+	cache.Add("f", []string{"team6"})
+	c.Check(cache.Stats().Evictions, qt.Equals, uint64(4))
+}
+
+func TestLRUCacheStats(t *testing.T) {
+	c := qt.New(t)
+
+	cache := ssoauthacl.NewLRUCache(10)
+	for i := 0; i < 10; i++ {
+		cache.Add(fmt.Sprintf("key%d", i), nil)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Get(fmt.Sprintf("key%d", i))
+		}(i)
+	}
+	wg.Wait()
+	cache.Add("key10", nil)
+	cache.Add("key11", nil)
+	c.Check(cache.Stats(), qt.Equals, ssoauthacl.CacheStats{
+		Hits:      10,
+		Misses:    10,
+		Evictions: 2,
+	})
+}
+
+func TestLaunchpadTeamMatcherCacheStats(t *testing.T) {
+	c := qt.New(t)
+
+	m := ssoauthacl.LaunchpadTeamMatcher{}
+	_, ok := m.CacheStats()
+	c.Check(ok, qt.IsFalse)
+
+	cache := ssoauthacl.NewLRUCache(10)
+	cache.Get("a")
+	m.Cache = cache
+	stats, ok := m.CacheStats()
+	c.Check(ok, qt.IsTrue)
+	c.Check(stats, qt.Equals, ssoauthacl.CacheStats{Misses: 1})
+}
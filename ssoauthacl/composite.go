@@ -0,0 +1,115 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/canonical/ssoauth"
+)
+
+// An AndMatcher is an IdentityMatcher that only matches identities that
+// are matched by all of its IdentityMatchers. An empty AndMatcher
+// matches no identities.
+type AndMatcher []IdentityMatcher
+
+// MatchIdentity implements IdentityMatcher.
+//
+// The IdentityMatchers are consulted in order, each being given only
+// the identities matched by all the previous ones. Once no identities
+// remain no further IdentityMatchers are consulted. Any errors are
+// returned in an ACLMatchError keyed by the index of the IdentityMatcher
+// that returned them.
+func (m AndMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	if len(m) == 0 {
+		return []string{}, nil
+	}
+	errs := make(map[string]error)
+	for i, matcher := range m {
+		mids, err := matcher.MatchIdentity(ctx, acc, ids)
+		if err != nil {
+			errs[strconv.Itoa(i)] = err
+		}
+		ids = intersect(ids, mids)
+		if len(ids) == 0 {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return ids, &ACLMatchError{Errors: errs}
+	}
+	return ids, nil
+}
+
+// An OrMatcher is an IdentityMatcher that matches identities that are
+// matched by any of its IdentityMatchers.
+type OrMatcher []IdentityMatcher
+
+// MatchIdentity implements IdentityMatcher.
+//
+// Every IdentityMatcher is consulted and the union of their results is
+// returned, with any duplicates removed. Any errors are returned in an
+// ACLMatchError keyed by the index of the IdentityMatcher that returned
+// them.
+func (m OrMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	seen := make(map[string]bool)
+	matchids := make([]string, 0, len(ids))
+	errs := make(map[string]error)
+	for i, matcher := range m {
+		mids, err := matcher.MatchIdentity(ctx, acc, ids)
+		if err != nil {
+			errs[strconv.Itoa(i)] = err
+		}
+		for _, id := range mids {
+			if !seen[id] {
+				seen[id] = true
+				matchids = append(matchids, id)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return matchids, &ACLMatchError{Errors: errs}
+	}
+	return matchids, nil
+}
+
+// A NegationMatcher is an IdentityMatcher that matches all the given
+// identities when Inner matches none of them, and none of them when
+// Inner matches any. It can be combined with an AndMatcher to block
+// accounts that would otherwise be allowed.
+type NegationMatcher struct {
+	Inner IdentityMatcher
+}
+
+// MatchIdentity implements IdentityMatcher.
+//
+// If Inner returns an error no identities are matched and the error is
+// returned unchanged.
+func (m NegationMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	mids, err := m.Inner.MatchIdentity(ctx, acc, ids)
+	if err != nil {
+		return []string{}, err
+	}
+	if len(mids) > 0 {
+		return []string{}, nil
+	}
+	return append([]string{}, ids...), nil
+}
+
+// intersect returns the elements of ids that are also in mids.
+func intersect(ids, mids []string) []string {
+	set := make(map[string]bool, len(mids))
+	for _, id := range mids {
+		set[id] = true
+	}
+	result := make([]string, 0, len(mids))
+	for _, id := range ids {
+		if set[id] {
+			result = append(result, id)
+			delete(set, id)
+		}
+	}
+	return result
+}
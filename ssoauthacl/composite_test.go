@@ -0,0 +1,193 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+)
+
+// staticMatcher is an IdentityMatcher that matches a fixed set of
+// identities and then returns err.
+type staticMatcher struct {
+	ids []string
+	err error
+}
+
+func (m staticMatcher) MatchIdentity(_ context.Context, _ *ssoauth.Account, ids []string) ([]string, error) {
+	match := []string{}
+	for _, id := range ids {
+		for _, mid := range m.ids {
+			if id == mid {
+				match = append(match, id)
+			}
+		}
+	}
+	return match, m.err
+}
+
+var andMatcherTests = []struct {
+	name        string
+	matcher     ssoauthacl.AndMatcher
+	expectMatch []string
+	expectError string
+}{{
+	name: "all-match",
+	matcher: ssoauthacl.AndMatcher{
+		staticMatcher{ids: []string{"a", "b", "c"}},
+		staticMatcher{ids: []string{"b", "c", "d"}},
+	},
+	expectMatch: []string{"b", "c"},
+}, {
+	name: "one-empty",
+	matcher: ssoauthacl.AndMatcher{
+		staticMatcher{ids: []string{"a", "b", "c"}},
+		staticMatcher{},
+		ssoauthacl.ErrorMatcher{Err: errgo.New("not reached")},
+	},
+	expectMatch: []string{},
+}, {
+	name: "one-error",
+	matcher: ssoauthacl.AndMatcher{
+		staticMatcher{ids: []string{"a", "b"}, err: errgo.New("test error")},
+		staticMatcher{ids: []string{"b", "c"}},
+	},
+	expectMatch: []string{"b"},
+	expectError: `some matchers failed \[0: test error\]`,
+}, {
+	name:        "empty",
+	matcher:     ssoauthacl.AndMatcher{},
+	expectMatch: []string{},
+}}
+
+func TestAndMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	for _, test := range andMatcherTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ids, err := test.matcher.MatchIdentity(ctx, &ssoauth.Account{}, []string{"a", "b", "c", "d"})
+			if test.expectError != "" {
+				c.Check(err, qt.ErrorMatches, test.expectError)
+				c.Check(err, qt.Satisfies, func(err error) bool {
+					_, ok := err.(*ssoauthacl.ACLMatchError)
+					return ok
+				})
+			} else {
+				c.Check(err, qt.IsNil)
+			}
+			c.Check(ids, qt.DeepEquals, test.expectMatch)
+		})
+	}
+}
+
+var orMatcherTests = []struct {
+	name        string
+	matcher     ssoauthacl.OrMatcher
+	expectMatch []string
+	expectError string
+}{{
+	name: "first-matches",
+	matcher: ssoauthacl.OrMatcher{
+		staticMatcher{ids: []string{"a"}},
+		staticMatcher{},
+	},
+	expectMatch: []string{"a"},
+}, {
+	name: "second-matches",
+	matcher: ssoauthacl.OrMatcher{
+		staticMatcher{},
+		staticMatcher{ids: []string{"b"}},
+	},
+	expectMatch: []string{"b"},
+}, {
+	name: "both-match",
+	matcher: ssoauthacl.OrMatcher{
+		staticMatcher{ids: []string{"a", "b"}},
+		staticMatcher{ids: []string{"b", "c"}},
+	},
+	expectMatch: []string{"a", "b", "c"},
+}, {
+	name: "none-match",
+	matcher: ssoauthacl.OrMatcher{
+		staticMatcher{},
+		staticMatcher{ids: []string{"e"}},
+	},
+	expectMatch: []string{},
+}, {
+	name: "one-error",
+	matcher: ssoauthacl.OrMatcher{
+		ssoauthacl.ErrorMatcher{Err: errgo.New("test error")},
+		staticMatcher{ids: []string{"c"}},
+	},
+	expectMatch: []string{"c"},
+	expectError: `some matchers failed \[0: test error\]`,
+}}
+
+func TestOrMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	for _, test := range orMatcherTests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			ids, err := test.matcher.MatchIdentity(ctx, &ssoauth.Account{}, []string{"a", "b", "c", "d"})
+			if test.expectError != "" {
+				c.Check(err, qt.ErrorMatches, test.expectError)
+			} else {
+				c.Check(err, qt.IsNil)
+			}
+			c.Check(ids, qt.DeepEquals, test.expectMatch)
+		})
+	}
+}
+
+func TestNegationMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	acc := &ssoauth.Account{}
+
+	m := ssoauthacl.NegationMatcher{Inner: staticMatcher{ids: []string{"a"}}}
+	ids, err := m.MatchIdentity(ctx, acc, []string{"a", "b"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{})
+
+	ids, err = m.MatchIdentity(ctx, acc, []string{"b", "c"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"b", "c"})
+
+	testErr := errgo.New("test error")
+	m = ssoauthacl.NegationMatcher{Inner: ssoauthacl.ErrorMatcher{Err: testErr}}
+	ids, err = m.MatchIdentity(ctx, acc, []string{"a", "b"})
+	c.Check(err, qt.Equals, testErr)
+	c.Check(ids, qt.DeepEquals, []string{})
+}
+
+func TestAllowUnlessBlocked(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	allow := ssoauthacl.NewUsernameRegexMatcher(`^contractor-`)
+	block := ssoauthacl.NewUsernameRegexMatcher(`^contractor-blocked$`)
+	m := ssoauthacl.AndMatcher{allow, ssoauthacl.NegationMatcher{Inner: block}}
+
+	ids, err := m.MatchIdentity(ctx, &ssoauth.Account{Username: "contractor-jsmith"}, []string{"access"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"access"})
+
+	ids, err = m.MatchIdentity(ctx, &ssoauth.Account{Username: "contractor-blocked"}, []string{"access"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.HasLen, 0)
+
+	ids, err = m.MatchIdentity(ctx, &ssoauth.Account{Username: "jsmith"}, []string{"access"})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.HasLen, 0)
+}
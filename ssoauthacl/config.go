@@ -0,0 +1,66 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	matcherTypesMu sync.RWMutex
+	matcherTypes   = map[string]func() IdentityMatcher{
+		"account":   func() IdentityMatcher { return AccountMatcher{} },
+		"launchpad": func() IdentityMatcher { return LaunchpadTeamMatcher{} },
+	}
+)
+
+// RegisterMatcherType registers a factory for IdentityMatchers of the
+// given type name for use by ParseACLMatcher. The "account" and
+// "launchpad" types are registered by default, creating an
+// AccountMatcher and a LaunchpadTeamMatcher with default configuration
+// respectively. RegisterMatcherType panics if factory is nil or the name
+// is already registered.
+func RegisterMatcherType(name string, factory func() IdentityMatcher) {
+	if factory == nil {
+		panic("ssoauthacl: RegisterMatcherType factory is nil")
+	}
+	matcherTypesMu.Lock()
+	defer matcherTypesMu.Unlock()
+	if _, ok := matcherTypes[name]; ok {
+		panic("ssoauthacl: RegisterMatcherType called twice for " + name)
+	}
+	matcherTypes[name] = factory
+}
+
+// ParseACLMatcher creates an ACLMatcher from the given JSON
+// configuration. The configuration is an object mapping each host to the
+// name of a matcher type registered with RegisterMatcherType, for
+// example:
+//
+//	{
+//		"login.ubuntu.com": "account",
+//		"launchpad.net": "launchpad"
+//	}
+//
+// A new IdentityMatcher is created for every host.
+func ParseACLMatcher(data []byte) (ACLMatcher, error) {
+	var config map[string]string
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errgo.Notef(err, "cannot parse ACL matcher configuration")
+	}
+	matcherTypesMu.RLock()
+	defer matcherTypesMu.RUnlock()
+	m := make(ACLMatcher, len(config))
+	for host, name := range config {
+		factory := matcherTypes[name]
+		if factory == nil {
+			return nil, errgo.Newf("cannot parse ACL matcher configuration: unknown matcher type %q for host %q", name, host)
+		}
+		m[host] = factory()
+	}
+	return m, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+)
+
+func init() {
+	ssoauthacl.RegisterMatcherType("test-allow-all", func() ssoauthacl.IdentityMatcher {
+		return ssoauthacl.AllowAllMatcher{}
+	})
+}
+
+func TestParseACLMatcher(t *testing.T) {
+	c := qt.New(t)
+
+	m, err := ssoauthacl.ParseACLMatcher([]byte(`{
+		"login.example.com": "account",
+		"launchpad.net": "launchpad",
+		"example.com": "test-allow-all"
+	}`))
+	c.Assert(err, qt.IsNil)
+	c.Check(m, qt.DeepEquals, ssoauthacl.ACLMatcher{
+		"login.example.com": ssoauthacl.AccountMatcher{},
+		"launchpad.net":     ssoauthacl.LaunchpadTeamMatcher{},
+		"example.com":       ssoauthacl.AllowAllMatcher{},
+	})
+
+	acc := &ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+	}
+	ids, err := m.MatchIdentity(context.Background(), acc, []string{
+		"https://login.example.com/+id/AAAAAAA",
+		"https://login.example.com/+id/BBBBBBB",
+		"https://example.com/anything",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.ContentEquals, []string{
+		"https://login.example.com/+id/AAAAAAA",
+		"https://example.com/anything",
+	})
+}
+
+var parseACLMatcherErrorTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	about:       "unknown type",
+	data:        `{"login.example.com": "no-such-type"}`,
+	expectError: `cannot parse ACL matcher configuration: unknown matcher type "no-such-type" for host "login.example.com"`,
+}, {
+	about:       "invalid JSON",
+	data:        `{"login.example.com": `,
+	expectError: `cannot parse ACL matcher configuration: unexpected end of JSON input`,
+}, {
+	about:       "wrong value type",
+	data:        `{"login.example.com": 1}`,
+	expectError: `cannot parse ACL matcher configuration: json: cannot unmarshal number into .* of type string`,
+}}
+
+func TestParseACLMatcherError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseACLMatcherErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			m, err := ssoauthacl.ParseACLMatcher([]byte(test.data))
+			c.Check(err, qt.ErrorMatches, test.expectError)
+			c.Check(m, qt.IsNil)
+		})
+	}
+}
+
+func TestRegisterMatcherTypeDuplicate(t *testing.T) {
+	c := qt.New(t)
+	c.Check(func() {
+		ssoauthacl.RegisterMatcherType("account", func() ssoauthacl.IdentityMatcher {
+			return ssoauthacl.DenyAllMatcher{}
+		})
+	}, qt.PanicMatches, `ssoauthacl: RegisterMatcherType called twice for account`)
+}
@@ -0,0 +1,29 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import "time"
+
+// NewTTLCacheWithClock creates a TTL cache that uses the given function
+// to determine the current time.
+func NewTTLCacheWithClock(ttl time.Duration, now func() time.Time) StatsCache {
+	return newTTLCache(ttl, now)
+}
+
+// SetCircuitBreakerClock sets the function the given CircuitBreaker uses
+// to determine the current time.
+func SetCircuitBreakerClock(b *CircuitBreaker, now func() time.Time) {
+	b.now = now
+}
+
+// SetSharedRequestTimeout sets the timeout used for requests made using a
+// SingleflightGroup when RequestTimeout is not set, and returns a function
+// that restores the original.
+func SetSharedRequestTimeout(d time.Duration) (restore func()) {
+	old := sharedRequestTimeout
+	sharedRequestTimeout = d
+	return func() {
+		sharedRequestTimeout = old
+	}
+}
@@ -5,7 +5,15 @@ package ssoauthacl
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/singleflight"
 	"gopkg.in/errgo.v1"
 	"launchpad.net/lpad"
@@ -14,7 +22,8 @@ import (
 )
 
 // A LaunchpadTeamMatcher is an IdentityMatcher that matches against an
-// account's launchpad teams.
+// account's launchpad teams. Team URLs are compared ignoring case and
+// any trailing slash.
 type LaunchpadTeamMatcher struct {
 	// APIBase holds the base address of the launchpad API.
 	// If this is not set then lpad.Production will be used.
@@ -22,12 +31,15 @@ type LaunchpadTeamMatcher struct {
 
 	// Auth holds an authentication to use when querying the
 	// launchpad API. If Auth is nil an anonymous authentication will
-	// be used.
+	// be used. NewLaunchpadOAuthAuth may be used to create an
+	// authentication from OAuth credentials.
 	Auth lpad.Auth
 
 	// LaunchpadOpenID holds the function used to determine the
 	// launchpad openid string from an account. If this is nil then
-	// DefaultLaunchpadOpenID is used.
+	// DefaultLaunchpadOpenID is used. The LaunchpadOpenID method of
+	// an OpenIDMapper may be used to map accounts using a set of
+	// rules.
 	LaunchpadOpenID func(*ssoauth.Account) string
 
 	// Cache is used to store lists of launchpad teams indexed by
@@ -37,10 +49,55 @@ type LaunchpadTeamMatcher struct {
 
 	// SingleflightGroup is used to prevent multiple concurrent
 	// requests being made for the same account. If this is nil then
-	// no such protection will be used.
+	// no such protection will be used. A shared request is not
+	// canceled when the caller that started it gives up, so if
+	// RequestTimeout is zero it is bounded by a default timeout of
+	// 30 seconds instead.
 	SingleflightGroup *singleflight.Group
+
+	// RetryPolicy is used to determine whether, and when, failed
+	// launchpad API requests are retried. If this is nil then failed
+	// requests are not retried.
+	RetryPolicy RetryPolicy
+
+	// RequestTimeout holds the maximum time allowed for each attempt
+	// to retrieve an account's teams from the launchpad API. If this
+	// is zero then requests are only bounded by the context passed
+	// to MatchIdentity, or by a default timeout when SingleflightGroup
+	// is set.
+	RequestTimeout time.Duration
+
+	// CircuitBreaker is used to stop making launchpad API requests
+	// while the API is failing. While the breaker does not allow
+	// requests MatchIdentity returns an error with a cause of
+	// ErrCircuitOpen, unless the result is already cached. If this is
+	// nil then requests are always attempted.
+	CircuitBreaker Breaker
+
+	// PrefetchConcurrency holds the maximum number of concurrent
+	// launchpad API lookups made by Prefetch. If this is zero then 5
+	// is used.
+	PrefetchConcurrency int
+
+	// Logger holds the logger to which Prefetch logs failed lookups.
+	// If this is nil slog.Default is used.
+	Logger *slog.Logger
 }
 
+// defaultPrefetchConcurrency is the number of concurrent lookups made by
+// Prefetch when PrefetchConcurrency is not set.
+const defaultPrefetchConcurrency = 5
+
+// sharedRequestTimeout bounds the requests made using a SingleflightGroup
+// when RequestTimeout is not set. It is a variable so that tests can
+// change it.
+var sharedRequestTimeout = 30 * time.Second
+
+// ErrCircuitOpen is the cause of the error returned from a
+// LaunchpadTeamMatcher when its CircuitBreaker does not allow requests
+// to the launchpad API.
+var ErrCircuitOpen = errgo.New("launchpad API circuit breaker open")
+
 // MatchIdentity implements IdentityMatcher.
 func (m LaunchpadTeamMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
 	oidf := DefaultLaunchpadOpenID
@@ -58,6 +115,17 @@ func (m LaunchpadTeamMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Ac
 	var err error
 	if m.SingleflightGroup != nil {
 		ch := m.SingleflightGroup.DoChan(oid, func() (interface{}, error) {
+			// The result is shared with other callers, so
+			// do not let this caller giving up abandon the
+			// request for everyone. Without a RequestTimeout
+			// nothing would then bound the request, so use
+			// a default.
+			ctx := context.WithoutCancel(ctx)
+			if m.RequestTimeout <= 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, sharedRequestTimeout)
+				defer cancel()
+			}
 			return m.getLaunchpadTeams(ctx, oid)
 		})
 		select {
@@ -73,13 +141,14 @@ func (m LaunchpadTeamMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Ac
 
 	rids := make([]string, 0, len(ids))
 	for _, id := range ids {
+		nid := normalizeTeam(id)
 		for _, t := range teams {
-			if id == t {
+			if nid == t {
 				rids = append(rids, id)
 			}
 		}
 	}
-	return rids, errgo.Mask(err, errgo.Is(context.Canceled), errgo.Is(context.DeadlineExceeded))
+	return rids, errgo.Mask(err, errgo.Is(context.Canceled), errgo.Is(context.DeadlineExceeded), errgo.Is(ErrCircuitOpen))
 }
 
 func (m LaunchpadTeamMatcher) getLaunchpadTeams(ctx context.Context, openID string) ([]string, error) {
@@ -89,57 +158,227 @@ func (m LaunchpadTeamMatcher) getLaunchpadTeams(ctx context.Context, openID stri
 		}
 	}
 
-	auth := m.Auth
-	if auth == nil {
-		auth = &lpad.OAuth{Consumer: "github.com/canonical/ssoauth/ssoauthacl", Anonymous: true}
+	for attempt := 1; ; attempt++ {
+		if m.CircuitBreaker != nil && !m.CircuitBreaker.Allow() {
+			return nil, errgo.WithCausef(nil, ErrCircuitOpen, "")
+		}
+		teams, err := m.fetchLaunchpadTeamsWithTimeout(ctx, openID)
+		if err == errAccountNotFound {
+			// If the user is not found they can't be in any
+			// teams. Cache that so that the API is not
+			// consulted for the account again.
+			teams, err = notFoundTeams, nil
+		}
+		if err == nil {
+			if m.CircuitBreaker != nil {
+				m.CircuitBreaker.RecordSuccess()
+			}
+			if m.Cache != nil {
+				m.Cache.Add(openID, teams)
+			}
+			return teams, nil
+		}
+		if m.CircuitBreaker != nil && ctx.Err() == nil {
+			// Only failures of the API count, not the
+			// caller giving up.
+			m.CircuitBreaker.RecordFailure()
+		}
+		if m.RetryPolicy == nil || !m.RetryPolicy.ShouldRetry(attempt, err) {
+			return nil, errgo.Mask(err)
+		}
+		t := time.NewTimer(m.RetryPolicy.Delay(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
 	}
-	apiBase := m.APIBase
-	if apiBase == "" {
-		apiBase = lpad.Production
+}
+
+// notFoundTeams is the team list stored in the cache for accounts that
+// are not known to launchpad. Such accounts are in no teams, so the
+// cached value needs no special handling when it is retrieved.
+var notFoundTeams = []string{}
+
+// errAccountNotFound is returned from fetchLaunchpadTeams when there is
+// no launchpad account with the requested OpenID.
+var errAccountNotFound = errgo.New("launchpad account not found")
+
+// fetchLaunchpadTeamsWithTimeout calls fetchLaunchpadTeams bounded by
+// the configured RequestTimeout.
+func (m LaunchpadTeamMatcher) fetchLaunchpadTeamsWithTimeout(ctx context.Context, openID string) ([]string, error) {
+	if m.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.RequestTimeout)
+		defer cancel()
 	}
-	root, err := lpad.Login(apiBase, auth)
+	return m.fetchLaunchpadTeams(ctx, openID)
+}
+
+// fetchLaunchpadTeams retrieves the teams for the given OpenID from the
+// launchpad API. The cause of any other returned error is the error from
+// the API client.
+func (m LaunchpadTeamMatcher) fetchLaunchpadTeams(ctx context.Context, openID string) ([]string, error) {
+	root, err := m.login(ctx)
 	if err != nil {
-		return nil, errgo.Mask(err)
+		return nil, errgo.Mask(err, errgo.Any)
 	}
 
 	v, err := root.Location("/people").Get(lpad.Params{"ws.op": "getByOpenIDIdentifier", "identifier": openID})
 	if errgo.Cause(err) == lpad.ErrNotFound {
-		// If the user is not found they can't be in any teams.
-		return nil, nil
+		return nil, errAccountNotFound
 	}
 	if err != nil {
-		return nil, errgo.Mask(err)
+		return nil, errgo.Mask(err, errgo.Any)
 	}
 	v, err = v.Link("super_teams_collection_link").Get(nil)
 	if err != nil {
-		return nil, errgo.Mask(err)
+		return nil, errgo.Mask(err, errgo.Any)
 	}
-	teams := make([]string, v.TotalSize())
-	var i int
+	// The total_size of a collection is only a hint, it may be
+	// missing for large collections. For follows the
+	// next_collection_link so collect the entries from every page.
+	teams := make([]string, 0, v.TotalSize())
 	err = v.For(func(v *lpad.Value) error {
 		if name := v.StringField("web_link"); name != "" {
-			teams[i] = name
-			i++
+			teams = append(teams, normalizeTeam(name))
 		}
 		return nil
 	})
-	if m.Cache != nil && err == nil {
-		m.Cache.Add(openID, teams)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
 	}
-	return teams[:i], errgo.Mask(err)
+	return teams, nil
 }
 
+// normalizeTeam returns the canonical form of the given launchpad team
+// URL, so that URLs that differ only by case or a trailing slash, as
+// returned by different versions of the launchpad API, compare equal.
+func normalizeTeam(team string) string {
+	return strings.TrimSuffix(strings.ToLower(team), "/")
+}
+
+// login connects to the launchpad API such that all requests are made
+// using the given context.
+func (m LaunchpadTeamMatcher) login(ctx context.Context) (*lpad.Root, error) {
+	auth := m.Auth
+	if auth == nil {
+		auth = &lpad.OAuth{Consumer: "github.com/canonical/ssoauth/ssoauthacl", Anonymous: true}
+	}
+	// The lpad package has no support for contexts, but every
+	// request it makes is signed so attach the context then.
+	auth = contextAuth{Auth: auth, ctx: ctx}
+	apiBase := m.APIBase
+	if apiBase == "" {
+		apiBase = lpad.Production
+	}
+	root, err := lpad.Login(apiBase, auth)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return root, nil
+}
+
+// HealthCheck checks that the launchpad API is reachable by fetching
+// its root resource. The request is bounded by the given context and by
+// RequestTimeout, if set. The matcher's Cache and CircuitBreaker are not
+// consulted.
+func (m LaunchpadTeamMatcher) HealthCheck(ctx context.Context) error {
+	if m.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.RequestTimeout)
+		defer cancel()
+	}
+	root, err := m.login(ctx)
+	if err == nil {
+		_, err = root.Get(nil)
+	}
+	if err != nil {
+		// Make the cause reflect a context error, so that callers
+		// can distinguish the probe timing out.
+		return errgo.WithCausef(err, ctx.Err(), "launchpad API unavailable")
+	}
+	return nil
+}
+
+// Prefetch populates the matcher's Cache with the teams for each of the
+// given launchpad OpenIDs, as returned by the LaunchpadOpenID function,
+// so that later matches for those accounts need not wait for the
+// launchpad API. At most PrefetchConcurrency lookups are made at once.
+// Failed lookups are logged to the matcher's Logger, an error is only
+// returned if every lookup fails. If the matcher has no Cache Prefetch
+// does nothing.
+func (m LaunchpadTeamMatcher) Prefetch(ctx context.Context, openIDs []string) error {
+	if m.Cache == nil || len(openIDs) == 0 {
+		return nil
+	}
+	limit := m.PrefetchConcurrency
+	if limit <= 0 {
+		limit = defaultPrefetchConcurrency
+	}
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	var g errgroup.Group
+	g.SetLimit(limit)
+	var mu sync.Mutex
+	var errs []error
+	for _, oid := range openIDs {
+		oid := oid
+		g.Go(func() error {
+			if _, err := m.getLaunchpadTeams(ctx, oid); err != nil {
+				logger.WarnContext(ctx, "cannot prefetch launchpad teams", "openid", oid, "error", err)
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, errgo.Notef(err, "%s", oid))
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if len(errs) == len(openIDs) {
+		return errgo.Notef(errors.Join(errs...), "cannot prefetch launchpad teams")
+	}
+	return nil
+}
+
+// InvalidateCache removes any cached teams for the given launchpad
+// OpenID, as returned by the LaunchpadOpenID function, so that the next
+// match for the account consults the launchpad API.
+func (m LaunchpadTeamMatcher) InvalidateCache(openID string) {
+	if m.Cache != nil {
+		m.Cache.Invalidate(openID)
+	}
+}
+
+// FlushCache removes all cached teams so that subsequent matches consult
+// the launchpad API.
+func (m LaunchpadTeamMatcher) FlushCache() {
+	if m.Cache != nil {
+		m.Cache.Flush()
+	}
+}
+
+// CacheStats returns the statistics of the matcher's Cache. The returned
+// boolean is false if the Cache does not implement StatsCache.
+func (m LaunchpadTeamMatcher) CacheStats() (CacheStats, bool) {
+	sc, ok := m.Cache.(StatsCache)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return sc.Stats(), true
+}
+
+// defaultOpenIDMapper holds the rules used by DefaultLaunchpadOpenID.
+var defaultOpenIDMapper = DefaultOpenIDMapper()
+
 // DefaultLaunchpadOpenID is the default mapping from an ssoauth.Account
-// to a launchpad OpenID.
+// to a launchpad OpenID. It uses the rules returned by
+// DefaultOpenIDMapper.
 func DefaultLaunchpadOpenID(acc *ssoauth.Account) string {
-	switch acc.Provider {
-	case "login.launchpad.net", "login.ubuntu.com":
-		return "https://login.launchpad.net/+id/" + acc.OpenID
-	case "login-lp.staging.ubuntu.com", "login.staging.ubuntu.com":
-		return "https://login-lp.staging.ubuntu.com/+id/" + acc.OpenID
-	default:
-		return ""
-	}
+	return defaultOpenIDMapper.LaunchpadOpenID(acc)
 }
 
 // A Cache implementation can be used by a LaunchpadTeamMatcher to store
@@ -151,4 +390,110 @@ type Cache interface {
 	// Get retrieves the item with the given key from the cache, if
 	// available.
 	Get(key string) ([]string, bool)
+
+	// Invalidate removes the item with the given key from the cache,
+	// if present.
+	Invalidate(key string)
+
+	// Flush removes all items from the cache.
+	Flush()
+}
+
+// NewLaunchpadOAuthAuth returns an lpad.Auth, suitable for use as the
+// Auth of a LaunchpadTeamMatcher, that signs launchpad API requests
+// using the given OAuth 1.0a credentials. Authenticated requests are
+// subject to higher rate limits than anonymous ones. The access token
+// and token secret must already have been authorized, see
+// https://help.launchpad.net/API/SigningRequests for how to obtain
+// them from the launchpad website.
+func NewLaunchpadOAuthAuth(consumerKey, accessToken, tokenSecret string) lpad.Auth {
+	return launchpadOAuth{
+		oauth: lpad.OAuth{
+			Consumer:    consumerKey,
+			Token:       accessToken,
+			TokenSecret: tokenSecret,
+		},
+	}
+}
+
+// launchpadOAuth is the lpad.Auth returned from NewLaunchpadOAuthAuth.
+// Unlike an *lpad.OAuth it is not modified by Login, so it may safely be
+// shared by concurrent requests.
+type launchpadOAuth struct {
+	oauth lpad.OAuth
+}
+
+// Login implements lpad.Auth.Login. The credentials are already known
+// so there is nothing to do.
+func (a launchpadOAuth) Login(baseURL string) error {
+	return nil
+}
+
+// Sign implements lpad.Auth.Sign.
+func (a launchpadOAuth) Sign(req *http.Request) error {
+	return a.oauth.Sign(req)
+}
+
+// contextAuth is an lpad.Auth that makes the requests it signs use a
+// context.
+type contextAuth struct {
+	lpad.Auth
+	ctx context.Context
+}
+
+// Sign implements lpad.Auth.Sign.
+func (a contextAuth) Sign(req *http.Request) error {
+	*req = *req.WithContext(a.ctx)
+	return a.Auth.Sign(req)
+}
+
+// A RetryPolicy determines how a LaunchpadTeamMatcher retries failed
+// launchpad API requests.
+type RetryPolicy interface {
+	// ShouldRetry reports whether another attempt should be made
+	// after the given attempt, counting from 1, failed with the
+	// given error.
+	ShouldRetry(attempt int, err error) bool
+
+	// Delay returns the amount of time to wait after the given
+	// attempt before trying again.
+	Delay(attempt int) time.Duration
+}
+
+// ExponentialBackoff returns a RetryPolicy that makes at most
+// maxAttempts attempts, doubling the delay between attempts starting
+// from base. Only transient errors, server errors and network timeouts,
+// are retried.
+func ExponentialBackoff(maxAttempts int, base time.Duration) RetryPolicy {
+	return exponentialBackoff{
+		maxAttempts: maxAttempts,
+		base:        base,
+	}
+}
+
+type exponentialBackoff struct {
+	maxAttempts int
+	base        time.Duration
+}
+
+// ShouldRetry implements RetryPolicy.ShouldRetry.
+func (b exponentialBackoff) ShouldRetry(attempt int, err error) bool {
+	return attempt < b.maxAttempts && isTransient(err)
+}
+
+// Delay implements RetryPolicy.Delay.
+func (b exponentialBackoff) Delay(attempt int) time.Duration {
+	return b.base << (attempt - 1)
+}
+
+// isTransient determines whether the given error from the launchpad API
+// client might not occur if the request is tried again.
+func isTransient(err error) bool {
+	switch err := errgo.Cause(err).(type) {
+	case *lpad.Error:
+		return err.StatusCode >= 500
+	case net.Error:
+		return err.Timeout()
+	}
+	return false
 }
@@ -4,8 +4,10 @@
 package ssoauthacl_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"sort"
@@ -21,14 +23,19 @@ import (
 
 	"github.com/canonical/ssoauth"
 	"github.com/canonical/ssoauth/ssoauthacl"
+	"github.com/canonical/ssoauth/ssoauthtest"
 )
 
 func TestLaunchpadTeamMatcher(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
-	mux := http.NewServeMux()
-	srv := httptest.NewServer(mux)
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	srv := lp.Start()
 	c.Cleanup(srv.Close)
 
 	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
@@ -40,21 +47,6 @@ func TestLaunchpadTeamMatcher(t *testing.T) {
 		OpenID:   "AAAAAAA",
 	}
 
-	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
-		req.ParseForm()
-		c.Check(req.Method, qt.Equals, "GET")
-		c.Check(req.Form.Get("ws.op"), qt.Equals, "getByOpenIDIdentifier")
-		c.Check(req.Form.Get("identifier"), qt.Equals, "https://login.launchpad.net/+id/AAAAAAA")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"name": "test", "super_teams_collection_link": "http://%s/test/super_teams"}`, req.Host)
-	})
-
-	mux.HandleFunc("/test/super_teams", func(w http.ResponseWriter, req *http.Request) {
-		c.Check(req.Method, qt.Equals, "GET")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"total_size":3,"start":0,"entries": [{"web_link": "https://launchpad.net/~test1"},{"web_link":"https://launchpad.net/~test2"}]}`)
-	})
-
 	ids, err := m.MatchIdentity(ctx, acc, []string{
 		"https://launchpad.net/~test1",
 		"https://launchpad.net/~test2",
@@ -67,14 +59,63 @@ func TestLaunchpadTeamMatcher(t *testing.T) {
 		"https://launchpad.net/~test1",
 		"https://launchpad.net/~test2",
 	})
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 1)
+}
+
+func TestLaunchpadTeamMatcherOAuth(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+	})
+	var mu sync.Mutex
+	var authHeaders []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+		mu.Unlock()
+		lp.ServeHTTP(w, req)
+	}))
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+		Auth:    ssoauthacl.NewLaunchpadOAuthAuth("test-consumer", "test-token", "test-secret"),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	ids, err := m.MatchIdentity(ctx, acc, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test1"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(authHeaders, qt.Not(qt.HasLen), 0)
+	for _, h := range authHeaders {
+		c.Check(h, qt.Matches, `OAuth .*`)
+		c.Check(h, qt.Contains, `oauth_consumer_key="test-consumer"`)
+		c.Check(h, qt.Contains, `oauth_token="test-token"`)
+		c.Check(h, qt.Contains, `oauth_signature_method="PLAINTEXT"`)
+		c.Check(h, qt.Contains, `oauth_signature="%26test-secret"`)
+	}
 }
 
 func TestLaunchpadTeamMatcherUnsupportedAccount(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
-	mux := http.NewServeMux()
-	srv := httptest.NewServer(mux)
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	srv := lp.Start()
 	c.Cleanup(srv.Close)
 
 	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
@@ -94,15 +135,35 @@ func TestLaunchpadTeamMatcherUnsupportedAccount(t *testing.T) {
 
 	c.Check(err, qt.IsNil)
 	c.Check(ids, qt.HasLen, 0)
+	c.Check(lp.CallCount("/people"), qt.Equals, 0)
+}
+
+// newBlockingLaunchpadServer starts a server for lp that signals on the
+// returned channel, and then briefly pauses, before serving each
+// request for a user.
+func newBlockingLaunchpadServer(c *qt.C, lp *ssoauthtest.MockLaunchpadServer) (*httptest.Server, <-chan struct{}) {
+	ch := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/people" {
+			ch <- struct{}{}
+			time.Sleep(10 * time.Millisecond)
+		}
+		lp.ServeHTTP(w, req)
+	}))
+	c.Cleanup(srv.Close)
+	return srv, ch
 }
 
 func TestLaunchpadTeamMatcherSingleFlight(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
-	mux := http.NewServeMux()
-	srv := httptest.NewServer(mux)
-	c.Cleanup(srv.Close)
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	srv, ch := newBlockingLaunchpadServer(c, lp)
 
 	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
 		APIBase:           lpad.APIBase(srv.URL),
@@ -114,28 +175,6 @@ func TestLaunchpadTeamMatcherSingleFlight(t *testing.T) {
 		OpenID:   "AAAAAAA",
 	}
 
-	ch := make(chan struct{})
-	var peopleRequests uint32
-	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
-		atomic.AddUint32(&peopleRequests, 1)
-		ch <- struct{}{}
-		time.Sleep(10 * time.Millisecond)
-		req.ParseForm()
-		c.Check(req.Method, qt.Equals, "GET")
-		c.Check(req.Form.Get("ws.op"), qt.Equals, "getByOpenIDIdentifier")
-		c.Check(req.Form.Get("identifier"), qt.Equals, "https://login.launchpad.net/+id/AAAAAAA")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"name": "test", "super_teams_collection_link": "http://%s/test/super_teams"}`, req.Host)
-	})
-
-	var teamRequests uint32
-	mux.HandleFunc("/test/super_teams", func(w http.ResponseWriter, req *http.Request) {
-		atomic.AddUint32(&teamRequests, 1)
-		c.Check(req.Method, qt.Equals, "GET")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"total_size":3,"start":0,"entries": [{"web_link": "https://launchpad.net/~test1"},{"web_link":"https://launchpad.net/~test2"}]}`)
-	})
-
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -170,17 +209,20 @@ func TestLaunchpadTeamMatcherSingleFlight(t *testing.T) {
 	}()
 
 	wg.Wait()
-	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(1))
-	c.Check(atomic.LoadUint32(&teamRequests), qt.Equals, uint32(1))
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 1)
 }
 
 func TestLaunchpadTeamMatcherSingleFlightCanceled(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
-	mux := http.NewServeMux()
-	srv := httptest.NewServer(mux)
-	c.Cleanup(srv.Close)
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	srv, ch := newBlockingLaunchpadServer(c, lp)
 
 	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
 		APIBase:           lpad.APIBase(srv.URL),
@@ -192,28 +234,6 @@ func TestLaunchpadTeamMatcherSingleFlightCanceled(t *testing.T) {
 		OpenID:   "AAAAAAA",
 	}
 
-	ch := make(chan struct{})
-	var peopleRequests uint32
-	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
-		atomic.AddUint32(&peopleRequests, 1)
-		ch <- struct{}{}
-		time.Sleep(10 * time.Millisecond)
-		req.ParseForm()
-		c.Check(req.Method, qt.Equals, "GET")
-		c.Check(req.Form.Get("ws.op"), qt.Equals, "getByOpenIDIdentifier")
-		c.Check(req.Form.Get("identifier"), qt.Equals, "https://login.launchpad.net/+id/AAAAAAA")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"name": "test", "super_teams_collection_link": "http://%s/test/super_teams"}`, req.Host)
-	})
-
-	var teamRequests uint32
-	mux.HandleFunc("/test/super_teams", func(w http.ResponseWriter, req *http.Request) {
-		atomic.AddUint32(&teamRequests, 1)
-		c.Check(req.Method, qt.Equals, "GET")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"total_size":3,"start":0,"entries": [{"web_link": "https://launchpad.net/~test1"},{"web_link":"https://launchpad.net/~test2"}]}`)
-	})
-
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -246,21 +266,26 @@ func TestLaunchpadTeamMatcherSingleFlightCanceled(t *testing.T) {
 	}()
 
 	wg.Wait()
-	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(1))
-	c.Check(atomic.LoadUint32(&teamRequests), qt.Equals, uint32(1))
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 1)
 }
 
 func TestLaunchpadTeamMatcherCache(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
-	mux := http.NewServeMux()
-	srv := httptest.NewServer(mux)
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	srv := lp.Start()
 	c.Cleanup(srv.Close)
 
+	cache := new(ssoauthtest.RecordingCache)
 	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
 		APIBase: lpad.APIBase(srv.URL),
-		Cache:   make(testCache),
+		Cache:   cache,
 	}
 
 	acc := &ssoauth.Account{
@@ -268,25 +293,6 @@ func TestLaunchpadTeamMatcherCache(t *testing.T) {
 		OpenID:   "AAAAAAA",
 	}
 
-	var peopleRequests uint32
-	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
-		atomic.AddUint32(&peopleRequests, 1)
-		req.ParseForm()
-		c.Check(req.Method, qt.Equals, "GET")
-		c.Check(req.Form.Get("ws.op"), qt.Equals, "getByOpenIDIdentifier")
-		c.Check(req.Form.Get("identifier"), qt.Equals, "https://login.launchpad.net/+id/AAAAAAA")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"name": "test", "super_teams_collection_link": "http://%s/test/super_teams"}`, req.Host)
-	})
-
-	var teamRequests uint32
-	mux.HandleFunc("/test/super_teams", func(w http.ResponseWriter, req *http.Request) {
-		atomic.AddUint32(&teamRequests, 1)
-		c.Check(req.Method, qt.Equals, "GET")
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"total_size":3,"start":0,"entries": [{"web_link": "https://launchpad.net/~test1"},{"web_link":"https://launchpad.net/~test2"}]}`)
-	})
-
 	ids, err := m.MatchIdentity(ctx, acc, []string{
 		"https://launchpad.net/~test1",
 		"https://launchpad.net/~test2",
@@ -311,25 +317,166 @@ func TestLaunchpadTeamMatcherCache(t *testing.T) {
 		"https://launchpad.net/~test2",
 	})
 
-	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(1))
-	c.Check(atomic.LoadUint32(&teamRequests), qt.Equals, uint32(1))
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 1)
+	c.Check(cache.GetCalls, qt.DeepEquals, []string{
+		"https://login.launchpad.net/+id/AAAAAAA",
+		"https://login.launchpad.net/+id/AAAAAAA",
+	})
+	c.Check(cache.AddCalls, qt.DeepEquals, []string{
+		"https://login.launchpad.net/+id/AAAAAAA",
+	})
 }
 
-type testCache map[string][]string
+func TestLaunchpadTeamMatcherPresetCache(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	cache := &ssoauthtest.RecordingCache{
+		GetResults: map[string][]string{
+			"https://login.launchpad.net/+id/AAAAAAA": {"https://launchpad.net/~test1"},
+		},
+	}
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+		Cache:   cache,
+	}
+
+	ids, err := m.MatchIdentity(ctx, &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test1"})
+	c.Check(lp.CallCount("/people"), qt.Equals, 0)
+	c.Check(cache.AddCalls, qt.HasLen, 0)
+}
+
+func TestLaunchpadTeamMatcherNormalizesTeams(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~Test1/",
+		"https://launchpad.net/~test2",
+	})
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	cache := new(ssoauthtest.RecordingCache)
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+		Cache:   cache,
+	}
 
-func (c testCache) Add(key string, value []string) {
-	c[key] = value
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	ids, err := m.MatchIdentity(ctx, acc, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~TEST2/",
+		"https://launchpad.net/~test3",
+	})
+	c.Check(err, qt.IsNil)
+	sort.Strings(ids)
+	c.Check(ids, qt.DeepEquals, []string{
+		"https://launchpad.net/~TEST2/",
+		"https://launchpad.net/~test1",
+	})
+	c.Check(cache.GetResults["https://login.launchpad.net/+id/AAAAAAA"], qt.DeepEquals, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
 }
 
-func (c testCache) Get(key string) ([]string, bool) {
-	v, ok := c[key]
-	return v, ok
+var _ ssoauthacl.Cache = (*ssoauthtest.RecordingCache)(nil)
+
+func TestLaunchpadTeamMatcherInvalidateCache(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+	})
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	m := ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+		Cache:   new(ssoauthtest.RecordingCache),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	match := func() {
+		ids, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+		c.Check(err, qt.IsNil)
+		c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test1"})
+	}
+	match()
+	match()
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+
+	m.InvalidateCache(ssoauthacl.DefaultLaunchpadOpenID(acc))
+	match()
+	c.Check(lp.CallCount("/people"), qt.Equals, 2)
+
+	m.FlushCache()
+	match()
+	match()
+	c.Check(lp.CallCount("/people"), qt.Equals, 3)
 }
 
 func TestLaunchpadTeamMatcherNotFound(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
 
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/BBBBBBB", "test", []string{
+		"https://launchpad.net/~test1",
+	})
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	ids, err := m.MatchIdentity(ctx, acc, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+		"https://launchpad.net/~test3",
+	})
+
+	c.Check(err, qt.IsNil)
+	c.Check(ids, qt.HasLen, 0)
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 0)
+}
+
+func TestLaunchpadTeamMatcherPagination(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
 	mux := http.NewServeMux()
 	srv := httptest.NewServer(mux)
 	c.Cleanup(srv.Close)
@@ -344,28 +491,272 @@ func TestLaunchpadTeamMatcherNotFound(t *testing.T) {
 	}
 
 	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
-		req.ParseForm()
-		c.Check(req.Method, qt.Equals, "GET")
-		c.Check(req.Form.Get("ws.op"), qt.Equals, "getByOpenIDIdentifier")
-		c.Check(req.Form.Get("identifier"), qt.Equals, "https://login.launchpad.net/+id/AAAAAAA")
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `null`)
+		fmt.Fprintf(w, `{"name": "test", "super_teams_collection_link": "http://%s/test/super_teams"}`, req.Host)
 	})
 
+	// The first page has no total_size, as happens with large
+	// launchpad collections.
 	mux.HandleFunc("/test/super_teams", func(w http.ResponseWriter, req *http.Request) {
-		c.Check(req.Method, qt.Equals, "GET")
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"total_size":3,"start":0,"entries": [{"web_link": "https://launchpad.net/~test1"},{"web_link":"https://launchpad.net/~test2"}]}`)
+		if req.URL.Query().Get("ws.start") == "2" {
+			fmt.Fprintf(w, `{"start":2,"entries": [{"web_link": "https://launchpad.net/~test3"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"start":0,"entries": [{"web_link": "https://launchpad.net/~test1"},{"web_link":"https://launchpad.net/~test2"}], "next_collection_link": "http://%s/test/super_teams?ws.start=2"}`, req.Host)
 	})
 
 	ids, err := m.MatchIdentity(ctx, acc, []string{
 		"https://launchpad.net/~test1",
-		"https://launchpad.net/~test2",
 		"https://launchpad.net/~test3",
+		"https://launchpad.net/~test4",
+	})
+	c.Check(err, qt.IsNil)
+	sort.Strings(ids)
+	c.Check(ids, qt.DeepEquals, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test3",
+	})
+}
+
+func TestLaunchpadTeamMatcherRetry(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
 	})
+	var peopleRequests uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/people" && atomic.AddUint32(&peopleRequests, 1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		lp.ServeHTTP(w, req)
+	}))
+	c.Cleanup(srv.Close)
 
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:     lpad.APIBase(srv.URL),
+		RetryPolicy: ssoauthacl.ExponentialBackoff(3, time.Millisecond),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	ids, err := m.MatchIdentity(ctx, acc, []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
 	c.Check(err, qt.IsNil)
-	c.Check(ids, qt.HasLen, 0)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test1"})
+	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(3))
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 1)
+}
+
+func TestLaunchpadTeamMatcherRetryExhausted(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:     lpad.APIBase(srv.URL),
+		RetryPolicy: ssoauthacl.ExponentialBackoff(2, time.Millisecond),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	var peopleRequests uint32
+	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&peopleRequests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+	c.Check(err, qt.ErrorMatches, `Server returned 503 and body: unavailable\n`)
+	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(2))
+}
+
+func TestLaunchpadTeamMatcherNoRetryClientError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:     lpad.APIBase(srv.URL),
+		RetryPolicy: ssoauthacl.ExponentialBackoff(3, time.Millisecond),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	var peopleRequests uint32
+	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&peopleRequests, 1)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+
+	_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+	c.Check(err, qt.ErrorMatches, `Server returned 403 and body: forbidden\n`)
+	c.Check(atomic.LoadUint32(&peopleRequests), qt.Equals, uint32(1))
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	c := qt.New(t)
+
+	p := ssoauthacl.ExponentialBackoff(3, time.Second)
+	c.Check(p.Delay(1), qt.Equals, time.Second)
+	c.Check(p.Delay(2), qt.Equals, 2*time.Second)
+	c.Check(p.Delay(3), qt.Equals, 4*time.Second)
+
+	serverErr := &lpad.Error{StatusCode: 500}
+	c.Check(p.ShouldRetry(1, serverErr), qt.IsTrue)
+	c.Check(p.ShouldRetry(2, errgo.Mask(serverErr, errgo.Any)), qt.IsTrue)
+	c.Check(p.ShouldRetry(3, serverErr), qt.IsFalse)
+	c.Check(p.ShouldRetry(1, &lpad.Error{StatusCode: 404}), qt.IsFalse)
+	c.Check(p.ShouldRetry(1, errgo.New("other error")), qt.IsFalse)
+}
+
+func TestLaunchpadTeamMatcherNotFoundCache(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+		Cache:   new(ssoauthtest.RecordingCache),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	for i := 0; i < 2; i++ {
+		ids, err := m.MatchIdentity(ctx, acc, []string{
+			"https://launchpad.net/~test1",
+		})
+		c.Check(err, qt.IsNil)
+		c.Check(ids, qt.HasLen, 0)
+	}
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+}
+
+func TestLaunchpadTeamMatcherRequestTimeout(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:        lpad.APIBase(srv.URL),
+		RequestTimeout: 10 * time.Millisecond,
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `null`)
+	})
+
+	start := time.Now()
+	_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+	c.Check(err, qt.ErrorMatches, `.*context deadline exceeded.*`)
+	c.Check(time.Since(start) < 5*time.Second, qt.IsTrue)
+}
+
+func TestLaunchpadTeamMatcherSingleflightDefaultTimeout(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	c.Cleanup(ssoauthacl.SetSharedRequestTimeout(10 * time.Millisecond))
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:           lpad.APIBase(srv.URL),
+		SingleflightGroup: new(singleflight.Group),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `null`)
+	})
+
+	// The request is detached from the caller's context, which has
+	// no deadline, but is still bounded.
+	start := time.Now()
+	_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+	c.Check(err, qt.ErrorMatches, `.*context deadline exceeded.*`)
+	c.Check(time.Since(start) < 5*time.Second, qt.IsTrue)
+}
+
+func TestLaunchpadTeamMatcherContextCanceled(t *testing.T) {
+	c := qt.New(t)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+
+	var m ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+	}
+
+	acc := &ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "AAAAAAA",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mux.HandleFunc("/people", func(w http.ResponseWriter, req *http.Request) {
+		cancel()
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `null`)
+	})
+
+	_, err := m.MatchIdentity(ctx, acc, []string{"https://launchpad.net/~test1"})
+	c.Check(err, qt.ErrorMatches, `.*context canceled.*`)
 }
 
 func TestDefaultLaunchpadOpenID(t *testing.T) {
@@ -387,3 +778,127 @@ func TestDefaultLaunchpadOpenID(t *testing.T) {
 		OpenID:   "DDDDDDD",
 	}), qt.Equals, "https://login-lp.staging.ubuntu.com/+id/DDDDDDD")
 }
+
+func TestLaunchpadTeamMatcherHealthCheck(t *testing.T) {
+	c := qt.New(t)
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	m := ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+	}
+	err := m.HealthCheck(context.Background())
+	c.Assert(err, qt.IsNil)
+	c.Check(lp.CallCount("/"), qt.Equals, 1)
+
+	srv.Close()
+	err = m.HealthCheck(context.Background())
+	c.Check(err, qt.ErrorMatches, `launchpad API unavailable: .*connection refused`)
+}
+
+func TestLaunchpadTeamMatcherHealthCheckTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+	done := make(chan struct{})
+	c.Cleanup(func() { close(done) })
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-done:
+		}
+	})
+
+	m := ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := m.HealthCheck(ctx)
+	c.Check(err, qt.ErrorMatches, `launchpad API unavailable: .*context deadline exceeded`)
+	c.Check(errgo.Cause(err), qt.Equals, context.DeadlineExceeded)
+}
+
+func TestLaunchpadTeamMatcherPrefetch(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	openIDs := []string{"a", "b", "c", "d", "e", "f"}
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	for _, oid := range openIDs {
+		lp.AddUser(oid, oid, []string{"https://launchpad.net/~" + oid})
+	}
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/people" {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			// Give other requests the chance to run concurrently.
+			time.Sleep(10 * time.Millisecond)
+		}
+		lp.ServeHTTP(w, req)
+	}))
+	c.Cleanup(srv.Close)
+
+	cache := ssoauthacl.NewLRUCache(10)
+	m := ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:             lpad.APIBase(srv.URL),
+		Cache:               cache,
+		PrefetchConcurrency: 2,
+	}
+	err := m.Prefetch(ctx, openIDs)
+	c.Assert(err, qt.IsNil)
+	c.Check(atomic.LoadInt32(&maxInFlight), qt.Equals, int32(2))
+	for _, oid := range openIDs {
+		teams, ok := cache.Get(oid)
+		c.Check(ok, qt.IsTrue)
+		c.Check(teams, qt.DeepEquals, []string{"https://launchpad.net/~" + oid})
+	}
+}
+
+func TestLaunchpadTeamMatcherPrefetchErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("good", "test", nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/people" && req.FormValue("identifier") != "good" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		lp.ServeHTTP(w, req)
+	}))
+	c.Cleanup(srv.Close)
+
+	cache := ssoauthacl.NewLRUCache(10)
+	var buf bytes.Buffer
+	m := ssoauthacl.LaunchpadTeamMatcher{
+		APIBase: lpad.APIBase(srv.URL),
+		Cache:   cache,
+		Logger:  slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+
+	// Some lookups succeed.
+	err := m.Prefetch(ctx, []string{"good", "bad"})
+	c.Assert(err, qt.IsNil)
+	c.Check(buf.String(), qt.Matches, `.*level=WARN msg="cannot prefetch launchpad teams" openid=bad .*\n`)
+	_, ok := cache.Get("good")
+	c.Check(ok, qt.IsTrue)
+	_, ok = cache.Get("bad")
+	c.Check(ok, qt.IsFalse)
+
+	// All lookups fail.
+	err = m.Prefetch(ctx, []string{"bad"})
+	c.Check(err, qt.ErrorMatches, `cannot prefetch launchpad teams: bad: .*unavailable\n`)
+}
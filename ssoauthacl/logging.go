@@ -0,0 +1,50 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/canonical/ssoauth"
+)
+
+// A LoggingMatcher is an IdentityMatcher that logs each call to the Inner
+// matcher. Calls and their results are logged at debug level, errors are
+// logged at warning level. Accounts are identified in the log by their
+// OpenID only. If Logger is nil slog.Default is used.
+type LoggingMatcher struct {
+	Inner  IdentityMatcher
+	Logger *slog.Logger
+}
+
+// NewLoggingMatcher creates a LoggingMatcher that logs calls to inner
+// using the given logger.
+func NewLoggingMatcher(inner IdentityMatcher, logger *slog.Logger) *LoggingMatcher {
+	return &LoggingMatcher{
+		Inner:  inner,
+		Logger: logger,
+	}
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m LoggingMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	var openID string
+	if acc != nil {
+		openID = acc.OpenID
+	}
+	logger = logger.With("openid", openID)
+	logger.DebugContext(ctx, "matching identities", "ids", len(ids))
+	mids, err := m.Inner.MatchIdentity(ctx, acc, ids)
+	if err != nil {
+		logger.WarnContext(ctx, "cannot match identities", "matched", mids, "error", err)
+	} else {
+		logger.DebugContext(ctx, "matched identities", "matched", mids)
+	}
+	return mids, err
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+)
+
+func TestLoggingMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	m := ssoauthacl.NewLoggingMatcher(ssoauthacl.UsernameMatcher{}, logger)
+	acc := &ssoauth.Account{
+		OpenID:   "AAAAAAA",
+		Username: "bob",
+		Email:    "bob@example.com",
+	}
+	ids, err := m.MatchIdentity(ctx, acc, []string{"username:alice", "username:bob"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"username:bob"})
+
+	c.Check(buf.String(), qt.Not(qt.Contains), "bob@example.com")
+	c.Check(logRecords(c, &buf), qt.DeepEquals, []map[string]interface{}{{
+		"level":  "DEBUG",
+		"msg":    "matching identities",
+		"openid": "AAAAAAA",
+		"ids":    2.0,
+	}, {
+		"level":   "DEBUG",
+		"msg":     "matched identities",
+		"openid":  "AAAAAAA",
+		"matched": []interface{}{"username:bob"},
+	}})
+}
+
+func TestLoggingMatcherError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	m := ssoauthacl.NewLoggingMatcher(ssoauthacl.ErrorMatcher{Err: errgo.New("test error")}, slog.New(slog.NewJSONHandler(&buf, nil)))
+	_, err := m.MatchIdentity(ctx, &ssoauth.Account{OpenID: "AAAAAAA"}, []string{"username:bob"})
+	c.Assert(err, qt.ErrorMatches, `test error`)
+
+	// Only the error is logged at the default level.
+	c.Check(logRecords(c, &buf), qt.DeepEquals, []map[string]interface{}{{
+		"level":   "WARN",
+		"msg":     "cannot match identities",
+		"openid":  "AAAAAAA",
+		"matched": nil,
+		"error":   "test error",
+	}})
+}
+
+// logRecords parses the JSON log records in buf, omitting their times.
+func logRecords(c *qt.C, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var r map[string]interface{}
+		err := json.Unmarshal([]byte(line), &r)
+		c.Assert(err, qt.IsNil)
+		delete(r, "time")
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/canonical/ssoauth"
+)
+
+// MatcherMetrics holds counters describing the use of an
+// IdentityMatcher.
+type MatcherMetrics struct {
+	// Calls holds the number of calls to MatchIdentity.
+	Calls atomic.Uint64
+
+	// Matches holds the total number of identities matched.
+	Matches atomic.Uint64
+
+	// Errors holds the number of calls to MatchIdentity that
+	// returned an error.
+	Errors atomic.Uint64
+}
+
+// Reset sets all the counters to zero.
+func (m *MatcherMetrics) Reset() {
+	m.Calls.Store(0)
+	m.Matches.Store(0)
+	m.Errors.Store(0)
+}
+
+// A MetricsMatcher is an IdentityMatcher that records the use of the
+// Inner matcher in Metrics.
+type MetricsMatcher struct {
+	Inner   IdentityMatcher
+	Metrics *MatcherMetrics
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m MetricsMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	m.Metrics.Calls.Add(1)
+	mids, err := m.Inner.MatchIdentity(ctx, acc, ids)
+	m.Metrics.Matches.Add(uint64(len(mids)))
+	if err != nil {
+		m.Metrics.Errors.Add(1)
+	}
+	return mids, err
+}
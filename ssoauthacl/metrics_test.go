@@ -0,0 +1,48 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+)
+
+func TestMetricsMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	metrics := new(ssoauthacl.MatcherMetrics)
+	m := ssoauthacl.MetricsMatcher{
+		Inner:   ssoauthacl.UsernameMatcher{},
+		Metrics: metrics,
+	}
+	acc := &ssoauth.Account{Username: "bob"}
+	for _, ids := range [][]string{
+		{"username:bob", "username:BOB"},
+		{"username:alice"},
+		{"username:bob"},
+	} {
+		_, err := m.MatchIdentity(ctx, acc, ids)
+		c.Assert(err, qt.IsNil)
+	}
+
+	m.Inner = ssoauthacl.ErrorMatcher{Err: errgo.New("test error")}
+	_, err := m.MatchIdentity(ctx, acc, []string{"username:bob"})
+	c.Assert(err, qt.ErrorMatches, `test error`)
+
+	c.Check(metrics.Calls.Load(), qt.Equals, uint64(4))
+	c.Check(metrics.Matches.Load(), qt.Equals, uint64(3))
+	c.Check(metrics.Errors.Load(), qt.Equals, uint64(1))
+
+	metrics.Reset()
+	c.Check(metrics.Calls.Load(), qt.Equals, uint64(0))
+	c.Check(metrics.Matches.Load(), qt.Equals, uint64(0))
+	c.Check(metrics.Errors.Load(), qt.Equals, uint64(0))
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"path"
+	"strings"
+
+	"github.com/canonical/ssoauth"
+)
+
+// An OpenIDRule maps accounts from matching SSO providers to launchpad
+// OpenIDs.
+type OpenIDRule struct {
+	// Provider holds a pattern, in the syntax used by path.Match,
+	// that is matched against the account's Provider.
+	Provider string
+
+	// Template holds the launchpad OpenID for matching accounts. Each
+	// occurrence of "{openid}" is replaced with the account's OpenID.
+	Template string
+}
+
+// An OpenIDMapper maps accounts to launchpad OpenIDs using a list of
+// rules. The first rule that matches the account's provider is used. Its
+// LaunchpadOpenID method can be used as the LaunchpadOpenID function of
+// a LaunchpadTeamMatcher.
+type OpenIDMapper struct {
+	Rules []OpenIDRule
+}
+
+// DefaultOpenIDMapper returns an OpenIDMapper holding the rules used by
+// DefaultLaunchpadOpenID. Further rules can be added with Prepend, to
+// take precedence over the defaults, or by appending to Rules.
+func DefaultOpenIDMapper() *OpenIDMapper {
+	return &OpenIDMapper{
+		Rules: []OpenIDRule{{
+			Provider: "login.launchpad.net",
+			Template: "https://login.launchpad.net/+id/{openid}",
+		}, {
+			Provider: "login.ubuntu.com",
+			Template: "https://login.launchpad.net/+id/{openid}",
+		}, {
+			Provider: "login-lp.staging.ubuntu.com",
+			Template: "https://login-lp.staging.ubuntu.com/+id/{openid}",
+		}, {
+			Provider: "login.staging.ubuntu.com",
+			Template: "https://login-lp.staging.ubuntu.com/+id/{openid}",
+		}},
+	}
+}
+
+// Prepend adds the given rules before the existing rules, so that they
+// take precedence.
+func (m *OpenIDMapper) Prepend(rules ...OpenIDRule) {
+	m.Rules = append(append([]OpenIDRule(nil), rules...), m.Rules...)
+}
+
+// LaunchpadOpenID returns the launchpad OpenID for the given account
+// using the first matching rule. If no rule matches it returns "".
+func (m *OpenIDMapper) LaunchpadOpenID(acc *ssoauth.Account) string {
+	for _, r := range m.Rules {
+		if ok, err := path.Match(r.Provider, acc.Provider); ok && err == nil {
+			return strings.ReplaceAll(r.Template, "{openid}", acc.OpenID)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"launchpad.net/lpad"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestDefaultOpenIDMapper(t *testing.T) {
+	c := qt.New(t)
+
+	m := ssoauthacl.DefaultOpenIDMapper()
+	for _, provider := range []string{
+		"login.ubuntu.com",
+		"login.launchpad.net",
+		"login.staging.ubuntu.com",
+		"login-lp.staging.ubuntu.com",
+		"login.example.com",
+	} {
+		acc := &ssoauth.Account{Provider: provider, OpenID: "AAAAAAA"}
+		c.Check(m.LaunchpadOpenID(acc), qt.Equals, ssoauthacl.DefaultLaunchpadOpenID(acc), qt.Commentf("%s", provider))
+	}
+}
+
+func TestOpenIDMapperCustomRule(t *testing.T) {
+	c := qt.New(t)
+
+	m := ssoauthacl.DefaultOpenIDMapper()
+	m.Prepend(ssoauthacl.OpenIDRule{
+		Provider: "login*.example.com",
+		Template: "https://login.example.com/+openid/{openid}",
+	}, ssoauthacl.OpenIDRule{
+		// Overrides the default rule.
+		Provider: "login.ubuntu.com",
+		Template: "https://login.ubuntu.com/+id/{openid}",
+	})
+
+	c.Check(m.LaunchpadOpenID(&ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+	}), qt.Equals, "https://login.example.com/+openid/AAAAAAA")
+	c.Check(m.LaunchpadOpenID(&ssoauth.Account{
+		Provider: "login-new.example.com",
+		OpenID:   "BBBBBBB",
+	}), qt.Equals, "https://login.example.com/+openid/BBBBBBB")
+	c.Check(m.LaunchpadOpenID(&ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "CCCCCCC",
+	}), qt.Equals, "https://login.ubuntu.com/+id/CCCCCCC")
+	c.Check(m.LaunchpadOpenID(&ssoauth.Account{
+		Provider: "login.launchpad.net",
+		OpenID:   "DDDDDDD",
+	}), qt.Equals, "https://login.launchpad.net/+id/DDDDDDD")
+	c.Check(m.LaunchpadOpenID(&ssoauth.Account{
+		Provider: "example.com",
+		OpenID:   "EEEEEEE",
+	}), qt.Equals, "")
+
+	// The default rules are unaffected.
+	c.Check(ssoauthacl.DefaultLaunchpadOpenID(&ssoauth.Account{
+		Provider: "login.ubuntu.com",
+		OpenID:   "CCCCCCC",
+	}), qt.Equals, "https://login.launchpad.net/+id/CCCCCCC")
+}
+
+func TestLaunchpadTeamMatcherOpenIDMapper(t *testing.T) {
+	c := qt.New(t)
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.example.com/+id/AAAAAAA", "test", []string{"https://launchpad.net/~test"})
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	m := ssoauthacl.DefaultOpenIDMapper()
+	m.Prepend(ssoauthacl.OpenIDRule{
+		Provider: "login.example.com",
+		Template: "https://login.example.com/+id/{openid}",
+	})
+	var matcher ssoauthacl.IdentityMatcher = ssoauthacl.LaunchpadTeamMatcher{
+		APIBase:         lpad.APIBase(srv.URL),
+		LaunchpadOpenID: m.LaunchpadOpenID,
+	}
+	ids, err := matcher.MatchIdentity(context.Background(), &ssoauth.Account{
+		Provider: "login.example.com",
+		OpenID:   "AAAAAAA",
+	}, []string{"https://launchpad.net/~test"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test"})
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/canonical/ssoauth"
+)
+
+// A TracingMatcher is an IdentityMatcher that records a span for each
+// call to the Inner matcher using Tracer.
+type TracingMatcher struct {
+	Inner  IdentityMatcher
+	Tracer trace.Tracer
+}
+
+// MatchIdentity implements IdentityMatcher.
+func (m TracingMatcher) MatchIdentity(ctx context.Context, acc *ssoauth.Account, ids []string) ([]string, error) {
+	ctx, span := m.Tracer.Start(ctx, "ssoauthacl.MatchIdentity", trace.WithAttributes(
+		attribute.Int("ssoauthacl.ids", len(ids)),
+	))
+	defer span.End()
+	mids, err := m.Inner.MatchIdentity(ctx, acc, ids)
+	span.SetAttributes(attribute.Int("ssoauthacl.matched", len(mids)))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return mids, err
+}
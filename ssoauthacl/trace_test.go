@@ -0,0 +1,71 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthacl_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthacl"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestTracingMatcher(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tracer := new(ssoauthtest.Tracer)
+	m := ssoauthacl.TracingMatcher{
+		Inner:  ssoauthacl.UsernameMatcher{},
+		Tracer: tracer,
+	}
+	acc := &ssoauth.Account{Username: "bob"}
+	ids, err := m.MatchIdentity(ctx, acc, []string{"username:alice", "username:bob"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"username:bob"})
+
+	spans := tracer.Spans()
+	c.Assert(spans, qt.HasLen, 1)
+	c.Check(spans[0].Name(), qt.Equals, "ssoauthacl.MatchIdentity")
+	c.Check(spans[0].Attribute("ssoauthacl.ids").AsInt64(), qt.Equals, int64(2))
+	c.Check(spans[0].Attribute("ssoauthacl.matched").AsInt64(), qt.Equals, int64(1))
+	c.Check(spans[0].Status(), qt.Equals, codes.Unset)
+	c.Check(spans[0].Ended(), qt.IsTrue)
+}
+
+func TestTracingMatcherError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tracer := new(ssoauthtest.Tracer)
+	m := ssoauthacl.TracingMatcher{
+		Inner:  ssoauthacl.ErrorMatcher{Err: errgo.New("test error")},
+		Tracer: tracer,
+	}
+	_, err := m.MatchIdentity(ctx, &ssoauth.Account{}, []string{"username:bob"})
+	c.Assert(err, qt.ErrorMatches, `test error`)
+
+	spans := tracer.Spans()
+	c.Assert(spans, qt.HasLen, 1)
+	c.Check(spans[0].Attribute("ssoauthacl.matched").AsInt64(), qt.Equals, int64(0))
+	c.Check(spans[0].Status(), qt.Equals, codes.Error)
+}
+
+func TestTracingMatcherNoop(t *testing.T) {
+	c := qt.New(t)
+
+	m := ssoauthacl.TracingMatcher{
+		Inner:  ssoauthacl.AllowAllMatcher{},
+		Tracer: noop.NewTracerProvider().Tracer("test"),
+	}
+	ids, err := m.MatchIdentity(context.Background(), &ssoauth.Account{}, []string{"a", "b"})
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"a", "b"})
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package ssoauthgrpc provides gRPC interceptors that authenticate
+// requests using SSO macaroons.
+package ssoauthgrpc
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+)
+
+// An Option configures optional behaviour of the interceptors.
+type Option func(*options)
+
+// options holds the configuration set by Options.
+type options struct {
+	logger *slog.Logger
+}
+
+// WithLogger configures the interceptors to log to the given logger. By
+// default messages are logged using the standard log package.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// newOptions returns the options configured by opts.
+func newOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor that authenticates
+// every unary call using the given Authenticator before passing it to
+// the handler. The macaroons are read from the "authorization" metadata
+// key, which must have a value of the form "Macaroon <base64>". The
+// authenticated Account is stored in the handler's context and can be
+// retrieved with ssoauth.AccountFromContext.
+//
+// If the call cannot be authenticated it fails with the status code
+// Unauthenticated. If authentication fails for any other reason the
+// error is logged and the call fails with the status code Internal
+// without revealing the error to the caller.
+func UnaryServerInterceptor(a *ssoauth.Authenticator, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		acc, err := o.authenticate(ctx, a, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ssoauth.WithAccount(ctx, acc), req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC interceptor that authenticates
+// every streaming call in the same way as UnaryServerInterceptor.
+func StreamServerInterceptor(a *ssoauth.Authenticator, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		acc, err := o.authenticate(ctx, a, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          ssoauth.WithAccount(ctx, acc),
+		})
+	}
+}
+
+// serverStream is a grpc.ServerStream with a replacement context.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context implements grpc.ServerStream.Context.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate authenticates the macaroons in the incoming metadata of
+// the given context for a call to the given method, returning a gRPC
+// status error on failure.
+func (o *options) authenticate(ctx context.Context, a *ssoauth.Authenticator, method string) (*ssoauth.Account, error) {
+	ms, err := macaroonsFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ctx = ssoauth.WithRemoteAddr(ctx, p.Addr.String())
+	}
+	acc, err := a.Authenticate(ctx, ms)
+	if err == nil {
+		return acc, nil
+	}
+	switch cause := errgo.Cause(err); {
+	case cause == ssoauth.ErrUnauthorized:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case cause == context.Canceled || cause == context.DeadlineExceeded:
+		return nil, status.FromContextError(cause).Err()
+	default:
+		if o.logger != nil {
+			o.logger.ErrorContext(ctx, "cannot authenticate call", "method", method, "error", err)
+		} else {
+			log.Printf("cannot authenticate call to %s: %v", method, err)
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+}
+
+// macaroonsFromContext reads the macaroons from the authorization key
+// of the incoming metadata in the given context.
+func macaroonsFromContext(ctx context.Context) (macaroon.Slice, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, errgo.New("no macaroon")
+	}
+	scheme, data, ok := strings.Cut(values[0], " ")
+	if !ok || scheme != "Macaroon" {
+		return nil, errgo.New("unsupported authorization scheme")
+	}
+	ms, err := ssoauth.UnmarshalMacaroonSlice([]byte(data))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse macaroon")
+	}
+	return ms, nil
+}
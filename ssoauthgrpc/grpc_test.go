@@ -0,0 +1,201 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthgrpc_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthgrpc"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	c := qt.New(t)
+	a, d := newAuthenticator(c)
+	hs := new(healthServer)
+	client := newClient(c, a, hs)
+
+	ctx := withMacaroons(c, context.Background(), dischargedMacaroon(c, a, d))
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	c.Assert(err, qt.IsNil)
+	c.Check(resp.Status, qt.Equals, healthpb.HealthCheckResponse_SERVING)
+	c.Check(hs.openID, qt.Equals, "AAAAAAA")
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	c := qt.New(t)
+	a, d := newAuthenticator(c)
+	hs := new(healthServer)
+	client := newClient(c, a, hs)
+
+	ctx := withMacaroons(c, context.Background(), dischargedMacaroon(c, a, d))
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	c.Assert(err, qt.IsNil)
+	resp, err := stream.Recv()
+	c.Assert(err, qt.IsNil)
+	c.Check(resp.Status, qt.Equals, healthpb.HealthCheckResponse_SERVING)
+	c.Check(hs.openID, qt.Equals, "AAAAAAA")
+}
+
+func TestInterceptorsUnauthenticated(t *testing.T) {
+	c := qt.New(t)
+	a, d := newAuthenticator(c)
+	client := newClient(c, a, new(healthServer))
+
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+	expired := ssoauthtest.MustDischarge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(-time.Minute), time.Time{})
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		expectError string
+	}{{
+		name:        "no-macaroon",
+		ctx:         context.Background(),
+		expectError: `no macaroon`,
+	}, {
+		name:        "bad-scheme",
+		ctx:         metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer xxx"),
+		expectError: `unsupported authorization scheme`,
+	}, {
+		name:        "bad-macaroon",
+		ctx:         metadata.AppendToOutgoingContext(context.Background(), "authorization", "Macaroon !!!"),
+		expectError: `cannot parse macaroon: .*`,
+	}, {
+		name:        "expired",
+		ctx:         withMacaroons(c, context.Background(), expired),
+		expectError: `.*macaroon expired.*`,
+	}}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			_, err := client.Check(test.ctx, &healthpb.HealthCheckRequest{})
+			c.Check(status.Code(err), qt.Equals, codes.Unauthenticated)
+			c.Check(status.Convert(err).Message(), qt.Matches, test.expectError)
+
+			stream, err := client.Watch(test.ctx, &healthpb.HealthCheckRequest{})
+			c.Assert(err, qt.IsNil)
+			_, err = stream.Recv()
+			c.Check(status.Code(err), qt.Equals, codes.Unauthenticated)
+			c.Check(status.Convert(err).Message(), qt.Matches, test.expectError)
+		})
+	}
+}
+
+func TestInterceptorsInternalError(t *testing.T) {
+	c := qt.New(t)
+	d := ssoauthtest.NewDischarger("login.example.com")
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	}, ssoauth.WithNonceStore(errorNonceStore{}))
+	var buf bytes.Buffer
+	client := newClient(c, a, new(healthServer), ssoauthgrpc.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+	_, err = ssoauth.AddNonceCaveat(m.M(), d.Location())
+	c.Assert(err, qt.IsNil)
+	ctx := withMacaroons(c, context.Background(), ssoauthtest.MustDischarge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute), time.Time{}))
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	c.Check(status.Code(err), qt.Equals, codes.Internal)
+	c.Check(status.Convert(err).Message(), qt.Equals, "internal error")
+	c.Check(buf.String(), qt.Contains, "nonce store unavailable")
+	c.Check(buf.String(), qt.Contains, "/grpc.health.v1.Health/Check")
+}
+
+// errorNonceStore is a NonceStore that always fails.
+type errorNonceStore struct{}
+
+func (errorNonceStore) MarkUsed(context.Context, string) (bool, error) {
+	return false, errgo.New("nonce store unavailable")
+}
+
+// healthServer is a health service that reports that it is serving
+// only to authenticated callers, and records the caller's OpenID.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	openID string
+}
+
+func (s *healthServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	return s.response(ctx), nil
+}
+
+func (s *healthServer) Watch(_ *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	return stream.Send(s.response(stream.Context()))
+}
+
+func (s *healthServer) response(ctx context.Context) *healthpb.HealthCheckResponse {
+	acc, ok := ssoauth.AccountFromContext(ctx)
+	if !ok {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}
+	}
+	s.openID = acc.OpenID
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}
+}
+
+func newAuthenticator(c *qt.C) (*ssoauth.Authenticator, *ssoauthtest.Discharger) {
+	d := ssoauthtest.NewDischarger("login.example.com")
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	})
+	return a, d
+}
+
+// newClient starts a gRPC server serving hs using interceptors for a,
+// and returns a client connected to it.
+func newClient(c *qt.C, a *ssoauth.Authenticator, hs healthpb.HealthServer, opts ...ssoauthgrpc.Option) healthpb.HealthClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(ssoauthgrpc.UnaryServerInterceptor(a, opts...)),
+		grpc.StreamInterceptor(ssoauthgrpc.StreamServerInterceptor(a, opts...)),
+	)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	c.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	c.Assert(err, qt.IsNil)
+	c.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func dischargedMacaroon(c *qt.C, a *ssoauth.Authenticator, d *ssoauthtest.Discharger) macaroon.Slice {
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+	return ssoauthtest.MustDischarge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute), time.Time{})
+}
+
+func withMacaroons(c *qt.C, ctx context.Context, ms macaroon.Slice) context.Context {
+	data, err := ssoauth.MarshalMacaroonSlice(ms)
+	c.Assert(err, qt.IsNil)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Macaroon "+string(data))
+}
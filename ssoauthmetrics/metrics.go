@@ -0,0 +1,159 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package ssoauthmetrics provides Prometheus instrumentation for SSO
+// authentication.
+package ssoauthmetrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+)
+
+const namespace = "ssoauth"
+
+// Values of the result label of the authenticate_total metric.
+const (
+	resultSuccess      = "success"
+	resultUnauthorized = "unauthorized"
+	resultError        = "error"
+)
+
+// WithMetrics returns an ssoauth.Option that configures an
+// Authenticator to record Prometheus metrics describing its use. The
+// following metrics are recorded:
+//
+//	ssoauth_authenticate_total{result="success|unauthorized|error"}
+//	ssoauth_authenticate_duration_seconds
+//	ssoauth_macaroon_total
+//
+// The metrics are registered with reg, or with
+// prometheus.DefaultRegisterer if reg is nil. As with
+// prometheus.MustRegister, WithMetrics panics if the metrics cannot be
+// registered.
+func WithMetrics(reg prometheus.Registerer) ssoauth.Option {
+	return ssoauth.WithMetrics(newMetrics(reg))
+}
+
+// metrics implements ssoauth.Metrics by recording Prometheus metrics.
+type metrics struct {
+	authenticateTotal    *prometheus.CounterVec
+	authenticateDuration prometheus.Histogram
+	macaroonTotal        prometheus.Counter
+}
+
+// newMetrics creates the metrics and registers them with reg, or with
+// prometheus.DefaultRegisterer if reg is nil.
+func newMetrics(reg prometheus.Registerer) *metrics {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &metrics{
+		authenticateTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "authenticate_total",
+			Help:      "The number of SSO authentication attempts, by result.",
+		}, []string{"result"}),
+		authenticateDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "authenticate_duration_seconds",
+			Help:      "The time taken to authenticate SSO macaroons.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		macaroonTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "macaroon_total",
+			Help:      "The number of SSO macaroons created.",
+		}),
+	}
+	// Initialize each result so that it is reported before it first
+	// occurs.
+	for _, result := range []string{resultSuccess, resultUnauthorized, resultError} {
+		m.authenticateTotal.WithLabelValues(result)
+	}
+	reg.MustRegister(m.authenticateTotal, m.authenticateDuration, m.macaroonTotal)
+	return m
+}
+
+// ObserveAuthentication implements ssoauth.Metrics.
+func (m *metrics) ObserveAuthentication(_ context.Context, err error, d time.Duration) {
+	m.authenticateDuration.Observe(d.Seconds())
+	result := resultSuccess
+	switch {
+	case err == nil:
+	case errors.Is(err, ssoauth.ErrUnauthorized):
+		result = resultUnauthorized
+	default:
+		result = resultError
+	}
+	m.authenticateTotal.WithLabelValues(result).Inc()
+}
+
+// ObserveMacaroon implements ssoauth.Metrics.
+func (m *metrics) ObserveMacaroon(context.Context) {
+	m.macaroonTotal.Inc()
+}
+
+// An Authenticator wraps an ssoauth.Authenticator and records the same
+// Prometheus metrics as WithMetrics for the methods it wraps.
+//
+// Deprecated: use WithMetrics, which also records metrics for
+// AuthenticateOp, AuthenticateAll and MacaroonForOp.
+type Authenticator struct {
+	a *ssoauth.Authenticator
+	m *metrics
+}
+
+// NewInstrumentedAuthenticator creates an Authenticator that records
+// metrics about the use of a. The metrics are registered with reg, or
+// with prometheus.DefaultRegisterer if reg is nil. As with
+// prometheus.MustRegister, NewInstrumentedAuthenticator panics if the
+// metrics cannot be registered.
+//
+// Deprecated: use WithMetrics.
+func NewInstrumentedAuthenticator(a *ssoauth.Authenticator, reg prometheus.Registerer) *Authenticator {
+	return &Authenticator{
+		a: a,
+		m: newMetrics(reg),
+	}
+}
+
+// Macaroon calls Macaroon on the wrapped Authenticator, counting the
+// macaroons created.
+func (a *Authenticator) Macaroon(ctx context.Context) (*bakery.Macaroon, error) {
+	m, err := a.a.Macaroon(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	a.m.ObserveMacaroon(ctx)
+	return m, nil
+}
+
+// MacaroonForLocation calls MacaroonForLocation on the wrapped
+// Authenticator, counting the macaroons created.
+func (a *Authenticator) MacaroonForLocation(ctx context.Context, location string) (*bakery.Macaroon, error) {
+	m, err := a.a.MacaroonForLocation(ctx, location)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	a.m.ObserveMacaroon(ctx)
+	return m, nil
+}
+
+// Authenticate calls Authenticate on the wrapped Authenticator,
+// recording the result and the time taken. Any error is returned
+// unchanged.
+func (a *Authenticator) Authenticate(ctx context.Context, ms macaroon.Slice) (*ssoauth.Account, error) {
+	start := time.Now()
+	acc, err := a.a.Authenticate(ctx, ms)
+	a.m.ObserveAuthentication(ctx, err, time.Since(start))
+	return acc, err
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthmetrics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthmetrics"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestWithMetrics(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	reg := prometheus.NewRegistry()
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	}, ssoauthmetrics.WithMetrics(reg))
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = a.MacaroonForLocation(ctx, "login.example.org")
+	c.Assert(err, qt.ErrorMatches, `untrusted location "login.example.org"`)
+	op := bakery.Op{Entity: "document", Action: "read"}
+	opm, err := a.MacaroonForOp(ctx, op)
+	c.Assert(err, qt.IsNil)
+
+	ms := ssoauthtest.MustDischarge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute), time.Time{})
+	_, err = a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+
+	opms := ssoauthtest.MustDischarge(d, opm.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute), time.Time{})
+	_, err = a.AuthenticateOp(ctx, opms, op)
+	c.Assert(err, qt.IsNil)
+
+	expired := ssoauthtest.MustDischarge(d, m.M(), nil, time.Now().Add(-time.Minute), time.Time{})
+	_, errs := a.AuthenticateAll(ctx, []macaroon.Slice{ms, expired})
+	c.Assert(errs[0], qt.IsNil)
+	c.Assert(errs[1], qt.ErrorIs, ssoauth.ErrUnauthorized)
+
+	c.Check(counterValue(c, reg, "ssoauth_macaroon_total", ""), qt.Equals, 2.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "success"), qt.Equals, 3.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "unauthorized"), qt.Equals, 1.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "error"), qt.Equals, 0.0)
+}
+
+func TestInstrumentedAuthenticator(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	reg := prometheus.NewRegistry()
+	a := ssoauthmetrics.NewInstrumentedAuthenticator(ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	}), reg)
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = a.MacaroonForLocation(ctx, "login.example.com")
+	c.Assert(err, qt.IsNil)
+	_, err = a.MacaroonForLocation(ctx, "login.example.org")
+	c.Assert(err, qt.ErrorMatches, `untrusted location "login.example.org"`)
+
+	ms := ssoauthtest.MustDischarge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute), time.Time{})
+	acc, err := a.Authenticate(ctx, ms)
+	c.Assert(err, qt.IsNil)
+	c.Check(acc.OpenID, qt.Equals, "AAAAAAA")
+
+	ms = ssoauthtest.MustDischarge(d, m.M(), nil, time.Now().Add(-time.Minute), time.Time{})
+	_, err = a.Authenticate(ctx, ms)
+	c.Assert(err, qt.ErrorIs, ssoauth.ErrUnauthorized)
+	_, err = a.Authenticate(ctx, ms)
+	c.Assert(err, qt.ErrorIs, ssoauth.ErrUnauthorized)
+
+	ctx1, cancel := context.WithCancel(ctx)
+	cancel()
+	ms = ssoauthtest.MustDischarge(d, m.M(), nil, time.Time{}, time.Time{})
+	_, err = a.Authenticate(ctx1, ms)
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+
+	c.Check(counterValue(c, reg, "ssoauth_macaroon_total", ""), qt.Equals, 2.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "success"), qt.Equals, 1.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "unauthorized"), qt.Equals, 2.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "error"), qt.Equals, 1.0)
+
+	families, err := reg.Gather()
+	c.Assert(err, qt.IsNil)
+	for _, f := range families {
+		if f.GetName() == "ssoauth_authenticate_duration_seconds" {
+			c.Check(f.GetMetric()[0].GetHistogram().GetSampleCount(), qt.Equals, uint64(4))
+		}
+	}
+}
+
+// counterValue returns the value of the named counter in reg. If result
+// is not empty the value of the counter with that result label is
+// returned.
+func counterValue(c *qt.C, reg prometheus.Gatherer, name, result string) float64 {
+	families, err := reg.Gather()
+	c.Assert(err, qt.IsNil)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if result == "" {
+				return m.GetCounter().GetValue()
+			}
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" && l.GetValue() == result {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	c.Fatalf("counter %s{result=%q} not found", name, result)
+	return 0
+}
+
+func TestInstrumentedAuthenticatorDefaultRegisterer(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	c.Patch(&prometheus.DefaultRegisterer, prometheus.Registerer(reg))
+	d := ssoauthtest.NewDischarger("login.example.com")
+	ssoauthmetrics.NewInstrumentedAuthenticator(ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	}), nil)
+
+	// The metrics are registered with the default registerer, and each
+	// result is reported before it occurs.
+	c.Check(counterValue(c, reg, "ssoauth_macaroon_total", ""), qt.Equals, 0.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "success"), qt.Equals, 0.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "unauthorized"), qt.Equals, 0.0)
+	c.Check(counterValue(c, reg, "ssoauth_authenticate_total", "error"), qt.Equals, 0.0)
+}
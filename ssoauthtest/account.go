@@ -0,0 +1,81 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"time"
+
+	"github.com/canonical/ssoauth"
+)
+
+// An AccountBuilder builds ssoauth.Account values for use in tests.
+type AccountBuilder struct {
+	acc ssoauth.Account
+}
+
+// NewAccount creates a new AccountBuilder. Accounts built with the
+// returned builder are verified and have a LastAuth time of the time
+// NewAccount was called, truncated to the precision supported by the
+// SSO caveats.
+func NewAccount() *AccountBuilder {
+	return &AccountBuilder{
+		acc: ssoauth.Account{
+			IsVerified: true,
+			LastAuth:   time.Now().UTC().Truncate(time.Microsecond),
+		},
+	}
+}
+
+// WithProvider sets the Provider of the built account.
+func (b *AccountBuilder) WithProvider(provider string) *AccountBuilder {
+	b.acc.Provider = provider
+	return b
+}
+
+// WithOpenID sets the OpenID of the built account.
+func (b *AccountBuilder) WithOpenID(openid string) *AccountBuilder {
+	b.acc.OpenID = openid
+	return b
+}
+
+// WithUsername sets the Username of the built account.
+func (b *AccountBuilder) WithUsername(username string) *AccountBuilder {
+	b.acc.Username = username
+	return b
+}
+
+// WithDisplayName sets the DisplayName of the built account.
+func (b *AccountBuilder) WithDisplayName(name string) *AccountBuilder {
+	b.acc.DisplayName = name
+	return b
+}
+
+// WithEmail sets the Email of the built account.
+func (b *AccountBuilder) WithEmail(email string) *AccountBuilder {
+	b.acc.Email = email
+	return b
+}
+
+// WithVerified sets the IsVerified flag of the built account.
+func (b *AccountBuilder) WithVerified(verified bool) *AccountBuilder {
+	b.acc.IsVerified = verified
+	return b
+}
+
+// WithLastAuth sets the LastAuth time of the built account. A zero
+// time means the account has no LastAuth time.
+func (b *AccountBuilder) WithLastAuth(t time.Time) *AccountBuilder {
+	b.acc.LastAuth = t
+	return b
+}
+
+// Build returns a new account with the configured values. Build panics
+// if no OpenID has been set.
+func (b *AccountBuilder) Build() *ssoauth.Account {
+	if b.acc.OpenID == "" {
+		panic("ssoauthtest: account has no OpenID")
+	}
+	acc := b.acc
+	return &acc
+}
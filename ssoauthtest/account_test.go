@@ -0,0 +1,53 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestAccountBuilder(t *testing.T) {
+	c := qt.New(t)
+
+	lastAuth := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	acc := ssoauthtest.NewAccount().
+		WithProvider("login.ubuntu.com").
+		WithOpenID("AAAAAAA").
+		WithUsername("test-user").
+		WithDisplayName("Test User").
+		WithEmail("test@example.com").
+		WithLastAuth(lastAuth).
+		Build()
+	c.Check(acc, qt.DeepEquals, &ssoauth.Account{
+		Provider:    "login.ubuntu.com",
+		OpenID:      "AAAAAAA",
+		Username:    "test-user",
+		DisplayName: "Test User",
+		Email:       "test@example.com",
+		IsVerified:  true,
+		LastAuth:    lastAuth,
+	})
+}
+
+func TestAccountBuilderDefaults(t *testing.T) {
+	c := qt.New(t)
+
+	before := time.Now().UTC().Truncate(time.Microsecond)
+	acc := ssoauthtest.NewAccount().WithOpenID("AAAAAAA").Build()
+	c.Check(acc.IsVerified, qt.IsTrue)
+	c.Check(acc.LastAuth.Before(before), qt.IsFalse)
+	c.Check(acc.LastAuth.After(time.Now()), qt.IsFalse)
+}
+
+func TestAccountBuilderNoOpenID(t *testing.T) {
+	c := qt.New(t)
+
+	c.Check(func() { ssoauthtest.NewAccount().Build() }, qt.PanicMatches, `ssoauthtest: account has no OpenID`)
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"context"
+
+	"github.com/canonical/ssoauth"
+)
+
+// A FakeACL is an implementation of the ssoauthacl.IdentityMatcher
+// interface that returns preset responses, for testing code that
+// consumes an IdentityMatcher.
+type FakeACL struct {
+	// Responses holds the identities returned from MatchIdentity
+	// for each account.
+	Responses map[*ssoauth.Account][]string
+
+	// Errors holds the errors returned from MatchIdentity for each
+	// account.
+	Errors map[*ssoauth.Account]error
+}
+
+// MatchIdentity implements ssoauthacl.IdentityMatcher.MatchIdentity. The
+// response and error for the given account are found first by pointer
+// and then, if there is no entry for the pointer, by comparing the
+// accounts' fields. The requested identities are ignored. If there is no
+// entry for the account MatchIdentity returns no identities and no
+// error.
+func (f *FakeACL) MatchIdentity(_ context.Context, acc *ssoauth.Account, _ []string) ([]string, error) {
+	ids, ok := f.Responses[acc]
+	if !ok {
+		for k, v := range f.Responses {
+			if ssoauth.AccountsEqual(k, acc) {
+				ids = v
+				break
+			}
+		}
+	}
+	err, ok := f.Errors[acc]
+	if !ok {
+		for k, v := range f.Errors {
+			if ssoauth.AccountsEqual(k, acc) {
+				err = v
+				break
+			}
+		}
+	}
+	return append([]string(nil), ids...), err
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestFakeACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	lastAuth := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	alice := &ssoauth.Account{OpenID: "AAAAAAA", Username: "alice", LastAuth: lastAuth}
+	bob := &ssoauth.Account{OpenID: "BBBBBBB", Username: "bob"}
+	testErr := errgo.New("test error")
+	acl := &ssoauthtest.FakeACL{
+		Responses: map[*ssoauth.Account][]string{
+			alice: {"https://launchpad.net/~test1"},
+			bob:   {"https://launchpad.net/~test2"},
+		},
+		Errors: map[*ssoauth.Account]error{
+			bob: testErr,
+		},
+	}
+
+	ids, err := acl.MatchIdentity(ctx, alice, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test1"})
+
+	// An equal account at a different address matches, even if the
+	// time has a different location.
+	ids, err = acl.MatchIdentity(ctx, &ssoauth.Account{
+		OpenID:   "AAAAAAA",
+		Username: "alice",
+		LastAuth: lastAuth.In(time.FixedZone("test", 3600)),
+	}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test1"})
+
+	ids, err = acl.MatchIdentity(ctx, bob, nil)
+	c.Check(err, qt.Equals, testErr)
+	c.Check(ids, qt.DeepEquals, []string{"https://launchpad.net/~test2"})
+
+	ids, err = acl.MatchIdentity(ctx, &ssoauth.Account{OpenID: "CCCCCCC"}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.IsNil)
+
+	ids, err = new(ssoauthtest.FakeACL).MatchIdentity(ctx, alice, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(ids, qt.IsNil)
+}
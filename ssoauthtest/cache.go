@@ -0,0 +1,60 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import "sync"
+
+// A RecordingCache is an in-memory implementation of the ssoauthacl.Cache
+// interface that records the keys it is called with, so that tests can
+// check how the cache is used. The zero value is ready to use. The
+// methods are safe for concurrent use, but the fields must not be
+// accessed while the cache is in use.
+type RecordingCache struct {
+	mu sync.Mutex
+
+	// AddCalls holds the key of every call to Add, in order.
+	AddCalls []string
+
+	// GetCalls holds the key of every call to Get, in order.
+	GetCalls []string
+
+	// GetResults holds the values returned from Get. It may be set
+	// before the cache is used to preset responses. Values stored
+	// with Add are also added to GetResults.
+	GetResults map[string][]string
+}
+
+// Add implements ssoauthacl.Cache.Add.
+func (c *RecordingCache) Add(key string, value []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.AddCalls = append(c.AddCalls, key)
+	if c.GetResults == nil {
+		c.GetResults = make(map[string][]string)
+	}
+	c.GetResults[key] = value
+}
+
+// Get implements ssoauthacl.Cache.Get.
+func (c *RecordingCache) Get(key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.GetCalls = append(c.GetCalls, key)
+	v, ok := c.GetResults[key]
+	return v, ok
+}
+
+// Invalidate implements ssoauthacl.Cache.Invalidate.
+func (c *RecordingCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.GetResults, key)
+}
+
+// Flush implements ssoauthacl.Cache.Flush.
+func (c *RecordingCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.GetResults = nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestRecordingCache(t *testing.T) {
+	c := qt.New(t)
+
+	cache := &ssoauthtest.RecordingCache{
+		GetResults: map[string][]string{
+			"preset": {"a"},
+		},
+	}
+	v, ok := cache.Get("preset")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"a"})
+	_, ok = cache.Get("missing")
+	c.Check(ok, qt.IsFalse)
+
+	cache.Add("added", []string{"b"})
+	v, ok = cache.Get("added")
+	c.Check(ok, qt.IsTrue)
+	c.Check(v, qt.DeepEquals, []string{"b"})
+
+	cache.Invalidate("added")
+	_, ok = cache.Get("added")
+	c.Check(ok, qt.IsFalse)
+
+	cache.Flush()
+	_, ok = cache.Get("preset")
+	c.Check(ok, qt.IsFalse)
+
+	c.Check(cache.AddCalls, qt.DeepEquals, []string{"added"})
+	c.Check(cache.GetCalls, qt.DeepEquals, []string{"preset", "missing", "added", "added", "preset"})
+}
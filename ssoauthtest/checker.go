@@ -0,0 +1,118 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+)
+
+// AccountEquals is a quicktest checker that checks that two accounts
+// are equal according to ssoauth.AccountsEqual. Both the obtained and
+// expected values may be either an ssoauth.Account or a pointer to one.
+// On failure the differing fields are reported.
+//
+// For instance:
+//
+//	c.Assert(acc, ssoauthtest.AccountEquals, &ssoauth.Account{OpenID: "AAAAAAA"})
+var AccountEquals qt.Checker = accountChecker{}
+
+type accountChecker struct{}
+
+// ArgNames implements qt.Checker.ArgNames.
+func (accountChecker) ArgNames() []string {
+	return []string{"got", "want"}
+}
+
+// Check implements qt.Checker.Check.
+func (accountChecker) Check(got interface{}, args []interface{}, note func(key string, value interface{})) error {
+	gotAcc, ok := toAccount(got)
+	if !ok {
+		return qt.BadCheckf("first argument is not an account")
+	}
+	wantAcc, ok := toAccount(args[0])
+	if !ok {
+		return qt.BadCheckf("second argument is not an account")
+	}
+	if ssoauth.AccountsEqual(gotAcc, wantAcc) {
+		return nil
+	}
+	if gotAcc != nil && wantAcc != nil {
+		note("diff", qt.Unquoted(accountDiff(gotAcc, wantAcc)))
+	}
+	return errors.New("accounts are not equal")
+}
+
+func toAccount(v interface{}) (*ssoauth.Account, bool) {
+	switch v := v.(type) {
+	case *ssoauth.Account:
+		return v, true
+	case ssoauth.Account:
+		return &v, true
+	}
+	return nil, false
+}
+
+// accountDiff describes the fields that differ between got and want.
+func accountDiff(got, want *ssoauth.Account) string {
+	var lines []string
+	field := func(name string, equal bool, got, want interface{}) {
+		if !equal {
+			lines = append(lines, fmt.Sprintf("%s:\n  got:  %#v\n  want: %#v", name, got, want))
+		}
+	}
+	field("Provider", got.Provider == want.Provider, got.Provider, want.Provider)
+	field("OpenID", got.OpenID == want.OpenID, got.OpenID, want.OpenID)
+	field("Username", got.Username == want.Username, got.Username, want.Username)
+	field("DisplayName", got.DisplayName == want.DisplayName, got.DisplayName, want.DisplayName)
+	field("Email", got.Email == want.Email, got.Email, want.Email)
+	field("IsVerified", got.IsVerified == want.IsVerified, got.IsVerified, want.IsVerified)
+	field("LastAuth", got.LastAuth.Equal(want.LastAuth), got.LastAuth.String(), want.LastAuth.String())
+	field("Scopes", scopesEqual(got.Scopes, want.Scopes), got.Scopes, want.Scopes)
+	keys := make(map[string]bool)
+	for k := range got.Extra {
+		keys[k] = true
+	}
+	for k := range want.Extra {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		g, gok := got.Extra[k]
+		w, wok := want.Extra[k]
+		field(fmt.Sprintf("Extra[%q]", k), g == w && gok == wok, optional(g, gok), optional(w, wok))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// optional returns s if ok is true and nil otherwise, so that missing
+// values are distinguished from empty ones in a diff.
+func optional(s string, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return s
+}
+
+func scopesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestAccountEquals(t *testing.T) {
+	c := qt.New(t)
+
+	lastAuth := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	acc := &ssoauth.Account{
+		OpenID:   "AAAAAAA",
+		Username: "bob",
+		LastAuth: lastAuth,
+	}
+	c.Check(acc, ssoauthtest.AccountEquals, &ssoauth.Account{
+		OpenID:   "AAAAAAA",
+		Username: "bob",
+		LastAuth: lastAuth.Local(),
+	})
+	c.Check(*acc, ssoauthtest.AccountEquals, *acc)
+
+	notes := make(map[string]interface{})
+	err := ssoauthtest.AccountEquals.Check(acc, []interface{}{&ssoauth.Account{
+		OpenID:   "AAAAAAA",
+		Username: "alice",
+		LastAuth: lastAuth,
+		Extra:    map[string]string{"2fa": "true"},
+	}}, func(key string, value interface{}) {
+		notes[key] = value
+	})
+	c.Check(err, qt.ErrorMatches, `accounts are not equal`)
+	c.Check(notes["diff"], qt.Equals, qt.Unquoted(`Username:
+  got:  "bob"
+  want: "alice"
+Extra["2fa"]:
+  got:  <nil>
+  want: "true"`))
+
+	err = ssoauthtest.AccountEquals.Check("bob", []interface{}{acc}, nil)
+	c.Check(qt.IsBadCheck(err), qt.IsTrue)
+}
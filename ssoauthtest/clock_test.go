@@ -0,0 +1,31 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestFakeClock(t *testing.T) {
+	c := qt.New(t)
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := ssoauthtest.NewFakeClock(t0)
+	c.Check(clock.Now(), qt.Equals, t0)
+
+	clock.Advance(time.Minute)
+	c.Check(clock.Now(), qt.Equals, t0.Add(time.Minute))
+
+	clock.Advance(-time.Hour)
+	c.Check(clock.Now(), qt.Equals, t0.Add(-59*time.Minute))
+
+	t1 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock.Set(t1)
+	c.Check(clock.Now(), qt.Equals, t1)
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth"
+)
+
+// A DischargeRequest is the body of a request to the handler created by
+// Discharger.Handler.
+type DischargeRequest struct {
+	// CaveatID contains the base64 encoded ID of the caveat to
+	// discharge.
+	CaveatID string `json:"caveat_id"`
+}
+
+// A DischargeResponse is the body of a successful response from the
+// handler created by Discharger.Handler.
+type DischargeResponse struct {
+	// DischargeMacaroon contains the discharge macaroon, encoded in
+	// the binary format as URL-safe base64 without padding.
+	DischargeMacaroon string `json:"discharge_macaroon"`
+}
+
+// Handler creates an http.Handler that acts as an SSO discharge
+// endpoint. The handler accepts POST requests with a JSON encoded
+// DischargeRequest body and responds with a JSON encoded
+// DischargeResponse. The discharge macaroons are created as if by
+// calling d.Discharge with the given acc, expires and validSince.
+func (d *Discharger) Handler(acc *ssoauth.Account, expires, validSince time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, errgo.Newf("unsupported method %q", req.Method))
+			return
+		}
+		var dreq DischargeRequest
+		if err := json.NewDecoder(req.Body).Decode(&dreq); err != nil {
+			writeError(w, http.StatusBadRequest, errgo.Notef(err, "cannot parse request"))
+			return
+		}
+		caveatID, err := decodeBase64(dreq.CaveatID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errgo.Notef(err, "cannot parse caveat_id"))
+			return
+		}
+		m, err := d.Discharge(caveatID, acc, expires, validSince)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errgo.Notef(err, "cannot discharge caveat"))
+			return
+		}
+		b, err := m.MarshalBinary()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DischargeResponse{
+			DischargeMacaroon: base64.RawURLEncoding.EncodeToString(b),
+		})
+	})
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	enc := base64.RawURLEncoding
+	if strings.ContainsAny(s, "+/") {
+		enc = base64.RawStdEncoding
+	}
+	return enc.DecodeString(s)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{
+		Message: err.Error(),
+	})
+}
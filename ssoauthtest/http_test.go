@@ -0,0 +1,102 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestDischargerHandler(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewTLSServer(mux)
+	c.Cleanup(srv.Close)
+
+	d := ssoauthtest.NewDischarger(srv.URL)
+	expectAccount := &ssoauth.Account{
+		Provider: srv.URL,
+		OpenID:   "AAAAAAA",
+		Username: "test-user",
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mux.Handle("/discharge", d.Handler(expectAccount, now.Add(time.Minute), time.Time{}))
+
+	clock := ssoauthtest.NewFakeClock(now)
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  srv.URL,
+	}, ssoauth.WithClock(clock))
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	caveatID, err := ssoauthtest.GetCaveatID(d, m.M())
+	c.Assert(err, qt.IsNil)
+	body, err := json.Marshal(ssoauthtest.DischargeRequest{
+		CaveatID: base64.StdEncoding.EncodeToString(caveatID),
+	})
+	c.Assert(err, qt.IsNil)
+	resp, err := srv.Client().Post(srv.URL+"/discharge", "application/json", bytes.NewReader(body))
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	var dresp ssoauthtest.DischargeResponse
+	err = json.NewDecoder(resp.Body).Decode(&dresp)
+	c.Assert(err, qt.IsNil)
+	b, err := base64.RawURLEncoding.DecodeString(dresp.DischargeMacaroon)
+	c.Assert(err, qt.IsNil)
+	var discharge macaroon.Macaroon
+	err = discharge.UnmarshalBinary(b)
+	c.Assert(err, qt.IsNil)
+	discharge.Bind(m.M().Signature())
+
+	acc, err := a.Authenticate(ctx, macaroon.Slice{m.M(), &discharge})
+	c.Assert(err, qt.IsNil)
+	c.Check(acc, qt.DeepEquals, expectAccount)
+
+	clock.Advance(time.Minute)
+	_, err = a.Authenticate(ctx, macaroon.Slice{m.M(), &discharge})
+	c.Check(errors.Is(err, ssoauth.ErrExpired), qt.IsTrue)
+}
+
+func TestDischargerHandlerErrors(t *testing.T) {
+	c := qt.New(t)
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	d.PublicKey()
+	h := d.Handler(nil, time.Time{}, time.Time{})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	c.Check(rr.Code, qt.Equals, http.StatusMethodNotAllowed)
+	c.Check(rr.Body.String(), qt.JSONEquals, map[string]string{"message": `unsupported method "GET"`})
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/", bytes.NewReader([]byte("{"))))
+	c.Check(rr.Code, qt.Equals, http.StatusBadRequest)
+	c.Check(rr.Body.String(), qt.JSONEquals, map[string]string{"message": `cannot parse request: unexpected EOF`})
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"caveat_id":"e30"}`))))
+	c.Check(rr.Code, qt.Equals, http.StatusBadRequest)
+	c.Check(rr.Body.String(), qt.JSONEquals, map[string]string{"message": `cannot discharge caveat: unsupported caveat version 0`})
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+)
+
+// GenerateTestKey generates a new RSA key of the given size. It panics
+// if the key cannot be generated.
+func GenerateTestKey(bits int) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// TestKey returns a 2048 bit RSA key that is generated once per
+// process. It is suitable for tests that do not need a unique key.
+func TestKey() *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		testKey = GenerateTestKey(keyBits)
+	})
+	return testKey
+}
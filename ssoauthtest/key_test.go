@@ -0,0 +1,57 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestGenerateTestKey(t *testing.T) {
+	c := qt.New(t)
+
+	key := ssoauthtest.GenerateTestKey(1024)
+	c.Check(key.N.BitLen(), qt.Equals, 1024)
+	c.Check(ssoauthtest.GenerateTestKey(1024).Equal(key), qt.IsFalse)
+}
+
+func TestTestKey(t *testing.T) {
+	c := qt.New(t)
+
+	key := ssoauthtest.TestKey()
+	c.Check(key.N.BitLen(), qt.Equals, 2048)
+	c.Check(ssoauthtest.TestKey(), qt.Equals, key)
+}
+
+func TestDischargerWithKey(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	key := ssoauthtest.TestKey()
+	d := ssoauthtest.NewDischarger("login.example.com", ssoauthtest.WithKey(key))
+	c.Check(d.PublicKey().Equal(&key.PublicKey), qt.IsTrue)
+
+	// A macaroon for a discharger using the same key can be
+	// discharged by both.
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: &key.PublicKey,
+		Location:  d.Location(),
+	})
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	d2 := ssoauthtest.NewDischarger("login.example.com", ssoauthtest.WithKey(key))
+	for _, d := range []*ssoauthtest.Discharger{d, d2} {
+		ms := ssoauthtest.MustDischarge(d, m.M(), nil, time.Time{}, time.Time{})
+		_, err = a.Authenticate(ctx, ms)
+		c.Check(err, qt.IsNil)
+	}
+}
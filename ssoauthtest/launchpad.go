@@ -0,0 +1,137 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+// A MockLaunchpadServer is a fake of the parts of the launchpad API used
+// to find the teams of an account. It serves the "/people" endpoint,
+// which finds a user by OpenID identifier, and the
+// "/{username}/super_teams" endpoint, which lists a user's teams. The
+// zero value is ready to use.
+type MockLaunchpadServer struct {
+	mu      sync.Mutex
+	openIDs map[string]string
+	teams   map[string][]string
+	calls   map[string]int
+}
+
+// AddUser adds a launchpad user with the given OpenID identifier,
+// username and team URLs. Any existing user with the same OpenID or
+// username is replaced.
+func (s *MockLaunchpadServer) AddUser(openID, username string, teams []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.openIDs == nil {
+		s.openIDs = make(map[string]string)
+		s.teams = make(map[string][]string)
+	}
+	s.openIDs[openID] = username
+	s.teams[username] = append([]string(nil), teams...)
+}
+
+// Start starts an HTTP server serving the fake launchpad API. The caller
+// should close the server when finished with it. The server's URL may be
+// used as a launchpad API base.
+func (s *MockLaunchpadServer) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// CallCount returns the number of requests that have been made to the
+// given endpoint, which is the path of the request such as "/people" or
+// "/test/super_teams".
+func (s *MockLaunchpadServer) CallCount(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// ServeHTTP implements http.Handler, so that tests needing to intercept
+// requests can wrap the MockLaunchpadServer in their own handler.
+func (s *MockLaunchpadServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[req.URL.Path]++
+	s.mu.Unlock()
+
+	if req.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errgo.Newf("unsupported method %q", req.Method))
+		return
+	}
+	switch {
+	case req.URL.Path == "/":
+		writeJSON(w, struct{}{})
+	case req.URL.Path == "/people":
+		s.servePeople(w, req)
+	case strings.HasSuffix(req.URL.Path, "/super_teams"):
+		s.serveSuperTeams(w, req)
+	default:
+		writeError(w, http.StatusNotFound, errgo.Newf("%s not found", req.URL.Path))
+	}
+}
+
+func (s *MockLaunchpadServer) servePeople(w http.ResponseWriter, req *http.Request) {
+	if op := req.FormValue("ws.op"); op != "getByOpenIDIdentifier" {
+		writeError(w, http.StatusBadRequest, errgo.Newf("unsupported operation %q", op))
+		return
+	}
+	s.mu.Lock()
+	username, ok := s.openIDs[req.FormValue("identifier")]
+	s.mu.Unlock()
+	if !ok {
+		// Launchpad responds with null when there is no
+		// matching user.
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("null"))
+		return
+	}
+	writeJSON(w, struct {
+		Name                     string `json:"name"`
+		SuperTeamsCollectionLink string `json:"super_teams_collection_link"`
+	}{
+		Name:                     username,
+		SuperTeamsCollectionLink: "http://" + req.Host + "/" + username + "/super_teams",
+	})
+}
+
+func (s *MockLaunchpadServer) serveSuperTeams(w http.ResponseWriter, req *http.Request) {
+	username := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/"), "/super_teams")
+	s.mu.Lock()
+	teams, ok := s.teams[username]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errgo.Newf("no user %q", username))
+		return
+	}
+	type entry struct {
+		WebLink string `json:"web_link"`
+	}
+	entries := make([]entry, len(teams))
+	for i, t := range teams {
+		entries[i].WebLink = t
+	}
+	writeJSON(w, struct {
+		TotalSize int     `json:"total_size"`
+		Start     int     `json:"start"`
+		Entries   []entry `json:"entries"`
+	}{
+		TotalSize: len(entries),
+		Entries:   entries,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
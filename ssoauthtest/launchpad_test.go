@@ -0,0 +1,87 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestMockLaunchpadServer(t *testing.T) {
+	c := qt.New(t)
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	lp.AddUser("https://login.launchpad.net/+id/AAAAAAA", "test", []string{
+		"https://launchpad.net/~test1",
+		"https://launchpad.net/~test2",
+	})
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	var person struct {
+		Name                     string `json:"name"`
+		SuperTeamsCollectionLink string `json:"super_teams_collection_link"`
+	}
+	getJSON(c, srv.URL+"/people?ws.op=getByOpenIDIdentifier&identifier="+url.QueryEscape("https://login.launchpad.net/+id/AAAAAAA"), &person)
+	c.Check(person.Name, qt.Equals, "test")
+	c.Check(person.SuperTeamsCollectionLink, qt.Equals, srv.URL+"/test/super_teams")
+
+	var teams struct {
+		TotalSize int `json:"total_size"`
+		Entries   []struct {
+			WebLink string `json:"web_link"`
+		} `json:"entries"`
+	}
+	getJSON(c, person.SuperTeamsCollectionLink, &teams)
+	c.Check(teams.TotalSize, qt.Equals, 2)
+	c.Assert(teams.Entries, qt.HasLen, 2)
+	c.Check(teams.Entries[0].WebLink, qt.Equals, "https://launchpad.net/~test1")
+	c.Check(teams.Entries[1].WebLink, qt.Equals, "https://launchpad.net/~test2")
+
+	c.Check(lp.CallCount("/people"), qt.Equals, 1)
+	c.Check(lp.CallCount("/test/super_teams"), qt.Equals, 1)
+	c.Check(lp.CallCount("/other/super_teams"), qt.Equals, 0)
+}
+
+func TestMockLaunchpadServerUnknownUser(t *testing.T) {
+	c := qt.New(t)
+
+	lp := new(ssoauthtest.MockLaunchpadServer)
+	srv := lp.Start()
+	c.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/people?ws.op=getByOpenIDIdentifier&identifier=unknown")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Check(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Check(string(body), qt.Equals, "null")
+
+	resp, err = http.Get(srv.URL + "/unknown/super_teams")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, qt.Equals, http.StatusNotFound)
+
+	resp, err = http.Get(srv.URL + "/people?ws.op=getByEmail")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+}
+
+func getJSON(c *qt.C, url string, v interface{}) {
+	resp, err := http.Get(url)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	err = json.NewDecoder(resp.Body).Decode(v)
+	c.Assert(err, qt.IsNil)
+}
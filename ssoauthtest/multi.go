@@ -0,0 +1,53 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"crypto/rsa"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+)
+
+// A MultiDischarger holds a Discharger for each of a number of SSO
+// locations, keyed by location.
+type MultiDischarger map[string]*Discharger
+
+// NewMultiDischarger creates a MultiDischarger containing a new
+// Discharger for each of the given locations.
+func NewMultiDischarger(locations ...string) MultiDischarger {
+	md := make(MultiDischarger, len(locations))
+	for _, loc := range locations {
+		md[loc] = NewDischarger(loc)
+	}
+	return md
+}
+
+// Discharge creates a discharge macaroon for the given caveatID using
+// the Discharger for the given location. See Discharger.Discharge for
+// details.
+func (md MultiDischarger) Discharge(location string, caveatID []byte, acc *ssoauth.Account, expires, validSince time.Time) (*macaroon.Macaroon, error) {
+	d, ok := md[location]
+	if !ok {
+		return nil, errgo.Newf("no discharger for location %q", location)
+	}
+	m, err := d.Discharge(caveatID, acc, expires, validSince)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return m, nil
+}
+
+// PublicKeys returns the public key of each Discharger, keyed by
+// location.
+func (md MultiDischarger) PublicKeys() map[string]*rsa.PublicKey {
+	keys := make(map[string]*rsa.PublicKey, len(md))
+	for loc, d := range md {
+		keys[loc] = d.PublicKey()
+	}
+	return keys
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestMultiDischarger(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	md := ssoauthtest.NewMultiDischarger("login1.example.com", "login2.example.com")
+	keys := md.PublicKeys()
+	c.Assert(keys, qt.HasLen, 2)
+
+	for _, loc := range []string{"login1.example.com", "login2.example.com"} {
+		a := ssoauth.New(ssoauth.Params{
+			Oven:      bakery.NewOven(bakery.OvenParams{}),
+			PublicKey: keys[loc],
+			Location:  loc,
+		})
+		m, err := a.Macaroon(ctx)
+		c.Assert(err, qt.IsNil)
+		caveatID := ssoauthtest.MustGetCaveatID(md[loc], m.M())
+
+		discharge, err := md.Discharge(loc, caveatID, &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+		c.Assert(err, qt.IsNil)
+		discharge.Bind(m.M().Signature())
+		acc, err := a.Authenticate(ctx, macaroon.Slice{m.M(), discharge})
+		c.Assert(err, qt.IsNil)
+		c.Check(acc.Provider, qt.Equals, loc)
+
+		_, err = md.Discharge("login3.example.com", caveatID, nil, time.Time{}, time.Time{})
+		c.Check(err, qt.ErrorMatches, `no discharger for location "login3.example.com"`)
+	}
+}
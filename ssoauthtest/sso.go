@@ -5,15 +5,23 @@
 package ssoauthtest
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
 
+	"golang.org/x/crypto/hkdf"
 	errgo "gopkg.in/errgo.v1"
 	macaroon "gopkg.in/macaroon.v2"
 
@@ -27,46 +35,161 @@ const (
 	keyBits         = 2048
 )
 
+// A Discharger discharges third-party caveats in the same way as an SSO
+// server. The zero value of a Discharger uses the location
+// "login.example.com".
 type Discharger struct {
-	mu  sync.Mutex
-	key *rsa.PrivateKey
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	ecdhKey  *ecdh.PrivateKey
+	location string
+
+	dischargeCount atomic.Int64
+	publicKeyCount atomic.Int64
+}
+
+// An Option configures a Discharger created with NewDischarger.
+type Option func(*Discharger)
+
+// WithLocation configures the Discharger to use the given location,
+// overriding the location passed to NewDischarger.
+func WithLocation(loc string) Option {
+	return func(d *Discharger) {
+		d.location = loc
+	}
+}
+
+// WithKey configures the Discharger to use the given private key rather
+// than generating one.
+func WithKey(key *rsa.PrivateKey) Option {
+	return func(d *Discharger) {
+		d.key = key
+	}
+}
+
+// WithECDHKey configures the Discharger to use the given X25519 private
+// key, for discharging caveats added with ssoauth.AddThirdPartyCaveatECDH,
+// rather than generating one.
+func WithECDHKey(key *ecdh.PrivateKey) Option {
+	return func(d *Discharger) {
+		d.ecdhKey = key
+	}
+}
+
+// NewDischarger creates a new Discharger using the given location.
+func NewDischarger(location string, opts ...Option) *Discharger {
+	d := &Discharger{
+		location: location,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // Get the location of this discharger.
 func (d *Discharger) Location() string {
-	return defaultLocation
+	if d.location == "" {
+		return defaultLocation
+	}
+	return d.location
 }
 
 // Get the public key for this discharger. The key is generated the first
 // time it is requested.
 func (d *Discharger) PublicKey() *rsa.PublicKey {
+	d.publicKeyCount.Add(1)
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	if d.key == nil {
-		var err error
-		d.key, err = rsa.GenerateKey(rand.Reader, keyBits)
+		d.key = GenerateTestKey(keyBits)
+	}
+	return d.key.Public().(*rsa.PublicKey)
+}
+
+// ECDHPublicKey gets the X25519 public key for this discharger, for use
+// with ssoauth.AddThirdPartyCaveatECDH. The key is generated the first
+// time it is requested.
+func (d *Discharger) ECDHPublicKey() *ecdh.PublicKey {
+	d.publicKeyCount.Add(1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ecdhKey == nil {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
 		if err != nil {
 			panic(err)
 		}
+		d.ecdhKey = key
 	}
-	return d.key.Public().(*rsa.PublicKey)
+	return d.ecdhKey.PublicKey()
+}
+
+// Reset discards the keys for this discharger. New keys are generated
+// the next time PublicKey or ECDHPublicKey is called. Caveats encrypted
+// with the old keys can no longer be discharged.
+func (d *Discharger) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.key = nil
+	d.ecdhKey = nil
 }
 
 // Discharge creates a discharge macaroon for the given caveatID. If acc,
 // expires or validSince are non-zero then matching caveats will be added
-// to the discharge macaroon to represent the given values.
+// to the discharge macaroon to represent the given values. The
+// Discharger performs no time checks of its own, to test time dependent
+// behaviour derive these times from a FakeClock that is also given to
+// the ssoauth.Authenticator.
 func (d *Discharger) Discharge(caveatID []byte, acc *ssoauth.Account, expires, validSince time.Time) (*macaroon.Macaroon, error) {
-	var cid struct {
-		Secret  string `json:"secret"`
-		Version int    `json:"version"`
+	m, err := d.newDischarge(caveatID)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if acc != nil {
+		m.AddFirstPartyCaveat(d.accountCaveat(acc))
+	}
+	if !expires.IsZero() {
+		ssoauth.AddExpiresCaveat(m, d.Location(), expires)
+	}
+	if !validSince.IsZero() {
+		ssoauth.AddValidSinceCaveat(m, d.Location(), validSince)
+	}
+	if acc != nil && !acc.LastAuth.IsZero() {
+		ssoauth.AddLastAuthCaveat(m, d.Location(), acc.LastAuth)
 	}
 
-	if err := json.Unmarshal([]byte(caveatID), &cid); err != nil {
+	return m, nil
+}
+
+// DischargeWithCaveats creates a discharge macaroon for the given
+// caveatID in the same way as Discharge, but adds the given first-party
+// caveats verbatim rather than encoding them from an account and times.
+// This is useful for testing how malformed discharges are handled.
+func (d *Discharger) DischargeWithCaveats(caveatID []byte, rawCaveats [][]byte) (*macaroon.Macaroon, error) {
+	m, err := d.newDischarge(caveatID)
+	if err != nil {
 		return nil, errgo.Mask(err)
 	}
+	for _, cav := range rawCaveats {
+		if err := m.AddFirstPartyCaveat(cav); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return m, nil
+}
 
-	if cid.Version != 1 {
-		return nil, errgo.Newf("unsupported caveat version %d", cid.Version)
+// newDischarge creates a discharge macaroon, with no caveats, for the
+// given caveatID.
+func (d *Discharger) newDischarge(caveatID []byte) (*macaroon.Macaroon, error) {
+	d.dischargeCount.Add(1)
+	var cid struct {
+		Secret    string `json:"secret"`
+		PublicKey string `json:"public_key"`
+		Version   int    `json:"version"`
+	}
+
+	if err := json.Unmarshal([]byte(caveatID), &cid); err != nil {
+		return nil, errgo.Mask(err)
 	}
 
 	secret, err := base64.StdEncoding.DecodeString(cid.Secret)
@@ -74,7 +197,15 @@ func (d *Discharger) Discharge(caveatID []byte, acc *ssoauth.Account, expires, v
 		return nil, errgo.Mask(err)
 	}
 
-	rootKey, err := d.decrypt(secret)
+	var rootKey []byte
+	switch cid.Version {
+	case 1:
+		rootKey, err = d.decrypt(secret)
+	case 2:
+		rootKey, err = d.decryptECDH(cid.PublicKey, secret)
+	default:
+		return nil, errgo.Newf("unsupported caveat version %d", cid.Version)
+	}
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -83,20 +214,46 @@ func (d *Discharger) Discharge(caveatID []byte, acc *ssoauth.Account, expires, v
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
-	if acc != nil {
-		m.AddFirstPartyCaveat(d.accountCaveat(acc))
-	}
-	if !expires.IsZero() {
-		m.AddFirstPartyCaveat(d.timeCaveat("expires", expires))
-	}
-	if !validSince.IsZero() {
-		m.AddFirstPartyCaveat(d.timeCaveat("valid_since", validSince))
-	}
-	if acc != nil && !acc.LastAuth.IsZero() {
-		m.AddFirstPartyCaveat(d.timeCaveat("last_auth", acc.LastAuth))
+	return m, nil
+}
+
+// DischargeCount returns the number of times Discharge or
+// DischargeWithCaveats has been called since the discharger was created
+// or ResetCounters was last called.
+func (d *Discharger) DischargeCount() int {
+	return int(d.dischargeCount.Load())
+}
+
+// PublicKeyCount returns the number of times PublicKey or ECDHPublicKey
+// has been called since the discharger was created or ResetCounters was
+// last called.
+func (d *Discharger) PublicKeyCount() int {
+	return int(d.publicKeyCount.Load())
+}
+
+// ResetCounters sets the values returned by DischargeCount and
+// PublicKeyCount to zero.
+func (d *Discharger) ResetCounters() {
+	d.dischargeCount.Store(0)
+	d.publicKeyCount.Store(0)
+}
+
+// ExpectCalls fails the test if DischargeCount does not return count.
+func (d *Discharger) ExpectCalls(t testing.TB, count int) {
+	t.Helper()
+	if n := d.DischargeCount(); n != count {
+		t.Errorf("discharger called %d times, want %d", n, count)
 	}
+}
 
-	return m, nil
+// AssertNoExtraCalls fails the test if Discharge or DischargeWithCaveats
+// has been called since the discharger was created or ResetCounters was
+// last called.
+func (d *Discharger) AssertNoExtraCalls(t testing.TB) {
+	t.Helper()
+	if n := d.DischargeCount(); n > 0 {
+		t.Errorf("discharger called %d times since counters were reset, want 0", n)
+	}
 }
 
 func (d *Discharger) decrypt(secret []byte) ([]byte, error) {
@@ -112,16 +269,56 @@ func (d *Discharger) decrypt(secret []byte) ([]byte, error) {
 	return rootKey, nil
 }
 
+// decryptECDH decrypts the secret in a version 2 caveat ID, which was
+// encrypted using the given base64 encoded ephemeral public key.
+func (d *Discharger) decryptECDH(publicKey string, secret []byte) ([]byte, error) {
+	d.mu.Lock()
+	key := d.ecdhKey
+	d.mu.Unlock()
+	if key == nil {
+		return nil, errgo.New("cannot decrypt secret")
+	}
+	b, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	salt := append(append([]byte(nil), ephemeral.Bytes()...), key.PublicKey().Bytes()...)
+	wrappingKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("ssoauth third-party caveat v2")), wrappingKey); err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	block, err := aes.NewCipher(wrappingKey)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	if len(secret) < aead.NonceSize() {
+		return nil, errgo.New("cannot decrypt secret: secret too short")
+	}
+	rootKey, err := aead.Open(nil, secret[:aead.NonceSize()], secret[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt secret")
+	}
+	return rootKey, nil
+}
+
 func (d *Discharger) accountCaveat(acc *ssoauth.Account) []byte {
 	buf, err := json.Marshal(acc)
 	if err != nil {
 		panic(err)
 	}
-	return []byte(fmt.Sprintf("%s|account|%s", d.Location(), base64.StdEncoding.EncodeToString(buf)))
-}
-
-func (d *Discharger) timeCaveat(name string, t time.Time) []byte {
-	return []byte(fmt.Sprintf("%s|%s|%s", d.Location(), name, t.Format(TimeFormat)))
+	return []byte(ssoauth.FormatCaveat(d.Location(), "account", base64.StdEncoding.EncodeToString(buf)))
 }
 
 // GetCaveatID gets the caveat ID of the third-party caveat in the given
@@ -164,3 +361,23 @@ func Discharge(d *Discharger, root *macaroon.Macaroon, acc *ssoauth.Account, exp
 	discharge.Bind(root.Signature())
 	return macaroon.Slice{root, discharge}, nil
 }
+
+// MustGetCaveatID is like GetCaveatID except that it panics if there is
+// an error. It is intended for use in test setup code only.
+func MustGetCaveatID(d *Discharger, m *macaroon.Macaroon) []byte {
+	caveatID, err := GetCaveatID(d, m)
+	if err != nil {
+		panic(fmt.Sprintf("ssoauthtest: cannot get caveat ID: %v", err))
+	}
+	return caveatID
+}
+
+// MustDischarge is like Discharge except that it panics if there is an
+// error. It is intended for use in test setup code only.
+func MustDischarge(d *Discharger, root *macaroon.Macaroon, acc *ssoauth.Account, expires, validSince time.Time) macaroon.Slice {
+	ms, err := Discharge(d, root, acc, expires, validSince)
+	if err != nil {
+		panic(fmt.Sprintf("ssoauthtest: cannot discharge macaroon: %v", err))
+	}
+	return ms
+}
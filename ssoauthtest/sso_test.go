@@ -0,0 +1,187 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/ssoauth"
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestDischargerLocation(t *testing.T) {
+	c := qt.New(t)
+
+	c.Check(new(ssoauthtest.Discharger).Location(), qt.Equals, "login.example.com")
+	c.Check(ssoauthtest.NewDischarger("login.test").Location(), qt.Equals, "login.test")
+	c.Check(ssoauthtest.NewDischarger("login.test", ssoauthtest.WithLocation("login2.test")).Location(), qt.Equals, "login2.test")
+}
+
+func TestDischargersWithDifferentLocations(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d1 := ssoauthtest.NewDischarger("login1.example.com")
+	d2 := ssoauthtest.NewDischarger("login2.example.com")
+
+	for _, d := range []*ssoauthtest.Discharger{d1, d2} {
+		a := ssoauth.New(ssoauth.Params{
+			Oven:      bakery.NewOven(bakery.OvenParams{}),
+			PublicKey: d.PublicKey(),
+			Location:  d.Location(),
+		})
+		m, err := a.Macaroon(ctx)
+		c.Assert(err, qt.IsNil)
+
+		ms, err := ssoauthtest.Discharge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Now().Add(time.Minute), time.Time{})
+		c.Assert(err, qt.IsNil)
+		acc, err := a.Authenticate(ctx, ms)
+		c.Assert(err, qt.IsNil)
+		c.Check(acc.Provider, qt.Equals, d.Location())
+	}
+
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d1.PublicKey(),
+		Location:  d1.Location(),
+	})
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = ssoauthtest.Discharge(d2, m.M(), nil, time.Time{}, time.Time{})
+	c.Check(err, qt.ErrorMatches, `no third party caveat addressed to discharger`)
+}
+
+func TestMustDischarge(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	})
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+
+	c.Check(ssoauthtest.MustGetCaveatID(d, m.M()), qt.Not(qt.HasLen), 0)
+	ms := ssoauthtest.MustDischarge(d, m.M(), &ssoauth.Account{OpenID: "AAAAAAA"}, time.Time{}, time.Time{})
+	_, err = a.Authenticate(ctx, ms)
+	c.Check(err, qt.IsNil)
+
+	other := ssoauthtest.NewDischarger("login2.example.com")
+	c.Check(func() {
+		ssoauthtest.MustGetCaveatID(other, m.M())
+	}, qt.PanicMatches, `ssoauthtest: cannot get caveat ID: no third party caveat addressed to discharger`)
+	c.Check(func() {
+		ssoauthtest.MustDischarge(other, m.M(), nil, time.Time{}, time.Time{})
+	}, qt.PanicMatches, `ssoauthtest: cannot discharge macaroon: no third party caveat addressed to discharger`)
+}
+
+func TestDischargerReset(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	key1 := d.PublicKey()
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: key1,
+		Location:  d.Location(),
+	})
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = ssoauthtest.Discharge(d, m.M(), nil, time.Time{}, time.Time{})
+	c.Assert(err, qt.IsNil)
+
+	d.Reset()
+	_, err = ssoauthtest.Discharge(d, m.M(), nil, time.Time{}, time.Time{})
+	c.Check(err, qt.ErrorMatches, `cannot decrypt secret`)
+
+	key2 := d.PublicKey()
+	c.Check(key2.Equal(key1), qt.IsFalse)
+	_, err = ssoauthtest.Discharge(d, m.M(), nil, time.Time{}, time.Time{})
+	c.Check(err, qt.ErrorMatches, `cannot decrypt secret: crypto/rsa: decryption error`)
+}
+
+func TestDischargerCounters(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	})
+	c.Check(d.PublicKeyCount(), qt.Equals, 1)
+	c.Check(d.DischargeCount(), qt.Equals, 0)
+
+	m, err := a.Macaroon(ctx)
+	c.Assert(err, qt.IsNil)
+	ssoauthtest.MustDischarge(d, m.M(), nil, time.Time{}, time.Time{})
+	ssoauthtest.MustDischarge(d, m.M(), nil, time.Time{}, time.Time{})
+	_, err = d.Discharge([]byte("{}"), nil, time.Time{}, time.Time{})
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Check(d.DischargeCount(), qt.Equals, 3)
+	c.Check(d.PublicKeyCount(), qt.Equals, 1)
+
+	d.ResetCounters()
+	c.Check(d.DischargeCount(), qt.Equals, 0)
+	c.Check(d.PublicKeyCount(), qt.Equals, 0)
+}
+
+// recordingTB is a testing.TB that records failures rather than
+// failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingTB) Helper() {}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestDischargerExpectCalls(t *testing.T) {
+	c := qt.New(t)
+
+	d := ssoauthtest.NewDischarger("login.example.com")
+	a := ssoauth.New(ssoauth.Params{
+		Oven:      bakery.NewOven(bakery.OvenParams{}),
+		PublicKey: d.PublicKey(),
+		Location:  d.Location(),
+	})
+	m, err := a.Macaroon(context.Background())
+	c.Assert(err, qt.IsNil)
+
+	tb := &recordingTB{TB: t}
+	d.ExpectCalls(tb, 0)
+	d.AssertNoExtraCalls(tb)
+	c.Check(tb.errors, qt.HasLen, 0)
+
+	ssoauthtest.MustDischarge(d, m.M(), nil, time.Time{}, time.Time{})
+	ssoauthtest.MustDischarge(d, m.M(), nil, time.Time{}, time.Time{})
+	d.ExpectCalls(tb, 2)
+	c.Check(tb.errors, qt.HasLen, 0)
+	d.ExpectCalls(tb, 1)
+	d.AssertNoExtraCalls(tb)
+	c.Check(tb.errors, qt.DeepEquals, []string{
+		"discharger called 2 times, want 1",
+		"discharger called 2 times since counters were reset, want 0",
+	})
+
+	tb.errors = nil
+	d.ResetCounters()
+	d.AssertNoExtraCalls(tb)
+	d.ExpectCalls(tb, 0)
+	c.Check(tb.errors, qt.HasLen, 0)
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// A Tracer is a trace.Tracer that records the spans that are started
+// with it, so that tests can check them. The zero value is ready to use.
+type Tracer struct {
+	noop.Tracer
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Start implements trace.Tracer.Start.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &Span{
+		name:       name,
+		attributes: cfg.Attributes(),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, s)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// Spans returns the spans started with the Tracer, in the order they
+// were started.
+func (t *Tracer) Spans() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Span(nil), t.spans...)
+}
+
+// A Span is a span recorded by a Tracer.
+type Span struct {
+	noop.Span
+
+	mu         sync.Mutex
+	name       string
+	attributes []attribute.KeyValue
+	status     codes.Code
+	ended      bool
+}
+
+// Name returns the name of the span.
+func (s *Span) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// Attribute returns the most recently set value of the attribute with
+// the given key. If the attribute has not been set an invalid Value is
+// returned.
+func (s *Span) Attribute(key attribute.Key) attribute.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.attributes) - 1; i >= 0; i-- {
+		if s.attributes[i].Key == key {
+			return s.attributes[i].Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// Status returns the status code most recently set on the span.
+func (s *Span) Status() codes.Code {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Ended reports whether End has been called on the span.
+func (s *Span) Ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ended
+}
+
+// SetName implements trace.Span.SetName.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes implements trace.Span.SetAttributes.
+func (s *Span) SetAttributes(kv ...attribute.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, kv...)
+}
+
+// SetStatus implements trace.Span.SetStatus.
+func (s *Span) SetStatus(code codes.Code, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+}
+
+// End implements trace.Span.End.
+func (s *Span) End(...trace.SpanEndOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+// IsRecording implements trace.Span.IsRecording.
+func (s *Span) IsRecording() bool {
+	return !s.Ended()
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ssoauthtest_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/canonical/ssoauth/ssoauthtest"
+)
+
+func TestTracer(t *testing.T) {
+	c := qt.New(t)
+
+	tracer := new(ssoauthtest.Tracer)
+	ctx, span := tracer.Start(context.Background(), "test", trace.WithAttributes(attribute.String("a", "1")))
+	c.Check(trace.SpanFromContext(ctx), qt.Equals, span)
+	span.SetAttributes(attribute.String("a", "2"), attribute.Int("b", 3))
+	span.SetStatus(codes.Error, "failed")
+	c.Check(span.IsRecording(), qt.IsTrue)
+	span.End()
+
+	spans := tracer.Spans()
+	c.Assert(spans, qt.HasLen, 1)
+	c.Check(spans[0].Name(), qt.Equals, "test")
+	c.Check(spans[0].Attribute("a").AsString(), qt.Equals, "2")
+	c.Check(spans[0].Attribute("b").AsInt64(), qt.Equals, int64(3))
+	c.Check(spans[0].Attribute("c").Type(), qt.Equals, attribute.INVALID)
+	c.Check(spans[0].Status(), qt.Equals, codes.Error)
+	c.Check(spans[0].Ended(), qt.IsTrue)
+	c.Check(spans[0].IsRecording(), qt.IsFalse)
+}
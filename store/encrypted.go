@@ -0,0 +1,76 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"gopkg.in/errgo.v1"
+)
+
+var _ TokenStore = EncryptedDirTokenStore{}
+
+// EncryptedDirTokenStore provides filesystem storage for arbitrary
+// tokens, keyed by URL, in which the tokens are encrypted using
+// AES-256-GCM.
+type EncryptedDirTokenStore struct {
+	// Dir holds the store used to hold encrypted tokens.
+	Dir DirTokenStore
+
+	// Key holds the key used to encrypt and decrypt tokens.
+	Key [32]byte
+}
+
+// Get retrieves the token stored for the given URL, if present. An
+// error is returned if the stored token cannot be decrypted with the
+// store's key.
+func (s EncryptedDirTokenStore) Get(ctx context.Context, url string) ([]byte, error) {
+	data, err := s.Dir.Get(ctx, url)
+	if err != nil || len(data) == 0 {
+		return nil, errgo.Mask(err)
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errgo.Newf("cannot decrypt token for %q: token too short", url)
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	token, err := aead.Open(nil, nonce, ciphertext, []byte(url))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decrypt token for %q", url)
+	}
+	return token, nil
+}
+
+// Set stores the given token for the given URL. The URL is
+// authenticated along with the token, so that a token stored for one
+// URL cannot be retrieved for another.
+func (s EncryptedDirTokenStore) Set(ctx context.Context, url string, token []byte) error {
+	if len(token) == 0 {
+		return errgo.Mask(s.Dir.Set(ctx, url, nil))
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(token)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return errgo.Mask(err)
+	}
+	data := aead.Seal(nonce, nonce, token, []byte(url))
+	return errgo.Mask(s.Dir.Set(ctx, url, data))
+}
+
+func (s EncryptedDirTokenStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.Key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
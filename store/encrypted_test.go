@@ -0,0 +1,71 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/store"
+)
+
+func TestEncryptedDirTokenStoreRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.Mkdir()
+	ts := store.EncryptedDirTokenStore{Dir: store.DirTokenStore(dir), Key: [32]byte{1, 2, 3}}
+	token, err := ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(token, qt.IsNil)
+
+	err = ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+	token, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(token), qt.Equals, "test-token")
+
+	// The token is not stored in plain text.
+	data, err := os.ReadFile(filepath.Join(dir, "https---example.com"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(bytes.Contains(data, []byte("test-token")), qt.IsFalse)
+
+	err = ts.Set(ctx, "https://example.com", nil)
+	c.Assert(err, qt.IsNil)
+	token, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(token, qt.IsNil)
+}
+
+func TestEncryptedDirTokenStoreWrongKey(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := store.DirTokenStore(c.Mkdir())
+	ts := store.EncryptedDirTokenStore{Dir: dir, Key: [32]byte{1}}
+	err := ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+
+	ts.Key = [32]byte{2}
+	_, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.ErrorMatches, `cannot decrypt token for "https://example.com": cipher: message authentication failed`)
+}
+
+func TestEncryptedDirTokenStoreCorrupt(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := store.DirTokenStore(c.Mkdir())
+	err := dir.Set(ctx, "https://example.com", []byte("short"))
+	c.Assert(err, qt.IsNil)
+
+	ts := store.EncryptedDirTokenStore{Dir: dir}
+	_, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.ErrorMatches, `cannot decrypt token for "https://example.com": token too short`)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+var _ TokenStore = EnvTokenStore{}
+
+// EnvTokenStore provides access to tokens, keyed by URL, held in
+// environment variables. This is useful, for example, in CI/CD
+// pipelines where tokens are injected into the environment rather than
+// written to files. The variable used for a URL is given by VarName.
+type EnvTokenStore struct {
+	// Prefix holds the prefix of the names of the environment
+	// variables holding tokens.
+	Prefix string
+
+	// Writable holds whether Set may modify the environment of the
+	// current process. If this is false the store is read-only.
+	Writable bool
+}
+
+// ErrReadOnly is the cause of the error returned from EnvTokenStore.Set
+// when the store is not writable.
+var ErrReadOnly = errgo.New("environment token store is read-only")
+
+// Get retrieves the token held in the environment variable for the
+// given URL, if it is set and not empty.
+func (s EnvTokenStore) Get(_ context.Context, url string) ([]byte, error) {
+	v := os.Getenv(s.VarName(url))
+	if v == "" {
+		return nil, nil
+	}
+	return []byte(v), nil
+}
+
+// Set sets the environment variable for the given URL to the given
+// token, or unsets it if the token is empty. If the store is not
+// Writable Set returns an error with a cause of ErrReadOnly.
+func (s EnvTokenStore) Set(_ context.Context, url string, token []byte) error {
+	name := s.VarName(url)
+	if !s.Writable {
+		return errgo.WithCausef(nil, ErrReadOnly, "cannot set %s: environment token store is read-only", name)
+	}
+	if len(token) == 0 {
+		return errgo.Mask(os.Unsetenv(name))
+	}
+	return errgo.Mask(os.Setenv(name, string(token)))
+}
+
+// VarName returns the name of the environment variable that holds the
+// token for the given URL. The name is the store's Prefix and the URL
+// joined with an underscore, with the URL upper-cased and every
+// character other than an ASCII letter or digit replaced with an
+// underscore. For example with a Prefix of "SSO" the token for
+// "https://login.example.com" is held in SSO_HTTPS___LOGIN_EXAMPLE_COM.
+func (s EnvTokenStore) VarName(url string) string {
+	sb := new(strings.Builder)
+	sb.Grow(len(s.Prefix) + 1 + len(url))
+	if s.Prefix != "" {
+		sb.WriteString(s.Prefix)
+		sb.WriteByte('_')
+	}
+	for _, c := range strings.ToUpper(url) {
+		if ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			sb.WriteByte(byte(c))
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
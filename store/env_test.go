@@ -0,0 +1,93 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth/store"
+)
+
+func TestEnvTokenStoreGet(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Setenv("SSO_HTTPS___LOGIN_EXAMPLE_COM", "test-token")
+	c.Setenv("SSO_HTTPS___LOGIN_STAGING_EXAMPLE_COM", "")
+
+	ts := store.EnvTokenStore{Prefix: "SSO"}
+	token, err := ts.Get(ctx, "https://login.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "test-token")
+
+	token, err = ts.Get(ctx, "https://login.staging.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(token, qt.IsNil)
+
+	token, err = ts.Get(ctx, "https://login.other.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(token, qt.IsNil)
+}
+
+var envVarNameTests = []struct {
+	prefix string
+	url    string
+	expect string
+}{{
+	prefix: "SSO",
+	url:    "https://login.example.com",
+	expect: "SSO_HTTPS___LOGIN_EXAMPLE_COM",
+}, {
+	prefix: "",
+	url:    "https://login.example.com:8443/path",
+	expect: "HTTPS___LOGIN_EXAMPLE_COM_8443_PATH",
+}, {
+	prefix: "MY_APP",
+	url:    "http://Example-1.com",
+	expect: "MY_APP_HTTP___EXAMPLE_1_COM",
+}}
+
+func TestEnvTokenStoreVarName(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range envVarNameTests {
+		ts := store.EnvTokenStore{Prefix: test.prefix}
+		c.Check(ts.VarName(test.url), qt.Equals, test.expect, qt.Commentf("%s %s", test.prefix, test.url))
+	}
+}
+
+func TestEnvTokenStoreSetReadOnly(t *testing.T) {
+	c := qt.New(t)
+	c.Setenv("SSO_HTTPS___LOGIN_EXAMPLE_COM", "test-token")
+
+	ts := store.EnvTokenStore{Prefix: "SSO"}
+	err := ts.Set(context.Background(), "https://login.example.com", []byte("new-token"))
+	c.Check(err, qt.ErrorMatches, `cannot set SSO_HTTPS___LOGIN_EXAMPLE_COM: environment token store is read-only`)
+	c.Check(errgo.Cause(err), qt.Equals, store.ErrReadOnly)
+	c.Check(os.Getenv("SSO_HTTPS___LOGIN_EXAMPLE_COM"), qt.Equals, "test-token")
+}
+
+func TestEnvTokenStoreSetWritable(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	// Setenv ensures the variable is restored at the end of the test.
+	c.Setenv("SSO_HTTPS___LOGIN_EXAMPLE_COM", "")
+
+	ts := store.EnvTokenStore{Prefix: "SSO", Writable: true}
+	err := ts.Set(ctx, "https://login.example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+	c.Check(os.Getenv("SSO_HTTPS___LOGIN_EXAMPLE_COM"), qt.Equals, "test-token")
+	token, err := ts.Get(ctx, "https://login.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "test-token")
+
+	err = ts.Set(ctx, "https://login.example.com", nil)
+	c.Assert(err, qt.IsNil)
+	_, ok := os.LookupEnv("SSO_HTTPS___LOGIN_EXAMPLE_COM")
+	c.Check(ok, qt.IsFalse)
+}
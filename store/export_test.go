@@ -0,0 +1,31 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// SetRename replaces the function used to move completed token files
+// into place and returns a function that restores the original.
+func SetRename(f func(oldpath, newpath string) error) (restore func()) {
+	old := rename
+	rename = f
+	return func() {
+		rename = old
+	}
+}
+
+// NewTTLTokenStoreWithClock is like NewTTLTokenStore except that the
+// current time is determined by calling now.
+func NewTTLTokenStoreWithClock(inner TokenStore, ttl time.Duration, now func() time.Time) TokenStore {
+	return newTTLTokenStore(inner, ttl, now)
+}
+
+// XDGTokenStoreForOS is like XDGTokenStore except that the directory is
+// chosen as it would be on the given operating system.
+func XDGTokenStoreForOS(appName, goos string) DirTokenStore {
+	return DirTokenStore(filepath.Join(dataHome(goos), appName))
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+var _ TokenStore = (*MemTokenStore)(nil)
+
+// MemTokenStore provides in-memory storage for arbitrary tokens, keyed
+// by URL. It is safe for concurrent use.
+type MemTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string][]byte
+}
+
+// NewMemTokenStore creates a new empty MemTokenStore.
+func NewMemTokenStore() *MemTokenStore {
+	return &MemTokenStore{
+		tokens: make(map[string][]byte),
+	}
+}
+
+// Get retrieves the token stored for the given URL, if present.
+func (s *MemTokenStore) Get(_ context.Context, url string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	token, ok := s.tokens[url]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), token...), nil
+}
+
+// Set stores the given token for the given URL.
+func (s *MemTokenStore) Set(_ context.Context, url string, token []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(token) == 0 {
+		delete(s.tokens, url)
+		return nil
+	}
+	s.tokens[url] = append([]byte(nil), token...)
+	return nil
+}
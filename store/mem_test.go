@@ -0,0 +1,58 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/store"
+)
+
+func TestMemTokenStoreRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	ts := store.NewMemTokenStore()
+	token, err := ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(token, qt.IsNil)
+
+	err = ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+	token, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(token), qt.Equals, "test-token")
+
+	err = ts.Set(ctx, "https://example.com", nil)
+	c.Assert(err, qt.IsNil)
+	token, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(token, qt.IsNil)
+}
+
+func TestMemTokenStoreConcurrentAccess(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	ts := store.NewMemTokenStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := fmt.Sprintf("https://%d.example.com", i%3)
+			err := ts.Set(ctx, url, []byte(url))
+			c.Check(err, qt.IsNil)
+			token, err := ts.Get(ctx, url)
+			c.Check(err, qt.IsNil)
+			c.Check(string(token), qt.Equals, url)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"context"
+	"errors"
+
+	"gopkg.in/errgo.v1"
+)
+
+var _ TokenStore = MultiTokenStore(nil)
+
+// MultiTokenStore is a TokenStore that combines a chain of TokenStores.
+// Tokens are read from the first store in the chain that holds one and
+// are written to every store in the chain. This is useful, for example,
+// when migrating tokens from one store to another.
+type MultiTokenStore []TokenStore
+
+// NewMultiTokenStore creates a MultiTokenStore that reads from primary,
+// falling back to fallback if primary holds no token.
+func NewMultiTokenStore(primary, fallback TokenStore) *MultiTokenStore {
+	return &MultiTokenStore{primary, fallback}
+}
+
+// Get retrieves the token stored for the given URL from the first store
+// in the chain that holds one. Later stores are not consulted once a
+// token has been found. If any store returns an error Get returns that
+// error.
+func (s MultiTokenStore) Get(ctx context.Context, url string) ([]byte, error) {
+	for _, ts := range s {
+		token, err := ts.Get(ctx, url)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+		if len(token) > 0 {
+			return token, nil
+		}
+	}
+	return nil, nil
+}
+
+// Set stores the given token for the given URL in every store in the
+// chain. Set attempts to update every store even if some fail, the
+// returned error describes all the failures.
+func (s MultiTokenStore) Set(ctx context.Context, url string, token []byte) error {
+	var errs []error
+	for _, ts := range s {
+		if err := ts.Set(ctx, url, token); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errgo.Notef(errors.Join(errs...), "cannot store token")
+	}
+	return nil
+}
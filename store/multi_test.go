@@ -0,0 +1,118 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/ssoauth/store"
+)
+
+func TestMultiTokenStoreGetPrimary(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	primary := store.NewMemTokenStore()
+	err := primary.Set(ctx, "https://example.com", []byte("primary-token"))
+	c.Assert(err, qt.IsNil)
+	fallback := &countingTokenStore{TokenStore: store.NewMemTokenStore()}
+
+	ts := store.NewMultiTokenStore(primary, fallback)
+	token, err := ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "primary-token")
+	c.Check(fallback.gets, qt.Equals, 0)
+}
+
+func TestMultiTokenStoreGetFallback(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	fallback := store.NewMemTokenStore()
+	err := fallback.Set(ctx, "https://example.com", []byte("fallback-token"))
+	c.Assert(err, qt.IsNil)
+
+	ts := store.NewMultiTokenStore(store.NewMemTokenStore(), fallback)
+	token, err := ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "fallback-token")
+
+	token, err = ts.Get(ctx, "https://example.org")
+	c.Assert(err, qt.IsNil)
+	c.Check(token, qt.IsNil)
+}
+
+func TestMultiTokenStoreGetError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	ts := store.MultiTokenStore{errorTokenStore{errgo.New("test error")}, store.NewMemTokenStore()}
+	_, err := ts.Get(ctx, "https://example.com")
+	c.Check(err, qt.ErrorMatches, `test error`)
+}
+
+func TestMultiTokenStoreSet(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	s1 := store.NewMemTokenStore()
+	s2 := store.NewMemTokenStore()
+	ts := store.NewMultiTokenStore(s1, s2)
+	err := ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+	for _, s := range []*store.MemTokenStore{s1, s2} {
+		token, err := s.Get(ctx, "https://example.com")
+		c.Assert(err, qt.IsNil)
+		c.Check(string(token), qt.Equals, "test-token")
+	}
+
+	err = ts.Set(ctx, "https://example.com", nil)
+	c.Assert(err, qt.IsNil)
+	for _, s := range []*store.MemTokenStore{s1, s2} {
+		token, err := s.Get(ctx, "https://example.com")
+		c.Assert(err, qt.IsNil)
+		c.Check(token, qt.IsNil)
+	}
+}
+
+func TestMultiTokenStoreSetError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	s := store.NewMemTokenStore()
+	ts := store.MultiTokenStore{errorTokenStore{errgo.New("test error")}, s}
+	err := ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Check(err, qt.ErrorMatches, `cannot store token: test error`)
+
+	// The token is still written to the stores that did not fail.
+	token, err := s.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "test-token")
+}
+
+type countingTokenStore struct {
+	store.TokenStore
+	gets int
+}
+
+func (s *countingTokenStore) Get(ctx context.Context, url string) ([]byte, error) {
+	s.gets++
+	return s.TokenStore.Get(ctx, url)
+}
+
+type errorTokenStore struct {
+	err error
+}
+
+func (s errorTokenStore) Get(context.Context, string) ([]byte, error) {
+	return nil, s.err
+}
+
+func (s errorTokenStore) Set(context.Context, string, []byte) error {
+	return s.err
+}
@@ -5,44 +5,282 @@ package store
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"gopkg.in/errgo.v1"
 )
 
+// A TokenStore stores arbitrary tokens, keyed by URL.
+type TokenStore interface {
+	// Get retrieves the token stored for the given URL. If there is
+	// no such token Get returns a nil token and no error.
+	Get(ctx context.Context, url string) ([]byte, error)
+
+	// Set stores the given token for the given URL. Setting an empty
+	// token removes any stored token.
+	Set(ctx context.Context, url string, token []byte) error
+}
+
+var _ TokenStore = DirTokenStore("")
+
 // DirTokenStore provides filesystem storage for arbitrary tokens, keyed by
 // URL. The value of the DirTokenStore is the directory in which the tokens
 // are stored, if this directory does not exist it will be created when
 // required.
 type DirTokenStore string
 
-// Get retrieves the token stored for the given URL, if present.
+// Get retrieves the token stored for the given URL, if present. If the
+// file used for the given URL holds a token for a different URL, as
+// recorded by Set, then Get returns a nil token and no error.
 func (s DirTokenStore) Get(_ context.Context, url string) ([]byte, error) {
-	path := filepath.Join(string(s), filenameForURL(url))
-	b, err := ioutil.ReadFile(path)
+	name := filenameForURL(url)
+	storedURL, err := ioutil.ReadFile(s.urlPath(name))
+	switch {
+	case err == nil:
+		if string(storedURL) != url {
+			return nil, nil
+		}
+	case os.IsNotExist(err):
+		// Tokens stored before URLs were recorded have no
+		// companion file.
+	default:
+		return nil, errgo.Mask(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(string(s), name))
 	if err != nil && os.IsNotExist(err) {
 		err = nil
 	}
 	return b, errgo.Mask(err)
 }
 
-// Set stores the given token for the given URL.
+// ErrURLCollision is the cause of the error returned from
+// DirTokenStore.Set when the file used for the given URL already holds a
+// token for a different URL.
+var ErrURLCollision = errgo.New("token file holds a token for a different URL")
+
+// Set stores the given token for the given URL. The URL is recorded in a
+// companion file so that a URL that maps to the same filename as one
+// that already has a token stored is detected. In that case Set returns
+// an error with a cause of ErrURLCollision rather than overwrite the
+// existing token.
 func (s DirTokenStore) Set(_ context.Context, url string, token []byte) error {
-	path := filepath.Join(string(s), filenameForURL(url))
-	if len(token) == 0 {
-		err := os.Remove(path)
-		if err != nil && os.IsNotExist(err) {
-			err = nil
+	name := filenameForURL(url)
+	path := filepath.Join(string(s), name)
+	urlPath := s.urlPath(name)
+	storedURL, err := ioutil.ReadFile(urlPath)
+	switch {
+	case err == nil:
+		if string(storedURL) != url {
+			return errgo.WithCausef(nil, ErrURLCollision, "cannot store token for %q: file already holds a token for %q", url, storedURL)
 		}
+	case os.IsNotExist(err):
+	case len(token) > 0:
 		return errgo.Mask(err)
 	}
+	hasURL := err == nil
+	if len(token) == 0 {
+		for _, p := range []string{path, urlPath} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return errgo.Mask(err)
+			}
+		}
+		return nil
+	}
 	if err := os.MkdirAll(string(s), 0700); err != nil {
 		return errgo.Mask(err)
 	}
-	return errgo.Mask(ioutil.WriteFile(path, token, 0600))
+	if !hasURL {
+		if err := writeFileAtomic(urlPath, []byte(url)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return errgo.Mask(writeFileAtomic(path, token))
+}
+
+// List returns the URLs for which tokens are stored, as recorded by Set.
+// Tokens stored without a record of their URL have the URL derived from
+// the filename, which cannot always be reversed: every character that is
+// not a letter, digit, '.', '_' or '-' is replaced by '-'. A filename of
+// the form "scheme---rest", where rest contains no further '-', is
+// returned as the URL "scheme://rest". Any other filename is ambiguous
+// and is returned unchanged. If the store directory does not exist List
+// returns no URLs and no error.
+func (s DirTokenStore) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(string(s))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errgo.Mask(err)
+	}
+	var urls []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			// Skip anything that cannot be a token, including
+			// temporary files left by an interrupted Set.
+			continue
+		}
+		url, err := ioutil.ReadFile(s.urlPath(e.Name()))
+		switch {
+		case err == nil:
+			urls = append(urls, string(url))
+		case os.IsNotExist(err):
+			urls = append(urls, urlForFilename(e.Name()))
+		default:
+			return nil, errgo.Mask(err)
+		}
+	}
+	return urls, nil
+}
+
+// urlPath returns the path of the companion file that records the URL
+// of the token stored in the file with the given name.
+func (s DirTokenStore) urlPath(name string) string {
+	return filepath.Join(string(s), "."+name+".url")
+}
+
+// Clear removes all files from the store directory, leaving the
+// directory itself in place. If the store directory does not exist Clear
+// does nothing. Clear attempts to remove every file even if some cannot
+// be removed, the returned error describes all the failures.
+func (s DirTokenStore) Clear(_ context.Context) error {
+	entries, err := os.ReadDir(string(s))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errgo.Mask(err)
+	}
+	var errs []error
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(string(s), e.Name())); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errgo.Notef(errors.Join(errs...), "cannot clear token store")
+	}
+	return nil
+}
+
+// CheckPermissions checks that the store directory is accessible only
+// by its owner (mode 0700 or narrower) and that each file within it is
+// readable and writable only by its owner (mode 0600 or narrower). The
+// returned error lists every file with broader permissions. If the store
+// directory does not exist CheckPermissions returns no error.
+func (s DirTokenStore) CheckPermissions(_ context.Context) error {
+	var errs []error
+	err := s.walkPermissions(func(path string, mode, want os.FileMode) error {
+		errs = append(errs, errgo.Newf("%s has mode %#o, want %#o", path, mode, want))
+		return nil
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if len(errs) > 0 {
+		return errgo.Notef(errors.Join(errs...), "insecure token store permissions")
+	}
+	return nil
+}
+
+// FixPermissions removes any permissions reported by CheckPermissions
+// from the store directory and the files within it.
+func (s DirTokenStore) FixPermissions(_ context.Context) error {
+	return errgo.Mask(s.walkPermissions(func(path string, mode, want os.FileMode) error {
+		return os.Chmod(path, mode&want)
+	}))
+}
+
+// walkPermissions calls f for the store directory and each regular file
+// within it that has permissions other than those in want.
+func (s DirTokenStore) walkPermissions(f func(path string, mode, want os.FileMode) error) error {
+	info, err := os.Stat(string(s))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if mode := info.Mode().Perm(); mode&^0700 != 0 {
+		if err := f(string(s), mode, 0700); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(string(s))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if mode := info.Mode().Perm(); mode&^0600 != 0 {
+			if err := f(filepath.Join(string(s), e.Name()), mode, 0600); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rename is used to move a completed temporary file into place. It is a
+// variable so that tests can simulate failures.
+var rename = os.Rename
+
+// writeFileAtomic writes the given data to the file at path such that
+// the file either contains all of the data or is left unchanged. The
+// data is written to a temporary file in the same directory which is
+// synced to disk and then renamed to path. The directory is then synced
+// so that the rename survives a crash.
+func writeFileAtomic(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	// CreateTemp creates the file with mode 0600, as required.
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the directory entries of the given directory to disk.
+// Directories cannot be synced on Windows, so syncDir does nothing
+// there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 func filenameForURL(url string) string {
@@ -60,3 +298,18 @@ func filenameForURL(url string) string {
 	}
 	return sb.String()
 }
+
+// urlForFilename attempts to reverse filenameForURL. If the URL cannot
+// be determined unambiguously the filename is returned unchanged.
+func urlForFilename(name string) string {
+	scheme, rest, ok := strings.Cut(name, "---")
+	if !ok || scheme == "" || rest == "" || strings.Contains(rest, "-") {
+		return name
+	}
+	for _, c := range scheme {
+		if !('A' <= c && c <= 'Z') && !('a' <= c && c <= 'z') {
+			return name
+		}
+	}
+	return scheme + "://" + rest
+}
@@ -6,10 +6,12 @@ package store_test
 import (
 	"context"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
 
 	"github.com/canonical/ssoauth/store"
 )
@@ -59,3 +61,212 @@ func TestSetWhenDoesNotExistIsOK(t *testing.T) {
 	err := ts.Set(context.Background(), "foo", []byte{})
 	c.Assert(err, qt.ErrorMatches, `remove /etc/passwd/foo: not a directory`)
 }
+
+func TestSetInterruptedLeavesOriginal(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+	ts := store.DirTokenStore(dir)
+	err := ts.Set(context.Background(), "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+
+	c.Cleanup(store.SetRename(func(string, string) error {
+		return errgo.New("interrupted")
+	}))
+	err = ts.Set(context.Background(), "https://example.com", []byte("new-token"))
+	c.Assert(err, qt.ErrorMatches, `interrupted`)
+
+	token, err := ts.Get(context.Background(), "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(token), qt.Equals, "test-token")
+
+	// The temporary file has been removed, leaving only the token and
+	// its URL.
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 2)
+}
+
+func TestSetFileMode(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+	ts := store.DirTokenStore(dir)
+	err := ts.Set(context.Background(), "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 2)
+	for _, e := range entries {
+		info, err := e.Info()
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0600), qt.Commentf("%s", e.Name()))
+	}
+}
+
+func TestList(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.Mkdir()
+	ts := store.DirTokenStore(dir)
+
+	err := ts.Set(ctx, "https://example.com", []byte("token1"))
+	c.Assert(err, qt.IsNil)
+	err = ts.Set(ctx, "https://api.example.com:8443/v1", []byte("token2"))
+	c.Assert(err, qt.IsNil)
+	// Temporary files and directories are not tokens.
+	err = os.WriteFile(filepath.Join(dir, ".https---example.com.tmp123"), []byte("x"), 0600)
+	c.Assert(err, qt.IsNil)
+	err = os.Mkdir(filepath.Join(dir, "subdir"), 0700)
+	c.Assert(err, qt.IsNil)
+	// A token stored without a record of its URL has the URL derived
+	// from its filename.
+	err = os.WriteFile(filepath.Join(dir, "https---legacy.example.com"), []byte("token3"), 0600)
+	c.Assert(err, qt.IsNil)
+
+	urls, err := ts.List(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Check(urls, qt.DeepEquals, []string{
+		"https://api.example.com:8443/v1",
+		"https://example.com",
+		"https://legacy.example.com",
+	})
+}
+
+func TestGetURLCollision(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	ts := store.DirTokenStore(c.Mkdir())
+
+	err := ts.Set(ctx, "https://example.com/a", []byte("token1"))
+	c.Assert(err, qt.IsNil)
+
+	// The URL maps to the same file, which holds a token for a
+	// different URL.
+	token, err := ts.Get(ctx, "https://example.com?a")
+	c.Assert(err, qt.IsNil)
+	c.Check(token, qt.IsNil)
+
+	token, err = ts.Get(ctx, "https://example.com/a")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "token1")
+}
+
+func TestListWhenDoesNotExistIsOK(t *testing.T) {
+	c := qt.New(t)
+	ts := store.DirTokenStore(filepath.Join(c.Mkdir(), "missing"))
+	urls, err := ts.List(context.Background())
+	c.Assert(err, qt.IsNil)
+	c.Check(urls, qt.HasLen, 0)
+}
+
+func TestClear(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.Mkdir()
+	ts := store.DirTokenStore(dir)
+
+	err := ts.Set(ctx, "https://example.com", []byte("token1"))
+	c.Assert(err, qt.IsNil)
+	err = ts.Set(ctx, "https://example.org", []byte("token2"))
+	c.Assert(err, qt.IsNil)
+
+	err = ts.Clear(ctx)
+	c.Assert(err, qt.IsNil)
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Check(entries, qt.HasLen, 0)
+}
+
+func TestClearWhenDoesNotExistIsOK(t *testing.T) {
+	c := qt.New(t)
+	ts := store.DirTokenStore(filepath.Join(c.Mkdir(), "missing"))
+	err := ts.Clear(context.Background())
+	c.Assert(err, qt.IsNil)
+}
+
+func TestClearPartialFailure(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.Mkdir()
+	ts := store.DirTokenStore(dir)
+
+	err := ts.Set(ctx, "https://example.com", []byte("token1"))
+	c.Assert(err, qt.IsNil)
+	// A non-empty directory cannot be removed.
+	err = os.MkdirAll(filepath.Join(dir, "subdir", "nested"), 0700)
+	c.Assert(err, qt.IsNil)
+
+	err = ts.Clear(ctx)
+	c.Assert(err, qt.ErrorMatches, `cannot clear token store: remove .*/subdir: directory not empty`)
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Check(entries[0].Name(), qt.Equals, "subdir")
+}
+
+func TestSetURLCollision(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	ts := store.DirTokenStore(c.Mkdir())
+
+	err := ts.Set(ctx, "https://example.com/a/b", []byte("token1"))
+	c.Assert(err, qt.IsNil)
+	// Setting the same URL again is fine.
+	err = ts.Set(ctx, "https://example.com/a/b", []byte("token2"))
+	c.Assert(err, qt.IsNil)
+
+	err = ts.Set(ctx, "https://example.com/a-b", []byte("token3"))
+	c.Check(err, qt.ErrorMatches, `cannot store token for "https://example.com/a-b": file already holds a token for "https://example.com/a/b"`)
+	c.Check(errgo.Cause(err), qt.Equals, store.ErrURLCollision)
+	err = ts.Set(ctx, "https://example.com/a-b", nil)
+	c.Check(errgo.Cause(err), qt.Equals, store.ErrURLCollision)
+
+	token, err := ts.Get(ctx, "https://example.com/a/b")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "token2")
+
+	// Once the token is removed the filename may be used by another URL.
+	err = ts.Set(ctx, "https://example.com/a/b", nil)
+	c.Assert(err, qt.IsNil)
+	err = ts.Set(ctx, "https://example.com/a-b", []byte("token3"))
+	c.Assert(err, qt.IsNil)
+}
+
+func TestCheckPermissions(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.Mkdir()
+	ts := store.DirTokenStore(dir)
+
+	err := ts.Set(ctx, "https://example.com", []byte("token1"))
+	c.Assert(err, qt.IsNil)
+	err = os.Chmod(dir, 0700)
+	c.Assert(err, qt.IsNil)
+	err = ts.CheckPermissions(ctx)
+	c.Assert(err, qt.IsNil)
+
+	err = os.Chmod(dir, 0755)
+	c.Assert(err, qt.IsNil)
+	err = os.Chmod(filepath.Join(dir, "https---example.com"), 0644)
+	c.Assert(err, qt.IsNil)
+	err = ts.CheckPermissions(ctx)
+	c.Check(err, qt.ErrorMatches, `insecure token store permissions: .* has mode 0755, want 0700
+.*/https---example.com has mode 0644, want 0600`)
+
+	err = ts.FixPermissions(ctx)
+	c.Assert(err, qt.IsNil)
+	err = ts.CheckPermissions(ctx)
+	c.Assert(err, qt.IsNil)
+	info, err := os.Stat(filepath.Join(dir, "https---example.com"))
+	c.Assert(err, qt.IsNil)
+	c.Check(info.Mode().Perm(), qt.Equals, os.FileMode(0600))
+}
+
+func TestCheckPermissionsWhenDoesNotExistIsOK(t *testing.T) {
+	c := qt.New(t)
+	ts := store.DirTokenStore(filepath.Join(c.Mkdir(), "missing"))
+	err := ts.CheckPermissions(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = ts.FixPermissions(context.Background())
+	c.Assert(err, qt.IsNil)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// NewTTLTokenStore creates a TokenStore that stores tokens in inner
+// along with an expiry time the given duration after they were set.
+// Expired tokens are not returned from Get. Tokens in inner that were
+// not stored by a TTL token store are returned unchanged.
+func NewTTLTokenStore(inner TokenStore, ttl time.Duration) TokenStore {
+	return newTTLTokenStore(inner, ttl, time.Now)
+}
+
+func newTTLTokenStore(inner TokenStore, ttl time.Duration, now func() time.Time) *ttlTokenStore {
+	return &ttlTokenStore{
+		inner: inner,
+		ttl:   ttl,
+		now:   now,
+	}
+}
+
+// ttlTokenStore is the TokenStore implementation returned from
+// NewTTLTokenStore.
+type ttlTokenStore struct {
+	inner TokenStore
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// ttlToken is the form in which tokens are written to the inner store.
+type ttlToken struct {
+	Token     []byte    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Get implements TokenStore.Get.
+func (s *ttlTokenStore) Get(ctx context.Context, url string) ([]byte, error) {
+	data, err := s.inner.Get(ctx, url)
+	if err != nil || len(data) == 0 {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	var t ttlToken
+	if err := json.Unmarshal(data, &t); err != nil || len(t.Token) == 0 || t.ExpiresAt.IsZero() {
+		// This token was not written by a ttlTokenStore.
+		return data, nil
+	}
+	if !s.now().Before(t.ExpiresAt) {
+		return nil, nil
+	}
+	return t.Token, nil
+}
+
+// Set implements TokenStore.Set.
+func (s *ttlTokenStore) Set(ctx context.Context, url string, token []byte) error {
+	if len(token) == 0 {
+		return errgo.Mask(s.inner.Set(ctx, url, nil), errgo.Any)
+	}
+	data, err := json.Marshal(ttlToken{
+		Token:     token,
+		ExpiresAt: s.now().Add(s.ttl).UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(s.inner.Set(ctx, url, data), errgo.Any)
+}
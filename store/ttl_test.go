@@ -0,0 +1,85 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/store"
+)
+
+func TestTTLTokenStore(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	inner := store.NewMemTokenStore()
+	ts := store.NewTTLTokenStoreWithClock(inner, time.Hour, func() time.Time { return now })
+
+	err := ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+
+	data, err := inner.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	var stored map[string]string
+	err = json.Unmarshal(data, &stored)
+	c.Assert(err, qt.IsNil)
+	c.Check(stored, qt.DeepEquals, map[string]string{
+		"token":      "dGVzdC10b2tlbg==",
+		"expires_at": "2020-01-01T01:00:00Z",
+	})
+
+	now = now.Add(59 * time.Minute)
+	token, err := ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "test-token")
+
+	now = now.Add(time.Minute)
+	token, err = ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(token, qt.IsNil)
+}
+
+func TestTTLTokenStoreUnwrappedToken(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	inner := store.NewMemTokenStore()
+	err := inner.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+
+	ts := store.NewTTLTokenStore(inner, time.Hour)
+	token, err := ts.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(token), qt.Equals, "test-token")
+}
+
+func TestTTLTokenStoreRemove(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	inner := store.NewMemTokenStore()
+	ts := store.NewTTLTokenStore(inner, time.Hour)
+	err := ts.Set(ctx, "https://example.com", []byte("test-token"))
+	c.Assert(err, qt.IsNil)
+	err = ts.Set(ctx, "https://example.com", nil)
+	c.Assert(err, qt.IsNil)
+
+	data, err := inner.Get(ctx, "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(data, qt.IsNil)
+}
+
+func TestTTLTokenStoreMissing(t *testing.T) {
+	c := qt.New(t)
+	ts := store.NewTTLTokenStore(store.NewMemTokenStore(), time.Hour)
+	token, err := ts.Get(context.Background(), "https://example.com")
+	c.Assert(err, qt.IsNil)
+	c.Check(token, qt.IsNil)
+}
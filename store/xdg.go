@@ -0,0 +1,49 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// XDGTokenStore returns a DirTokenStore in the conventional location for
+// application data belonging to the given application on the current
+// platform. On macOS this is "~/Library/Application Support/{appName}"
+// and on Windows "%APPDATA%\{appName}". On other platforms the XDG Base
+// Directory Specification is followed, so the directory is
+// "$XDG_DATA_HOME/{appName}", or "~/.local/share/{appName}" if
+// XDG_DATA_HOME is not set to an absolute path.
+func XDGTokenStore(appName string) DirTokenStore {
+	return DirTokenStore(filepath.Join(dataHome(runtime.GOOS), appName))
+}
+
+// dataHome returns the base directory for application data on the given
+// operating system.
+func dataHome(goos string) string {
+	switch goos {
+	case "darwin", "ios":
+		return filepath.Join(homeDir(), "Library", "Application Support")
+	case "windows":
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return dir
+		}
+		return filepath.Join(homeDir(), "AppData", "Roaming")
+	default:
+		// The specification requires relative paths to be
+		// ignored.
+		if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(homeDir(), ".local", "share")
+	}
+}
+
+// homeDir returns the current user's home directory, or the empty
+// string, and so the current directory, if it cannot be determined.
+func homeDir() string {
+	dir, _ := os.UserHomeDir()
+	return dir
+}
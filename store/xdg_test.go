@@ -0,0 +1,76 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package store_test
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/ssoauth/store"
+)
+
+var xdgTokenStoreTests = []struct {
+	name string
+	goos string
+	env  map[string]string
+	// expectDir holds the expected directory, relative to the home
+	// directory if it is not absolute.
+	expectDir string
+}{{
+	name:      "xdg-data-home",
+	goos:      "linux",
+	env:       map[string]string{"XDG_DATA_HOME": "/xdg/data"},
+	expectDir: "/xdg/data/myapp",
+}, {
+	name:      "xdg-data-home-unset",
+	goos:      "linux",
+	env:       map[string]string{"XDG_DATA_HOME": ""},
+	expectDir: ".local/share/myapp",
+}, {
+	name:      "xdg-data-home-relative",
+	goos:      "freebsd",
+	env:       map[string]string{"XDG_DATA_HOME": "relative/data"},
+	expectDir: ".local/share/myapp",
+}, {
+	name:      "darwin",
+	goos:      "darwin",
+	env:       map[string]string{"XDG_DATA_HOME": "/xdg/data"},
+	expectDir: "Library/Application Support/myapp",
+}, {
+	name:      "windows",
+	goos:      "windows",
+	env:       map[string]string{"APPDATA": "/appdata"},
+	expectDir: "/appdata/myapp",
+}}
+
+func TestXDGTokenStoreForOS(t *testing.T) {
+	c := qt.New(t)
+	home := c.Mkdir()
+	c.Setenv("HOME", home)
+	for _, test := range xdgTokenStoreTests {
+		c.Run(test.name, func(c *qt.C) {
+			for k, v := range test.env {
+				c.Setenv(k, v)
+			}
+			expect := filepath.FromSlash(test.expectDir)
+			if !filepath.IsAbs(expect) {
+				expect = filepath.Join(home, expect)
+			}
+			c.Check(store.XDGTokenStoreForOS("myapp", test.goos), qt.Equals, store.DirTokenStore(expect))
+		})
+	}
+}
+
+func TestXDGTokenStore(t *testing.T) {
+	c := qt.New(t)
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" || runtime.GOOS == "windows" {
+		c.Skip("XDG_DATA_HOME is not used on " + runtime.GOOS)
+	}
+	dir := c.Mkdir()
+	c.Setenv("XDG_DATA_HOME", dir)
+	c.Check(store.XDGTokenStore("myapp"), qt.Equals, store.DirTokenStore(filepath.Join(dir, "myapp")))
+}